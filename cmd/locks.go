@@ -0,0 +1,97 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/capitalone/stack-deployment-tool/locks"
+	"github.com/capitalone/stack-deployment-tool/stacks"
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var lockEnv string
+
+var locksCmd = &cobra.Command{
+	Use:   "locks",
+	Short: "Environment lock commands",
+	Long:  "List and break the environment locks held while deploying and deleting stacks",
+}
+
+var locksListCmd = &cobra.Command{
+	Use:   "list [stack_config.yml]",
+	Short: "List the environment locks",
+	Long:  "List the environment locks of the locks store in the stacks config",
+	Run: func(cmd *cobra.Command, args []string) {
+		ValidateArgLen(1, args, "stacks config file required")
+		backend := lockBackend(args[0])
+		held, err := backend.List()
+		if err != nil {
+			log.Fatalf("Error listing locks: %s %v", backend, err)
+		}
+		tbl := utils.NewTableWriter(os.Stdout, 15, 40, 25, 22, 22, 8)
+		tbl.WriteHeader("Environment", "Owner", "Host", "Acquired", "Expires", "Expired")
+		for _, l := range held {
+			tbl.WriteRow(l.Key, l.Owner, fmt.Sprintf("%s (%d)", l.Host, l.Pid), l.Acquired.Format(time.RFC3339),
+				l.Expires.Format(time.RFC3339), fmt.Sprint(l.Expired()))
+		}
+		tbl.Footer()
+	},
+}
+
+var locksBreakCmd = &cobra.Command{
+	Use:   "break [stack_config.yml]",
+	Short: "Break the lock on an environment",
+	Long:  "Remove the lock on an environment whoever holds it, i.e. after a deploy was killed",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(lockEnv) == 0 {
+			log.Fatalf("specify stack option: -s <environment>")
+		}
+		ValidateArgLen(1, args, "stacks config file required")
+		backend := lockBackend(args[0])
+		if err := backend.Break(lockEnv); err != nil {
+			log.Fatalf("Error breaking lock: %s %v", lockEnv, err)
+		}
+		log.Infof("Broke lock: %s", lockEnv)
+	},
+}
+
+func lockBackend(configFile string) locks.Backend {
+	conf := stacks.NewConfig(configFile, StacksApi())
+	backend, err := conf.LockBackend()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if backend == nil {
+		log.Fatalf("no locks configured in: %s", configFile)
+	}
+	return backend
+}
+
+func init() {
+	locksCmd.AddCommand(locksListCmd)
+	locksCmd.AddCommand(locksBreakCmd)
+	RootCmd.AddCommand(locksCmd)
+
+	locksBreakCmd.PersistentFlags().StringVarP(&lockEnv, "stacks", "s", "", "<environment> to unlock")
+}
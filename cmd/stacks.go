@@ -18,7 +18,11 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/capitalone/stack-deployment-tool/stacks"
 	"github.com/capitalone/stack-deployment-tool/utils"
@@ -28,9 +32,20 @@ import (
 )
 
 var (
-	stacksRef string
-	process   bool
-	api       stacks.StackApi
+	stacksRef    string
+	process      bool
+	graphFormat  string
+	graphStatus  bool
+	selection    stacks.StackSelection
+	changedSince string
+	deployOpts   stacks.DeployOptions
+	rollbackTo   string
+	packageOut   string
+	specPath     string
+	resolveOuts  bool
+	noColor      bool
+	renderOut    string
+	api          stacks.StackApi
 )
 
 // stacksCmd represents the stacks command
@@ -51,7 +66,7 @@ var stacksTemplateCmd = &cobra.Command{
 		var result interface{}
 		if len(stacksRef) > 0 {
 			log.Debugf("stacksRef: %+v", stacksRef)
-			item := conf.FetchEnvStacks(stacksRef)
+			item := fetchEnvStacks(conf)
 			result = item.FetchAll()
 		} else if process {
 			result = conf.FetchAll()
@@ -74,7 +89,8 @@ var stacksCreateOrUpdateCmd = &cobra.Command{
 		}
 		ValidateArgLen(1, args, "stacks config file required")
 		conf := stacks.NewConfig(args[0], StacksApi())
-		item := conf.FetchEnvStacks(stacksRef)
+		item := fetchEnvStacks(conf)
+		StacksApi().DeployOptions(deployOpts)
 		StacksApi().CreateOrUpdateStacks(item)
 	},
 }
@@ -89,8 +105,12 @@ var stacksDeleteCmd = &cobra.Command{
 			log.Fatalf("specify stack option: -s <environment>.<stack name> or <environment>[<stack name>, ...]")
 		}
 		ValidateArgLen(1, args, "stacks config file required")
+		if selection.WithDeps {
+			log.Fatalf("delete doesnt take --with-deps, the stacks the selected stacks depend on are not deleted (use --downstream to delete the stacks depending on them)")
+		}
 		conf := stacks.NewConfig(args[0], StacksApi())
-		item := conf.FetchEnvStacks(stacksRef)
+		item := fetchEnvStacks(conf)
+		StacksApi().DeployOptions(deployOpts)
 		StacksApi().DeleteStacks(item)
 	},
 }
@@ -105,7 +125,7 @@ var stacksStatusCmd = &cobra.Command{
 		}
 		ValidateArgLen(1, args, "stacks config file required")
 		conf := stacks.NewConfig(args[0], StacksApi())
-		item := conf.FetchEnvStacks(stacksRef)
+		item := fetchEnvStacks(conf)
 		StacksApi().StacksStatus(item)
 	},
 }
@@ -120,11 +140,229 @@ var stacksChangesCmd = &cobra.Command{
 		}
 		ValidateArgLen(1, args, "stacks config file required")
 		conf := stacks.NewConfig(args[0], StacksApi())
-		item := conf.FetchEnvStacks(stacksRef)
+		item := fetchEnvStacks(conf)
 		StacksApi().PrintChangesToStacks(item)
 	},
 }
 
+var stacksPackageCmd = &cobra.Command{
+	Use:   "package [stack_config.yml]",
+	Short: "Package the local files referenced by cloudformation templates",
+	Long: `Package the local files referenced by the templates of a set of cloudformation stacks: nested stack templates
+and lambda code are uploaded to the template_bucket, and the templates point at them. Deploy packages templates automatically.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(stacksRef) == 0 {
+			log.Fatalf("specify stack option: -s <environment>.<stack name> or <environment>[<stack name>, ...]")
+		}
+		ValidateArgLen(1, args, "stacks config file required")
+		conf := stacks.NewConfig(args[0], StacksApi())
+		item := fetchEnvStacks(conf)
+		StacksApi().PackageStacks(item, packageOut)
+	},
+}
+
+var stacksValidateCmd = &cobra.Command{
+	Use:   "validate [stack_config.yml]",
+	Short: "Validate the templates of cloudformation stacks offline",
+	Long: `Validate the rendered templates of an environment, or of all environments, without AWS credentials:
+resource types, properties and their types against a resource specification, and Ref, Fn::GetAtt and Fn::Sub references`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ValidateArgLen(1, args, "stacks config file required")
+		// no output finder, outputs and imports of deployed stacks render empty
+		conf := stacks.NewConfig(args[0], nil)
+		envs := []*stacks.EnvStacksConfig{}
+		if len(stacksRef) > 0 {
+			envs = append(envs, fetchEnvStacks(conf))
+		} else {
+			for _, env := range conf.EnvNames() {
+				envs = append(envs, conf.FetchEnvStacks(env))
+			}
+		}
+
+		if len(specPath) == 0 {
+			specPath = conf.ResourceSpecPath()
+		}
+		var spec *stacks.ResourceSpec
+		if len(specPath) > 0 {
+			var err error
+			if spec, err = stacks.LoadResourceSpec(specPath); err != nil {
+				log.Fatalf("%v", err)
+			}
+		} else {
+			log.Warnf("No resource spec (--spec or resource_spec), only checking references")
+		}
+
+		problems := 0
+		for _, env := range envs {
+			problems += stacks.ValidateStacks(env, spec)
+		}
+		if problems > 0 {
+			log.Fatalf("%d problems found", problems)
+		}
+	},
+}
+
+var stacksIamPolicyCmd = &cobra.Command{
+	Use:   "iam-policy [stack_config.yml]",
+	Short: "Generate the IAM policy needed to deploy a set of cloudformation stacks",
+	Long: `Generate an IAM policy document for deploying an environment: the CloudFormation, S3 and EC2 actions sdt uses,
+and the actions to create, update and delete the resource types of the templates, e.g. for a CI role or a CloudFormation service role`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(stacksRef) == 0 {
+			log.Fatalf("specify stack option: -s <environment>.<stack name> or <environment>[<stack name>, ...]")
+		}
+		ValidateArgLen(1, args, "stacks config file required")
+		// no output finder, only the resource types of the templates matter
+		conf := stacks.NewConfig(args[0], nil)
+		policy, err := stacks.StacksIAMPolicy(fetchEnvStacks(conf))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		b, err := json.MarshalIndent(policy, "", "  ")
+		if err != nil {
+			log.Fatalf("Error generating json: %v", err)
+		}
+		fmt.Println(string(b))
+	},
+}
+
+var stacksCompareCmd = &cobra.Command{
+	Use:   "compare [stack_config.yml] [environment] [environment]",
+	Short: "Compare the rendered stacks of two environments",
+	Long: `Compare the rendered stacks of two environments, e.g. qa and prod before promoting: the stacks of only one of them,
+and the parameters, tags, stack options and templates that differ. Stack outputs and imports are compared by reference,
+or by their deployed values with --resolve-outputs.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ValidateArgLen(3, args, "stacks config file and two environments required")
+		var finder stacks.DeploymentOutputFinder = stacks.PlaceholderOutputFinder{}
+		if resolveOuts {
+			finder = StacksApi()
+		}
+		// each environment renders its own copy of the stacks yaml, rendering replaces the values
+		envA := stacks.NewConfig(args[0], finder).FetchEnvStacksSelection(args[1], &selection)
+		envB := stacks.NewConfig(args[0], finder).FetchEnvStacksSelection(args[2], &selection)
+		cmp := stacks.CompareEnvStacks(envA, envB)
+		if cmp.Empty() {
+			fmt.Printf("No differences between %s and %s\n", envA.Env, envB.Env)
+			return
+		}
+		cmp.Print(os.Stdout)
+	},
+}
+
+var stacksDiffCmd = &cobra.Command{
+	Use:   "diff [stack_config.yml]",
+	Short: "Diff the rendered templates and parameters of cloudformation stacks against the deployed ones",
+	Long: `Show a unified diff of the deployed template and parameter values of a set of cloudformation stacks,
+and the rendered ones. Templates are compared as yaml when one of them is json.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(stacksRef) == 0 {
+			log.Fatalf("specify stack option: -s <environment>.<stack name> or <environment>[<stack name>, ...]")
+		}
+		ValidateArgLen(1, args, "stacks config file required")
+		conf := stacks.NewConfig(args[0], StacksApi())
+		item := fetchEnvStacks(conf)
+		StacksApi().DiffStacks(item, !noColor && isTerminal(os.Stdout))
+	},
+}
+
+var stacksRenderCmd = &cobra.Command{
+	Use:   "render [stack_config.yml]",
+	Short: "Render a set of cloudformation stacks into a build directory",
+	Long: `Write the rendered template, an aws cloudformation parameters file and a CodePipeline template configuration file
+(parameters and tags) of each stack to a directory, with a manifest.json of the stack names in deploy order.
+The templates are packaged as they are deployed, nothing is uploaded.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(stacksRef) == 0 {
+			log.Fatalf("specify stack option: -s <environment>.<stack name> or <environment>[<stack name>, ...]")
+		}
+		ValidateArgLen(1, args, "stacks config file required")
+		conf := stacks.NewConfig(args[0], StacksApi())
+		if _, err := StacksApi().RenderStacks(fetchEnvStacks(conf), renderOut); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+var stacksGraphCmd = &cobra.Command{
+	Use:   "graph [stack_config.yml]",
+	Short: "Show the dependency graph of a set of cloudformation stacks",
+	Long:  "Show the dependency graph of an environment, or of all environments, as ascii, graphviz dot or mermaid",
+	Run: func(cmd *cobra.Command, args []string) {
+		ValidateArgLen(1, args, "stacks config file required")
+		conf := stacks.NewConfig(args[0], StacksApi())
+		envs := []*stacks.EnvStacksConfig{}
+		if len(stacksRef) > 0 {
+			envs = append(envs, fetchEnvStacks(conf))
+		} else {
+			for _, env := range conf.EnvNames() {
+				envs = append(envs, conf.FetchEnvStacks(env))
+			}
+		}
+
+		var statusFinder stacks.StackStatusFinder
+		if graphStatus {
+			statusFinder = StacksApi()
+		}
+		if err := stacks.WriteStacksGraph(os.Stdout, graphFormat, envs, statusFinder); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+var stacksRollbackCmd = &cobra.Command{
+	Use:   "rollback [stack_config.yml]",
+	Short: "Redeploy a previous deployment of a cloudformation stack",
+	Long:  "Redeploy the template, parameters and tags of a previous deployment of a cloudformation stack, or list its deployments without --to",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(stacksRef) == 0 {
+			log.Fatalf("specify stack option: -s <environment>.<stack name>")
+		}
+		ValidateArgLen(1, args, "stacks config file required")
+		conf := stacks.NewConfig(args[0], StacksApi())
+		item := fetchEnvStacks(conf)
+		if len(rollbackTo) == 0 {
+			printSnapshots(conf, item)
+			return
+		}
+		StacksApi().DeployOptions(deployOpts)
+		StacksApi().RollbackStack(item, rollbackTo)
+	},
+}
+
+var stacksHistoryCmd = &cobra.Command{
+	Use:   "history [stack_config.yml]",
+	Short: "Show the deploy, delete and rollback history of a set of cloudformation stacks",
+	Long:  "Show the deploy, delete and rollback history of an environment from the audit log",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(stacksRef) == 0 {
+			log.Fatalf("specify stack option: -s <environment>.<stack name> or <environment>[<stack name>, ...]")
+		}
+		ValidateArgLen(1, args, "stacks config file required")
+		conf := stacks.NewConfig(args[0], StacksApi())
+		item := fetchEnvStacks(conf)
+		records, err := stacks.ReadAuditLog(conf, item.Env)
+		if err != nil {
+			log.Fatalf("Error reading the audit log: %v", err)
+		}
+
+		tbl := utils.NewTableWriter(os.Stdout, 22, 10, 40, 40, 9, 10, 12)
+		tbl.WriteHeader("Time", "Operation", "User", "Stacks", "Outcome", "Duration", "Git Commit")
+		for _, r := range records {
+			if !r.HasStack(item.StackLabels) {
+				continue
+			}
+			names := []string{}
+			for _, s := range r.Stacks {
+				names = append(names, s.Name+":"+s.Outcome)
+			}
+			tbl.WriteRow(r.Time.Format(time.RFC3339), r.Operation, r.User, strings.Join(names, ","), r.Outcome,
+				(time.Duration(r.DurationSeconds) * time.Second).String(), r.GitCommit)
+		}
+		tbl.Footer()
+	},
+}
+
 var stacksJsonToYamlCmd = &cobra.Command{
 	Use:   "yaml [stack.json]",
 	Short: "Convert a CloudFormation stack in json to yaml",
@@ -140,6 +378,48 @@ var stacksJsonToYamlCmd = &cobra.Command{
 	},
 }
 
+// printSnapshots lists the deploys of the selected stacks that can be rolled back to
+func printSnapshots(conf *stacks.StacksConfig, envStacks *stacks.EnvStacksConfig) {
+	st, err := conf.SnapshotStore()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	tbl := utils.NewTableWriter(os.Stdout, 5, 30, 30, 22, 42)
+	tbl.WriteHeader("--to", "Id", "Stack", "Deployed", "Git Commit")
+	for _, label := range envStacks.StackLabels {
+		stack := envStacks.Stack(label)
+		ids, err := stacks.SnapshotIds(st, stack.Env(), stack.Label())
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for i, id := range ids {
+			snapshot, err := stacks.LoadSnapshot(st, stack.Env(), stack.Label(), id)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			tbl.WriteRow(fmt.Sprint(i), snapshot.Id, snapshot.StackName, snapshot.Created.Format(time.RFC3339), snapshot.GitCommit)
+		}
+	}
+	tbl.Footer()
+}
+
+// fetchEnvStacks selects the stacks from the --stacks ref and the selection flags
+func fetchEnvStacks(conf *stacks.StacksConfig) *stacks.EnvStacksConfig {
+	envStacks := conf.FetchEnvStacksSelection(stacksRef, &selection)
+	if len(changedSince) > 0 {
+		if err := envStacks.SelectChangedSince(changedSince); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	return envStacks
+}
+
+// isTerminal is true when the file is a terminal, not a pipe or a file
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 func StacksApi() stacks.StackApi {
 	if api != nil {
 		return api
@@ -159,8 +439,39 @@ func init() {
 	stacksCmd.AddCommand(stacksStatusCmd)
 	stacksCmd.AddCommand(stacksChangesCmd)
 	stacksCmd.AddCommand(stacksJsonToYamlCmd)
+	stacksCmd.AddCommand(stacksGraphCmd)
+	stacksCmd.AddCommand(stacksRollbackCmd)
+	stacksCmd.AddCommand(stacksHistoryCmd)
+	stacksCmd.AddCommand(stacksPackageCmd)
+	stacksCmd.AddCommand(stacksValidateCmd)
+	stacksCmd.AddCommand(stacksIamPolicyCmd)
+	stacksCmd.AddCommand(stacksCompareCmd)
+	stacksCmd.AddCommand(stacksDiffCmd)
+	stacksCmd.AddCommand(stacksRenderCmd)
 	RootCmd.AddCommand(stacksCmd)
 
-	stacksCmd.PersistentFlags().StringVarP(&stacksRef, "stacks", "s", "", "<environment>.<stack name> or <environment>[<stack name>, ...]")
+	stacksCmd.PersistentFlags().StringVarP(&stacksRef, "stacks", "s", "", "<environment>.<stack name> or <environment>[<stack name>, ...], stack names can be glob patterns")
+	stacksCmd.PersistentFlags().BoolVar(&selection.WithDeps, "with-deps", false, "also select the stacks the selected stacks depend on")
+	stacksCmd.PersistentFlags().BoolVar(&selection.Downstream, "downstream", false, "also select the stacks that depend on the selected stacks")
+	stacksCmd.PersistentFlags().StringSliceVar(&selection.Exclude, "exclude", []string{}, "stack names or glob patterns to leave out of the selection")
+	for _, c := range []*cobra.Command{stacksCreateOrUpdateCmd, stacksChangesCmd} {
+		c.PersistentFlags().StringVar(&changedSince, "changed-since", "", "only the stacks changed since the git ref, and the stacks depending on them")
+	}
+	stacksCreateOrUpdateCmd.PersistentFlags().BoolVar(&deployOpts.Resume, "resume", false, "continue the last failed deploy from the first incomplete stack")
+	stacksCreateOrUpdateCmd.PersistentFlags().BoolVar(&deployOpts.Atomic, "atomic", false, "on failure roll back all the stacks deployed by the run")
+	stacksCreateOrUpdateCmd.PersistentFlags().BoolVar(&deployOpts.Force, "force", false, "deploy stacks even when the template, parameters and tags are unchanged")
+	stacksCreateOrUpdateCmd.PersistentFlags().StringVar(&deployOpts.StackPolicyDuringUpdate, "stack-policy-during-update", "", "stack policy file or json replacing the stack policies during the update, for intentional replacements")
+	for _, c := range []*cobra.Command{stacksCreateOrUpdateCmd, stacksDeleteCmd, stacksRollbackCmd} {
+		c.PersistentFlags().DurationVar(&deployOpts.WaitForLock, "wait-for-lock", 0, "how long to wait for the environment lock held by another run, e.g. 15m")
+		c.PersistentFlags().StringVar(&deployOpts.Ticket, "ticket", "", "change ticket reference, required by protected environments with require_ticket")
+	}
+	stacksRollbackCmd.PersistentFlags().StringVar(&rollbackTo, "to", "", "deploys back (0 is the latest, 1 the one before) or the snapshot id to redeploy")
+	stacksPackageCmd.PersistentFlags().StringVarP(&packageOut, "out", "o", "", "directory to write the packaged <stack name>.template files to, instead of printing them")
+	stacksValidateCmd.PersistentFlags().StringVar(&specPath, "spec", "", "CloudFormation resource specification json file (gzipped or not), instead of the resource_spec of the stacks yaml")
+	stacksCompareCmd.PersistentFlags().BoolVar(&resolveOuts, "resolve-outputs", false, "compare the deployed values of stack outputs and imports, instead of the references")
+	stacksDiffCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "dont color the diff, it is colored on a terminal")
+	stacksRenderCmd.PersistentFlags().StringVarP(&renderOut, "out", "o", "build", "directory to write the rendered stacks to")
 	stacksTemplateCmd.PersistentFlags().BoolVarP(&process, "process", "p", false, "process the template")
+	stacksGraphCmd.PersistentFlags().StringVarP(&graphFormat, "format", "f", stacks.GraphFormatASCII, "graph format: ascii, dot or mermaid")
+	stacksGraphCmd.PersistentFlags().BoolVar(&graphStatus, "status", false, "include the status of each stack")
 }
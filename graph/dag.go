@@ -36,10 +36,11 @@ type DAG struct {
 	Root     *Vertex
 	Edges    *list.List
 	Vertices map[*Vertex][]*Edge
+	names    map[string]*Vertex // vertex name index
 }
 
 func NewDAG() *DAG {
-	return &DAG{Vertices: make(map[*Vertex][]*Edge), Edges: list.New()}
+	return &DAG{Vertices: make(map[*Vertex][]*Edge), Edges: list.New(), names: make(map[string]*Vertex)}
 }
 
 func (d *DAG) AddRoot(v *Vertex) {
@@ -52,6 +53,9 @@ func (d *DAG) AddVertex(v *Vertex) {
 	if _, ok := d.Vertices[v]; !ok {
 		d.Vertices[v] = []*Edge{}
 	}
+	if _, ok := d.names[v.Name]; !ok {
+		d.names[v.Name] = v
+	}
 }
 
 func (d *DAG) AddEdge(e *Edge) {
@@ -71,12 +75,7 @@ func (d *DAG) AddEdge(e *Edge) {
 }
 
 func (d *DAG) FindVertexByName(name string) *Vertex {
-	for k, _ := range d.Vertices {
-		if k.Name == name {
-			return k
-		}
-	}
-	return nil
+	return d.names[name]
 }
 
 func (d *DAG) AddEdgeBetweenVertices(parentName, childName string) *Edge {
@@ -235,32 +234,7 @@ func (d *DAG) TransitiveReduction() {
 }
 
 func (d *DAG) HasCycles() bool {
-
-	edges := map[string]bool{}
-	vertsVisited := map[*Vertex]bool{}
-
-	cycleFound := false
-	d.VisitDepthFirstFromRoot(func(vertex *Vertex) bool {
-		vertEdges := d.Vertices[vertex]
-		vertsVisited[vertex] = true
-		for _, edge := range vertEdges {
-			id := fmt.Sprintf("%v%v", edge.Parent, edge.Child)
-
-			if _, found := vertsVisited[edge.Child]; found {
-				cycleFound = true
-				return false
-			}
-
-			if _, found := edges[id]; !found {
-				edges[id] = true
-			} else {
-				cycleFound = true
-				return false
-			}
-		}
-		return true
-	})
-	return cycleFound
+	return len(d.FindCycle()) > 0
 }
 
 func castEdge(el *list.Element) *Edge {
@@ -0,0 +1,172 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package graph
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	mermaidIdRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// VertexLabelFunc returns the text to display for a vertex
+type VertexLabelFunc func(v *Vertex) string
+
+func nameLabel(v *Vertex) string {
+	return v.Name
+}
+
+// Subgraph copies the graph with only the vertices to keep, and the edges between them
+func (d *DAG) Subgraph(keep func(v *Vertex) bool) *DAG {
+	sub := NewDAG()
+	if d.Root != nil && keep(d.Root) {
+		sub.AddRoot(d.Root)
+	}
+	for _, v := range d.SortedVertices() {
+		if keep(v) {
+			sub.AddVertex(v)
+		}
+	}
+	d.VisitEdges(func(e *Edge) {
+		if keep(e.Parent) && keep(e.Child) {
+			sub.AddEdge(&Edge{Parent: e.Parent, Child: e.Child})
+		}
+	})
+	return sub
+}
+
+// SortedEdges returns the distinct edges ordered by parent then child name
+func (d *DAG) SortedEdges() []*Edge {
+	edges := []*Edge{}
+	seen := map[string]bool{}
+	d.VisitEdges(func(e *Edge) {
+		if !seen[e.String()] {
+			seen[e.String()] = true
+			edges = append(edges, e)
+		}
+	})
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Parent.Name != edges[j].Parent.Name {
+			return edges[i].Parent.Name < edges[j].Parent.Name
+		}
+		return edges[i].Child.Name < edges[j].Child.Name
+	})
+	return edges
+}
+
+// WriteDot writes the graph as a graphviz digraph
+func (d *DAG) WriteDot(w io.Writer, name string, label VertexLabelFunc) {
+	fmt.Fprintf(w, "digraph %s {\n", strconv.Quote(name))
+	fmt.Fprintf(w, "  rankdir=LR;\n")
+	d.writeDotBody(w, "  ", "", label)
+	fmt.Fprintf(w, "}\n")
+}
+
+// WriteDotCluster writes the graph as a cluster subgraph, so several graphs can share one digraph
+func (d *DAG) WriteDotCluster(w io.Writer, name string, label VertexLabelFunc) {
+	fmt.Fprintf(w, "  subgraph %s {\n", strconv.Quote("cluster_"+name))
+	fmt.Fprintf(w, "    label=%s;\n", strconv.Quote(name))
+	d.writeDotBody(w, "    ", name+".", label)
+	fmt.Fprintf(w, "  }\n")
+}
+
+func (d *DAG) writeDotBody(w io.Writer, indent, idPrefix string, label VertexLabelFunc) {
+	if label == nil {
+		label = nameLabel
+	}
+	for _, v := range d.SortedVertices() {
+		fmt.Fprintf(w, "%s%s [label=%s];\n", indent, strconv.Quote(idPrefix+v.Name), strconv.Quote(label(v)))
+	}
+	for _, e := range d.SortedEdges() {
+		fmt.Fprintf(w, "%s%s -> %s;\n", indent, strconv.Quote(idPrefix+e.Parent.Name), strconv.Quote(idPrefix+e.Child.Name))
+	}
+}
+
+// WriteMermaid writes the graph as a mermaid flowchart
+func (d *DAG) WriteMermaid(w io.Writer, label VertexLabelFunc) {
+	fmt.Fprintf(w, "graph TD\n")
+	d.writeMermaidBody(w, "  ", "", label)
+}
+
+// WriteMermaidSubgraph writes the graph as a mermaid subgraph, to follow a "graph TD" header
+func (d *DAG) WriteMermaidSubgraph(w io.Writer, name string, label VertexLabelFunc) {
+	fmt.Fprintf(w, "  subgraph %s [%s]\n", mermaidId("", name), mermaidText(name))
+	d.writeMermaidBody(w, "    ", name+".", label)
+	fmt.Fprintf(w, "  end\n")
+}
+
+func (d *DAG) writeMermaidBody(w io.Writer, indent, idPrefix string, label VertexLabelFunc) {
+	if label == nil {
+		label = nameLabel
+	}
+	for _, v := range d.SortedVertices() {
+		fmt.Fprintf(w, "%s%s[%s]\n", indent, mermaidId(idPrefix, v.Name), mermaidText(label(v)))
+	}
+	for _, e := range d.SortedEdges() {
+		fmt.Fprintf(w, "%s%s --> %s\n", indent, mermaidId(idPrefix, e.Parent.Name), mermaidId(idPrefix, e.Child.Name))
+	}
+}
+
+func mermaidId(prefix, name string) string {
+	return mermaidIdRe.ReplaceAllString(prefix+name, "_")
+}
+
+func mermaidText(text string) string {
+	text = strings.Replace(text, "\"", "#quot;", -1)
+	return "\"" + strings.Replace(text, "\n", "<br/>", -1) + "\""
+}
+
+// WriteASCII writes the graph as trees starting at the vertices without parents.
+// A vertex with several parents is expanded under the first one, and referenced after that.
+func (d *DAG) WriteASCII(w io.Writer, label VertexLabelFunc) {
+	if label == nil {
+		label = nameLabel
+	}
+	expanded := map[*Vertex]bool{}
+	for _, v := range d.SortedVertices() {
+		if len(d.Parents(v)) == 0 {
+			d.writeASCIITree(w, v, "", "", expanded, label)
+		}
+	}
+}
+
+func (d *DAG) writeASCIITree(w io.Writer, v *Vertex, prefix, childPrefix string,
+	expanded map[*Vertex]bool, label VertexLabelFunc) {
+
+	if expanded[v] {
+		fmt.Fprintf(w, "%s%s (see above)\n", prefix, label(v))
+		return
+	}
+	expanded[v] = true
+	fmt.Fprintf(w, "%s%s\n", prefix, label(v))
+
+	children := d.Children(v)
+	for i, child := range children {
+		if i == len(children)-1 {
+			d.writeASCIITree(w, child, childPrefix+"└── ", childPrefix+"    ", expanded, label)
+		} else {
+			d.writeASCIITree(w, child, childPrefix+"├── ", childPrefix+"│   ", expanded, label)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package graph
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func printTestDag() *DAG {
+	dag := NewDAG()
+	// ROOT -> dns -> r53  ROOT -> elb -> server  dns -> server
+	dag.AddRoot(&Vertex{Name: "ROOT"})
+	dag.AddEdgeBetweenVertices("ROOT", "nagios-internal-dns")
+	dag.AddEdgeBetweenVertices("ROOT", "nagios-elb")
+	dag.AddEdgeBetweenVertices("nagios-internal-dns", "nagios-r53")
+	dag.AddEdgeBetweenVertices("nagios-elb", "nagios-server")
+	dag.AddEdgeBetweenVertices("nagios-internal-dns", "nagios-server")
+
+	return dag.Subgraph(func(v *Vertex) bool { return v.Name != "ROOT" })
+}
+
+func TestSubgraph(t *testing.T) {
+	dag := printTestDag()
+	assert.Nil(t, dag.Root)
+	assert.Nil(t, dag.FindVertexByName("ROOT"))
+	assert.Equal(t, 4, len(dag.Vertices))
+	assert.Equal(t, 3, dag.Edges.Len())
+}
+
+func TestWriteDot(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+	printTestDag().WriteDot(out, "qa", func(v *Vertex) string { return v.Name + "\nstack" })
+
+	assert.Equal(t, `digraph "qa" {
+  rankdir=LR;
+  "nagios-elb" [label="nagios-elb\nstack"];
+  "nagios-internal-dns" [label="nagios-internal-dns\nstack"];
+  "nagios-r53" [label="nagios-r53\nstack"];
+  "nagios-server" [label="nagios-server\nstack"];
+  "nagios-elb" -> "nagios-server";
+  "nagios-internal-dns" -> "nagios-r53";
+  "nagios-internal-dns" -> "nagios-server";
+}
+`, out.String())
+}
+
+func TestWriteMermaidSubgraph(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+	printTestDag().WriteMermaidSubgraph(out, "qa", func(v *Vertex) string { return v.Name + "\nstack" })
+
+	assert.Equal(t, `  subgraph qa ["qa"]
+    qa_nagios_elb["nagios-elb<br/>stack"]
+    qa_nagios_internal_dns["nagios-internal-dns<br/>stack"]
+    qa_nagios_r53["nagios-r53<br/>stack"]
+    qa_nagios_server["nagios-server<br/>stack"]
+    qa_nagios_elb --> qa_nagios_server
+    qa_nagios_internal_dns --> qa_nagios_r53
+    qa_nagios_internal_dns --> qa_nagios_server
+  end
+`, out.String())
+}
+
+func TestWriteASCII(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+	printTestDag().WriteASCII(out, nil)
+
+	assert.Equal(t, `nagios-elb
+└── nagios-server
+nagios-internal-dns
+├── nagios-r53
+└── nagios-server (see above)
+`, out.String())
+}
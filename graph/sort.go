@@ -0,0 +1,203 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package graph
+
+import (
+	"sort"
+	"strings"
+)
+
+// CycleError is returned when the graph is not acyclic, Path holds the vertices
+// of the cycle with the first vertex repeated at the end.
+type CycleError struct {
+	Path []*Vertex
+}
+
+func (e *CycleError) Error() string {
+	names := make([]string, len(e.Path))
+	for i, v := range e.Path {
+		names[i] = v.Name
+	}
+	return "dependency cycle: " + strings.Join(names, " -> ")
+}
+
+// SortedVertices returns all the vertices ordered by name
+func (d *DAG) SortedVertices() []*Vertex {
+	verts := make([]*Vertex, 0, len(d.Vertices))
+	for v := range d.Vertices {
+		verts = append(verts, v)
+	}
+	sortVertices(verts)
+	return verts
+}
+
+// Children of a vertex ordered by name
+func (d *DAG) Children(v *Vertex) []*Vertex {
+	children := []*Vertex{}
+	for _, e := range d.Vertices[v] {
+		if !arrayContainsVertex(children, e.Child) {
+			children = append(children, e.Child)
+		}
+	}
+	sortVertices(children)
+	return children
+}
+
+// Parents of a vertex ordered by name
+func (d *DAG) Parents(v *Vertex) []*Vertex {
+	parents := []*Vertex{}
+	d.VisitEdges(func(e *Edge) {
+		if e.Child == v && !arrayContainsVertex(parents, e.Parent) {
+			parents = append(parents, e.Parent)
+		}
+	})
+	sortVertices(parents)
+	return parents
+}
+
+// Ancestors are all the vertices with a path to the vertex, ordered by name
+func (d *DAG) Ancestors(v *Vertex) []*Vertex {
+	return d.reachable(v, d.Parents)
+}
+
+// Descendants are all the vertices reachable from the vertex, ordered by name
+func (d *DAG) Descendants(v *Vertex) []*Vertex {
+	return d.reachable(v, d.Children)
+}
+
+func (d *DAG) reachable(start *Vertex, next func(v *Vertex) []*Vertex) []*Vertex {
+	seen := map[*Vertex]bool{start: true}
+	result := []*Vertex{}
+	pending := next(start)
+	for len(pending) > 0 {
+		v := pending[0]
+		pending = pending[1:]
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+			pending = append(pending, next(v)...)
+		}
+	}
+	sortVertices(result)
+	return result
+}
+
+// TopologicalLevels groups the vertices with Kahn's algorithm, every vertex is in
+// a later level than all of its parents. Vertices in a level are ordered by name.
+func (d *DAG) TopologicalLevels() ([][]*Vertex, error) {
+	inDegree := make(map[*Vertex]int, len(d.Vertices))
+	for v := range d.Vertices {
+		inDegree[v] = 0
+	}
+	d.VisitEdges(func(e *Edge) {
+		inDegree[e.Child]++
+	})
+
+	level := []*Vertex{}
+	for v, degree := range inDegree {
+		if degree == 0 {
+			level = append(level, v)
+		}
+	}
+
+	levels := [][]*Vertex{}
+	sorted := 0
+	for len(level) > 0 {
+		sortVertices(level)
+		levels = append(levels, level)
+		sorted += len(level)
+
+		next := []*Vertex{}
+		for _, v := range level {
+			for _, e := range d.Vertices[v] {
+				inDegree[e.Child]--
+				if inDegree[e.Child] == 0 {
+					next = append(next, e.Child)
+				}
+			}
+		}
+		level = next
+	}
+
+	if sorted < len(d.Vertices) {
+		return nil, &CycleError{Path: d.FindCycle()}
+	}
+	return levels, nil
+}
+
+// TopologicalSort orders the vertices so that parents come before their children
+func (d *DAG) TopologicalSort() ([]*Vertex, error) {
+	levels, err := d.TopologicalLevels()
+	if err != nil {
+		return nil, err
+	}
+	result := []*Vertex{}
+	for _, level := range levels {
+		result = append(result, level...)
+	}
+	return result, nil
+}
+
+// FindCycle returns the path of the first cycle found, or nil when the graph is acyclic
+func (d *DAG) FindCycle() []*Vertex {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*Vertex]int, len(d.Vertices))
+	path := []*Vertex{}
+
+	var visit func(v *Vertex) []*Vertex
+	visit = func(v *Vertex) []*Vertex {
+		state[v] = visiting
+		path = append(path, v)
+		for _, child := range d.Children(v) {
+			switch state[child] {
+			case visiting:
+				// the cycle starts where the child was first seen on the path
+				for i, p := range path {
+					if p == child {
+						cycle := append([]*Vertex{}, path[i:]...)
+						return append(cycle, child)
+					}
+				}
+			case unvisited:
+				if cycle := visit(child); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[v] = visited
+		return nil
+	}
+
+	for _, v := range d.SortedVertices() {
+		if state[v] == unvisited {
+			if cycle := visit(v); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+func sortVertices(verts []*Vertex) {
+	sort.Slice(verts, func(i, j int) bool { return verts[i].Name < verts[j].Name })
+}
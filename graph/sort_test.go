@@ -0,0 +1,117 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func vertexNames(verts []*Vertex) []string {
+	names := []string{}
+	for _, v := range verts {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+func TestTopologicalLevels(t *testing.T) {
+	dag := NewDAG()
+	// ROOT -> dns -> r53  ROOT -> elb -> server  dns -> server
+	dag.AddRoot(&Vertex{Name: "ROOT"})
+	dag.AddEdgeBetweenVertices("ROOT", "nagios-internal-dns")
+	dag.AddEdgeBetweenVertices("ROOT", "nagios-elb")
+	dag.AddEdgeBetweenVertices("nagios-internal-dns", "nagios-r53")
+	dag.AddEdgeBetweenVertices("nagios-elb", "nagios-server")
+	dag.AddEdgeBetweenVertices("nagios-internal-dns", "nagios-server")
+
+	levels, err := dag.TopologicalLevels()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(levels))
+	assert.Equal(t, []string{"ROOT"}, vertexNames(levels[0]))
+	assert.Equal(t, []string{"nagios-elb", "nagios-internal-dns"}, vertexNames(levels[1]))
+	assert.Equal(t, []string{"nagios-r53", "nagios-server"}, vertexNames(levels[2]))
+
+	sorted, err := dag.TopologicalSort()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ROOT", "nagios-elb", "nagios-internal-dns", "nagios-r53", "nagios-server"},
+		vertexNames(sorted))
+}
+
+func TestTopologicalSortCycle(t *testing.T) {
+	dag := NewDAG()
+	// ROOT -> A -> B -> C -> B
+	dag.AddRoot(&Vertex{Name: "ROOT"})
+	dag.AddEdgeBetweenVertices("ROOT", "A")
+	dag.AddEdgeBetweenVertices("A", "B")
+	dag.AddEdgeBetweenVertices("B", "C")
+	dag.AddEdgeBetweenVertices("C", "B")
+
+	_, err := dag.TopologicalSort()
+	assert.NotNil(t, err)
+	cycleErr, ok := err.(*CycleError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"B", "C", "B"}, vertexNames(cycleErr.Path))
+	assert.Equal(t, "dependency cycle: B -> C -> B", err.Error())
+}
+
+func TestFindCycleDiamond(t *testing.T) {
+	dag := NewDAG()
+	// A -> B -> D  A -> C -> D
+	dag.AddRoot(&Vertex{Name: "A"})
+	dag.AddEdgeBetweenVertices("A", "B")
+	dag.AddEdgeBetweenVertices("A", "C")
+	dag.AddEdgeBetweenVertices("B", "D")
+	dag.AddEdgeBetweenVertices("C", "D")
+
+	assert.Nil(t, dag.FindCycle())
+	assert.False(t, dag.HasCycles())
+}
+
+func TestParentsAndChildren(t *testing.T) {
+	dag := NewDAG()
+	dag.AddRoot(&Vertex{Name: "A"})
+	dag.AddEdgeBetweenVertices("A", "C")
+	dag.AddEdgeBetweenVertices("A", "B")
+	dag.AddEdgeBetweenVertices("B", "C")
+
+	c := dag.FindVertexByName("C")
+	assert.NotNil(t, c)
+	assert.Equal(t, []string{"A", "B"}, vertexNames(dag.Parents(c)))
+	assert.Equal(t, []string{"B", "C"}, vertexNames(dag.Children(dag.Root)))
+	assert.Nil(t, dag.FindVertexByName("missing"))
+}
+
+func TestAncestorsAndDescendants(t *testing.T) {
+	dag := NewDAG()
+	// ROOT -> A -> B -> D  ROOT -> C -> D -> E
+	dag.AddRoot(&Vertex{Name: "ROOT"})
+	dag.AddEdgeBetweenVertices("ROOT", "A")
+	dag.AddEdgeBetweenVertices("ROOT", "C")
+	dag.AddEdgeBetweenVertices("A", "B")
+	dag.AddEdgeBetweenVertices("B", "D")
+	dag.AddEdgeBetweenVertices("C", "D")
+	dag.AddEdgeBetweenVertices("D", "E")
+
+	d := dag.FindVertexByName("D")
+	assert.Equal(t, []string{"A", "B", "C", "ROOT"}, vertexNames(dag.Ancestors(d)))
+	assert.Equal(t, []string{"E"}, vertexNames(dag.Descendants(d)))
+	assert.Equal(t, []string{"B", "D", "E"}, vertexNames(dag.Descendants(dag.FindVertexByName("A"))))
+	assert.Equal(t, []string{}, vertexNames(dag.Descendants(dag.FindVertexByName("E"))))
+}
@@ -0,0 +1,178 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package locks
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/capitalone/stack-deployment-tool/providers"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	// the table hash key, a string
+	DynamoDBKeyAttribute = "LockKey"
+)
+
+// DynamoDBBackend keeps the locks as items of a table with a LockKey string hash key, written with a conditional put
+type DynamoDBBackend struct {
+	Table string
+
+	service *dynamodb.DynamoDB
+}
+
+func NewDynamoDBBackend(table string) *DynamoDBBackend {
+	return &DynamoDBBackend{Table: table}
+}
+
+func (d *DynamoDBBackend) DynamoDBService() *dynamodb.DynamoDB {
+	if d.service == nil {
+		d.service = providers.NewAWSApi().DynamoDBService()
+	}
+	return d.service
+}
+
+func itemKey(key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{DynamoDBKeyAttribute: {S: aws.String(key)}}
+}
+
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "ConditionalCheckFailedException"
+}
+
+func (d *DynamoDBBackend) read(key string) (*Lock, error) {
+	resp, err := d.DynamoDBService().GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(d.Table),
+		Key:            itemKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return itemLock(resp.Item)
+}
+
+func itemLock(item map[string]*dynamodb.AttributeValue) (*Lock, error) {
+	if attr, ok := item["Lock"]; ok && attr.S != nil {
+		return decodeLock([]byte(*attr.S))
+	}
+	return nil, nil
+}
+
+// TryAcquire puts the lock unless an unexpired lock exists
+func (d *DynamoDBBackend) TryAcquire(lock *Lock) (*Lock, error) {
+	_, err := d.DynamoDBService().PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			DynamoDBKeyAttribute: {S: aws.String(lock.Key)},
+			"LockId":             {S: aws.String(lock.Id)},
+			"Expires":            {N: aws.String(fmt.Sprint(lock.Expires.Unix()))},
+			"Lock":               {S: aws.String(string(lock.encode()))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(" + DynamoDBKeyAttribute + ") OR Expires < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(fmt.Sprint(time.Now().Unix()))},
+		},
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !isConditionFailed(err) {
+		return nil, err
+	}
+	holder, err := d.read(lock.Key)
+	if err != nil {
+		return nil, err
+	}
+	if holder == nil { // released meanwhile, try again on the next poll
+		holder = &Lock{Key: lock.Key, Expires: time.Now()}
+	}
+	return holder, nil
+}
+
+// Renew puts the lock if the item is still held by it
+func (d *DynamoDBBackend) Renew(lock *Lock) error {
+	_, err := d.DynamoDBService().PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			DynamoDBKeyAttribute: {S: aws.String(lock.Key)},
+			"LockId":             {S: aws.String(lock.Id)},
+			"Expires":            {N: aws.String(fmt.Sprint(lock.Expires.Unix()))},
+			"Lock":               {S: aws.String(string(lock.encode()))},
+		},
+		ConditionExpression: aws.String("LockId = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {S: aws.String(lock.Id)},
+		},
+	})
+	if isConditionFailed(err) {
+		return fmt.Errorf("lock: %s is no longer held", lock.Key)
+	}
+	return err
+}
+
+func (d *DynamoDBBackend) Release(lock *Lock) error {
+	_, err := d.DynamoDBService().DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:           aws.String(d.Table),
+		Key:                 itemKey(lock.Key),
+		ConditionExpression: aws.String("LockId = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {S: aws.String(lock.Id)},
+		},
+	})
+	if isConditionFailed(err) {
+		log.Warnf("Lock: %s is no longer held, not releasing it", lock.Key)
+		return nil
+	}
+	return err
+}
+
+func (d *DynamoDBBackend) List() ([]*Lock, error) {
+	result := []*Lock{}
+	err := d.DynamoDBService().ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(d.Table),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if l, err := itemLock(item); err == nil && l != nil {
+				result = append(result, l)
+			}
+		}
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, err
+}
+
+func (d *DynamoDBBackend) Break(key string) error {
+	_, err := d.DynamoDBService().DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.Table),
+		Key:       itemKey(key),
+	})
+	return err
+}
+
+func (d *DynamoDBBackend) String() string {
+	return "dynamodb://" + d.Table
+}
@@ -0,0 +1,171 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package locks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// FileBackend keeps the locks as files in a directory, for deploys from the same machine or a shared file system
+type FileBackend struct {
+	Dir string
+}
+
+func NewFileBackend(dir string) *FileBackend {
+	return &FileBackend{Dir: dir}
+}
+
+func (f *FileBackend) path(key string) string {
+	return filepath.Join(f.Dir, key+".lock")
+}
+
+func (f *FileBackend) read(key string) (*Lock, error) {
+	b, err := ioutil.ReadFile(f.path(key))
+	if err != nil {
+		return nil, err
+	}
+	return decodeLock(b)
+}
+
+func (f *FileBackend) TryAcquire(lock *Lock) (*Lock, error) {
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return nil, err
+	}
+	var holder *Lock
+	for attempt := 0; attempt < 2; attempt++ {
+		fh, err := os.OpenFile(f.path(lock.Key), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = fh.Write(lock.encode())
+			fh.Close()
+			return nil, err
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		holder, err = f.read(lock.Key)
+		if os.IsNotExist(err) {
+			continue // released meanwhile
+		} else if err != nil {
+			return nil, err
+		}
+		if !holder.Expired() {
+			return holder, nil
+		}
+		log.Warnf("Lock: %s held by: %s expired, taking it over", lock.Key, holder)
+		if err := f.removeExpired(holder); err != nil {
+			return nil, err
+		}
+	}
+	return holder, nil
+}
+
+// removeExpired removes the lock file of the expired holder. Of the processes taking it over at the same time,
+// only the one creating the takeover file of the holder removes it, the others then find the lock taken.
+func (f *FileBackend) removeExpired(holder *Lock) error {
+	takeover := f.path(holder.Key) + "." + holder.Id
+	fh, err := os.OpenFile(takeover, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fh.Close()
+	defer os.Remove(takeover)
+
+	current, err := f.read(holder.Key)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if current.Id != holder.Id {
+		return nil // taken over meanwhile
+	}
+	if err := os.Remove(f.path(holder.Key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Renew replaces the lock file with a renamed temp file, so it is never seen half written
+func (f *FileBackend) Renew(lock *Lock) error {
+	holder, err := f.read(lock.Key)
+	if err != nil {
+		return err
+	}
+	if holder.Id != lock.Id {
+		return fmt.Errorf("lock: %s was taken over by: %s", lock.Key, holder)
+	}
+	tmp := f.path(lock.Key) + "." + lock.Id + ".renew"
+	if err := ioutil.WriteFile(tmp, lock.encode(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(lock.Key))
+}
+
+func (f *FileBackend) Release(lock *Lock) error {
+	holder, err := f.read(lock.Key)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if holder.Id != lock.Id {
+		log.Warnf("Lock: %s is held by: %s, not releasing it", lock.Key, holder)
+		return nil
+	}
+	return os.Remove(f.path(lock.Key))
+}
+
+func (f *FileBackend) List() ([]*Lock, error) {
+	files, err := filepath.Glob(filepath.Join(f.Dir, "*.lock"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	result := []*Lock{}
+	for _, file := range files {
+		l, err := f.read(strings.TrimSuffix(filepath.Base(file), ".lock"))
+		if err != nil {
+			log.Warnf("Error reading lock: %s %v", file, err)
+			continue
+		}
+		result = append(result, l)
+	}
+	return result, nil
+}
+
+func (f *FileBackend) Break(key string) error {
+	err := os.Remove(f.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *FileBackend) String() string {
+	return f.Dir
+}
@@ -0,0 +1,188 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package locks
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// how often a held lock is checked while waiting for it
+	PollInterval = 10 * time.Second
+)
+
+// Lock is an exclusive lock on a key, i.e. an environment, that expires after its TTL
+type Lock struct {
+	Key      string    `json:"key"`
+	Id       string    `json:"id"` // unique per acquire, only the holder releases the lock
+	Owner    string    `json:"owner"`
+	Host     string    `json:"host"`
+	Pid      int       `json:"pid"`
+	BuildURL string    `json:"build_url,omitempty"`
+	Acquired time.Time `json:"acquired"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Backend stores the locks
+type Backend interface {
+	// TryAcquire takes the lock, or returns the current holder when it is held and not expired
+	TryAcquire(lock *Lock) (*Lock, error)
+	// Renew writes the lock with its new expiry, if still held by it
+	Renew(lock *Lock) error
+	// Release removes the lock, if still held by it
+	Release(lock *Lock) error
+	List() ([]*Lock, error)
+	// Break removes the lock on the key, whoever holds it
+	Break(key string) error
+	String() string
+}
+
+// New creates the lock backend for a location: s3://bucket/prefix, dynamodb://table or a directory relative to baseDir
+func New(location string, baseDir string) (Backend, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "s3":
+		if len(u.Host) == 0 {
+			return nil, fmt.Errorf("bucket missing from: %s", location)
+		}
+		return NewS3Backend(u.Host, strings.Trim(u.Path, "/")), nil
+	case "dynamodb":
+		if len(u.Host) == 0 {
+			return nil, fmt.Errorf("table missing from: %s", location)
+		}
+		return NewDynamoDBBackend(u.Host), nil
+	case "":
+		if len(location) == 0 {
+			return nil, fmt.Errorf("lock location missing")
+		}
+		if !filepath.IsAbs(location) {
+			location = filepath.Join(baseDir, location)
+		}
+		return NewFileBackend(location), nil
+	}
+	return nil, fmt.Errorf("unsupported lock backend: %s", location)
+}
+
+// NewLock creates a lock on the key owned by the current user and process
+func NewLock(key string, ttl time.Duration) *Lock {
+	host, _ := os.Hostname()
+	now := time.Now().UTC()
+	return &Lock{
+		Key:      key,
+		Id:       newId(),
+		Owner:    os.Getenv("USER"),
+		Host:     host,
+		Pid:      os.Getpid(),
+		BuildURL: os.Getenv("BUILD_URL"),
+		Acquired: now,
+		Expires:  now.Add(ttl),
+	}
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+func (l *Lock) Expired() bool {
+	return time.Now().After(l.Expires)
+}
+
+// Keep renews the lock every third of its ttl, so a run longer than the ttl keeps it, until the returned stop func is called
+func Keep(backend Backend, lock *Lock) (stop func()) {
+	ttl := lock.Expires.Sub(lock.Acquired)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				renewed := *lock
+				renewed.Expires = time.Now().UTC().Add(ttl)
+				if err := backend.Renew(&renewed); err != nil {
+					log.Errorf("Error renewing lock: %s %v", lock.Key, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (l *Lock) String() string {
+	return fmt.Sprintf("%s@%s (pid: %d) since: %s expires: %s", l.Owner, l.Host, l.Pid,
+		l.Acquired.Format(time.RFC3339), l.Expires.Format(time.RFC3339))
+}
+
+func (l *Lock) encode() []byte {
+	b, _ := json.Marshal(l)
+	return b
+}
+
+func decodeLock(b []byte) (*Lock, error) {
+	l := &Lock{}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("invalid lock: %s %v", b, err)
+	}
+	return l, nil
+}
+
+// Acquire takes the lock, waiting up to wait for the current holder to release it
+func Acquire(backend Backend, lock *Lock, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		holder, err := backend.TryAcquire(lock)
+		if err != nil {
+			return err
+		}
+		if holder == nil {
+			log.Infof("Acquired lock: %s", lock.Key)
+			return nil
+		}
+
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return fmt.Errorf("%s is locked by: %s (see: sdt locks list/break, or use --wait-for-lock)", lock.Key, holder)
+		}
+		log.Infof("Waiting for lock: %s held by: %s", lock.Key, holder)
+		if remaining > PollInterval {
+			remaining = PollInterval
+		}
+		time.Sleep(remaining)
+	}
+}
@@ -0,0 +1,119 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package locks
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	b, err := New("s3://lock-bucket/sdt/locks", "/base")
+	assert.Nil(t, err)
+	assert.Equal(t, "s3://lock-bucket/sdt/locks", b.String())
+	assert.Equal(t, "sdt/locks/qa.lock", b.(*S3Backend).objectKey("qa"))
+
+	b, err = New("dynamodb://sdt-locks", "/base")
+	assert.Nil(t, err)
+	assert.Equal(t, "sdt-locks", b.(*DynamoDBBackend).Table)
+
+	b, err = New(".sdt/locks", "/base")
+	assert.Nil(t, err)
+	assert.Equal(t, "/base/.sdt/locks", b.String())
+
+	_, err = New("consul://locks", "/base")
+	assert.NotNil(t, err)
+	_, err = New("dynamodb://", "/base")
+	assert.NotNil(t, err)
+}
+
+func TestFileBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "locks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	PollInterval = 10 * time.Millisecond
+
+	b := NewFileBackend(dir)
+	first := NewLock("qa", time.Hour)
+	assert.Nil(t, Acquire(b, first, 0))
+
+	second := NewLock("qa", time.Hour)
+	err = Acquire(b, second, 30*time.Millisecond)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "qa is locked by:")
+
+	// only the holder releases the lock
+	assert.Nil(t, b.Release(second))
+	locks, err := b.List()
+	assert.Nil(t, err)
+	assert.Len(t, locks, 1)
+	assert.Equal(t, first.Id, locks[0].Id)
+
+	assert.Nil(t, b.Release(first))
+	assert.Nil(t, Acquire(b, second, 0))
+
+	// expired locks are taken over, by one process at a time
+	expired := NewLock("prod", -time.Minute)
+	assert.Nil(t, Acquire(b, expired, 0))
+	takeover := b.path("prod") + "." + expired.Id
+	assert.Nil(t, ioutil.WriteFile(takeover, nil, 0644))
+	holder, err := b.TryAcquire(NewLock("prod", time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, expired.Id, holder.Id)
+	assert.Nil(t, os.Remove(takeover))
+	assert.Nil(t, Acquire(b, NewLock("prod", time.Hour), 0))
+	_, err = os.Stat(takeover)
+	assert.True(t, os.IsNotExist(err))
+
+	locks, err = b.List()
+	assert.Nil(t, err)
+	assert.Len(t, locks, 2)
+	assert.Equal(t, "prod", locks[0].Key)
+
+	assert.Nil(t, b.Break("qa"))
+	assert.Nil(t, b.Break("qa"))
+	assert.Nil(t, Acquire(b, NewLock("qa", time.Hour), 0))
+}
+
+func TestKeep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "locks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	b := NewFileBackend(dir)
+	lock := NewLock("qa", 60*time.Millisecond)
+	assert.Nil(t, Acquire(b, lock, 0))
+	stop := Keep(b, lock)
+	time.Sleep(150 * time.Millisecond)
+	stop()
+
+	// renewed past its ttl, so not taken over
+	holder, err := b.TryAcquire(NewLock("qa", time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, lock.Id, holder.Id)
+	assert.True(t, holder.Expires.After(lock.Expires))
+
+	// only the holder renews the lock
+	assert.NotNil(t, b.Renew(NewLock("qa", time.Hour)))
+	assert.Nil(t, b.Release(lock))
+	assert.NotNil(t, b.Renew(lock))
+}
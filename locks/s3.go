@@ -0,0 +1,226 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package locks
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/providers"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Backend keeps the locks as objects under a bucket prefix, created with a conditional write
+type S3Backend struct {
+	Bucket string
+	Prefix string
+
+	service *s3.S3
+}
+
+func NewS3Backend(bucket, prefix string) *S3Backend {
+	return &S3Backend{Bucket: bucket, Prefix: prefix}
+}
+
+func (b *S3Backend) S3Service() *s3.S3 {
+	if b.service == nil {
+		b.service = providers.NewAWSApi().S3Service()
+	}
+	return b.service
+}
+
+func (b *S3Backend) objectKey(key string) string {
+	if len(b.Prefix) == 0 {
+		return key + ".lock"
+	}
+	return b.Prefix + "/" + key + ".lock"
+}
+
+// putIfAbsent writes the lock object only if it doesnt exist (If-None-Match: *)
+func (b *S3Backend) putIfAbsent(lock *Lock) (bool, error) {
+	return b.putIf(lock, "If-None-Match", "*")
+}
+
+// putIfMatch replaces the lock object only if it is still the one with the etag (If-Match)
+func (b *S3Backend) putIfMatch(lock *Lock, etag string) (bool, error) {
+	return b.putIf(lock, "If-Match", etag)
+}
+
+func (b *S3Backend) putIf(lock *Lock, header, value string) (bool, error) {
+	req, _ := b.S3Service().PutObjectRequest(&s3.PutObjectInput{
+		Bucket:               aws.String(b.Bucket),
+		Key:                  aws.String(b.objectKey(lock.Key)),
+		Body:                 bytes.NewReader(lock.encode()),
+		ContentType:          aws.String("application/json"),
+		ACL:                  aws.String(s3.ObjectCannedACLBucketOwnerFullControl),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+	req.HTTPRequest.Header.Set(header, value)
+	err := req.Send()
+	if isPreconditionFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isPreconditionFailed is true when a conditional write lost, e.g. to another process taking the lock
+func isPreconditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == "PreconditionFailed" || aerr.Code() == "ConditionalRequestConflict" || aerr.Code() == "NoSuchKey")
+}
+
+// read is the lock on the key and the etag of its object, nil when it isnt held
+func (b *S3Backend) read(key string) (*Lock, string, error) {
+	resp, err := b.S3Service().GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(b.objectKey(key)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchKey" {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	l, err := decodeLock(body)
+	return l, aws.StringValue(resp.ETag), err
+}
+
+// TryAcquire takes an expired lock over by replacing the object only if it is still the expired one,
+// so of the processes taking it over at the same time only one gets it
+func (b *S3Backend) TryAcquire(lock *Lock) (*Lock, error) {
+	var holder *Lock
+	for attempt := 0; attempt < 2; attempt++ {
+		acquired, err := b.putIfAbsent(lock)
+		if err != nil || acquired {
+			return nil, err
+		}
+		var etag string
+		holder, etag, err = b.read(lock.Key)
+		if err != nil {
+			return nil, err
+		}
+		if holder == nil {
+			continue // released meanwhile
+		}
+		if !holder.Expired() {
+			return holder, nil
+		}
+		log.Warnf("Lock: %s held by: %s expired, taking it over", lock.Key, holder)
+		acquired, err = b.putIfMatch(lock, etag)
+		if err != nil || acquired {
+			return nil, err
+		}
+		// taken over or released meanwhile
+	}
+	return holder, nil
+}
+
+// Renew replaces the lock object only if it is still the one read, i.e. held by the lock
+func (b *S3Backend) Renew(lock *Lock) error {
+	holder, etag, err := b.read(lock.Key)
+	if err != nil {
+		return err
+	}
+	if holder == nil || holder.Id != lock.Id {
+		return fmt.Errorf("lock: %s is no longer held", lock.Key)
+	}
+	renewed, err := b.putIfMatch(lock, etag)
+	if err == nil && !renewed {
+		err = fmt.Errorf("lock: %s was taken over", lock.Key)
+	}
+	return err
+}
+
+// Release deletes the lock object only if it is still the one read, i.e. held by the lock
+func (b *S3Backend) Release(lock *Lock) error {
+	holder, etag, err := b.read(lock.Key)
+	if err != nil || holder == nil {
+		return err
+	}
+	if holder.Id != lock.Id {
+		log.Warnf("Lock: %s is held by: %s, not releasing it", lock.Key, holder)
+		return nil
+	}
+	req, _ := b.S3Service().DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(b.objectKey(lock.Key)),
+	})
+	req.HTTPRequest.Header.Set("If-Match", etag)
+	if err = req.Send(); isPreconditionFailed(err) {
+		log.Warnf("Lock: %s was taken over, not releasing it", lock.Key)
+		return nil
+	}
+	return err
+}
+
+func (b *S3Backend) List() ([]*Lock, error) {
+	keys := []string{}
+	prefix := strings.TrimSuffix(b.objectKey(""), ".lock")
+	err := b.S3Service().ListObjectsPages(&s3.ListObjectsInput{
+		Bucket: aws.String(b.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if k := aws.StringValue(obj.Key); strings.HasSuffix(k, ".lock") {
+				keys = append(keys, strings.TrimSuffix(strings.TrimPrefix(k, prefix), ".lock"))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	result := []*Lock{}
+	for _, k := range keys {
+		l, _, err := b.read(k)
+		if err != nil {
+			log.Warnf("Error reading lock: %s %v", k, err)
+			continue
+		}
+		if l != nil {
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+func (b *S3Backend) Break(key string) error {
+	_, err := b.S3Service().DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(b.objectKey(key)),
+	})
+	return err
+}
+
+func (b *S3Backend) String() string {
+	return "s3://" + strings.TrimSuffix(b.Bucket+"/"+b.Prefix, "/")
+}
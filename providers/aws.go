@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/capitalone/stack-deployment-tool/sdt"
@@ -35,8 +36,11 @@ import (
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/servicecatalog"
+	"github.com/aws/aws-sdk-go/service/sts"
 	ini "github.com/go-ini/ini"
 )
 
@@ -53,6 +57,8 @@ type AWSApi struct {
 	cfsrvc  *cloudformation.CloudFormation
 	s3srvc  *s3.S3
 	ec2srvc *ec2.EC2
+	scsrvc  *servicecatalog.ServiceCatalog
+	ddbsrvc *dynamodb.DynamoDB
 	dryMode bool
 }
 
@@ -64,6 +70,29 @@ func NewAWSApiWithHttpClient(httpClient *http.Client) *AWSApi {
 	return &AWSApi{Session: createSession(httpClient)}
 }
 
+// NewAWSApiForAccount returns an api for another account and/or region, sharing the config and http client of base.
+// When the account differs from the current one, the cross account role is assumed in the target account
+// with the credentials of base.
+func NewAWSApiForAccount(base *AWSApi, account, region, role string) (*AWSApi, error) {
+	if len(region) == 0 {
+		region = aws.StringValue(base.Session.Config.Region)
+	}
+	api := &AWSApi{Session: base.Session.Copy(aws.NewConfig().WithRegion(region)), dryMode: base.dryMode}
+	if len(account) == 0 || account == base.AccountId() {
+		return api, nil
+	}
+
+	crossRoleArn := CrossAccountRoleArn(account, role)
+	if len(crossRoleArn) == 0 {
+		return nil, fmt.Errorf("no cross account role found for account: %s", account)
+	}
+	log.Infof("Assuming Cross Account Role: %s", crossRoleArn)
+	api.Session.Config.Credentials = stscreds.NewCredentials(base.Session,
+		crossRoleArn,
+		func(p *stscreds.AssumeRoleProvider) { p.RoleSessionName = sessionName() })
+	return api, nil
+}
+
 func sessionName() string {
 	return fmt.Sprintf("%s-%d", utils.GetenvWithDefault("USER", ""), time.Now().UTC().Unix())
 }
@@ -102,6 +131,28 @@ func RoleArn() string {
 	return *roleArn
 }
 
+// CrossAccountRoleName is the role to assume in other accounts, defaults to the name of the current role
+func CrossAccountRoleName() string {
+	if name := viper.GetString("CrossAccountRole"); len(name) > 0 {
+		return name
+	}
+	role := RoleArn()
+	if idx := strings.LastIndex(role, "/"); idx >= 0 {
+		return role[idx+1:]
+	}
+	return ""
+}
+
+func CrossAccountRoleArn(account, role string) string {
+	if len(role) == 0 {
+		role = CrossAccountRoleName()
+	}
+	if len(role) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s", account, role)
+}
+
 func Region() string {
 	return utils.GetenvWithDefault("AWS_REGION", utils.GetenvWithDefault("AWS_DEFAULT_REGION", "us-east-1"))
 }
@@ -179,3 +230,39 @@ func (a *AWSApi) EC2Service() *ec2.EC2 {
 	}
 	return a.ec2srvc
 }
+
+func (a *AWSApi) SCService() *servicecatalog.ServiceCatalog {
+	if a.scsrvc == nil {
+		a.MustHaveAccess()
+		a.scsrvc = servicecatalog.New(a.Session, a.Session.Config)
+	}
+	return a.scsrvc
+}
+
+func (a *AWSApi) DynamoDBService() *dynamodb.DynamoDB {
+	if a.ddbsrvc == nil {
+		a.MustHaveAccess()
+		a.ddbsrvc = dynamodb.New(a.Session, a.Session.Config)
+	}
+	return a.ddbsrvc
+}
+
+// CallerArn is the user or role ARN of the current credentials
+func (a *AWSApi) CallerArn() string {
+	resp, err := sts.New(a.Session, a.Session.Config).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.Errorf("Error getting caller identity: %v", err)
+		return ""
+	}
+	return aws.StringValue(resp.Arn)
+}
+
+// AccountId of the current credentials
+func (a *AWSApi) AccountId() string {
+	resp, err := sts.New(a.Session, a.Session.Config).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.Errorf("Error getting caller identity: %v", err)
+		return ""
+	}
+	return aws.StringValue(resp.Account)
+}
@@ -0,0 +1,242 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/capitalone/stack-deployment-tool/store"
+	"github.com/capitalone/stack-deployment-tool/versioning"
+
+	log "github.com/Sirupsen/logrus"
+	jsonptr "github.com/dustin/go-jsonpointer"
+)
+
+const (
+	AuditDeploy   = "deploy"
+	AuditDelete   = "delete"
+	AuditRollback = "rollback"
+
+	OutcomeSuccess = "success"
+	OutcomeNotRun  = "not_run"
+)
+
+// AuditRecord is who ran a stacks operation on which stacks, when and with what outcome
+type AuditRecord struct {
+	Time            time.Time    `json:"time"`
+	Operation       string       `json:"operation"`
+	User            string       `json:"user"` // user or role ARN
+	Env             string       `json:"env"`
+	Stacks          []AuditStack `json:"stacks"`
+	Outcome         string       `json:"outcome"`
+	Error           string       `json:"error,omitempty"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	GitCommit       string       `json:"git_commit"`
+	GitBranch       string       `json:"git_branch"`
+	BuildURL        string       `json:"build_url,omitempty"`
+	Ticket          string       `json:"ticket,omitempty"`
+
+	config        *StacksConfig
+	removeOnFatal func()
+}
+
+type AuditStack struct {
+	Label       string `json:"label"`
+	Name        string `json:"name"`
+	ChangeSetId string `json:"change_set_id,omitempty"`
+	Outcome     string `json:"outcome"`
+}
+
+// StartAudit starts the audit record of a stacks operation on the env stacks
+func StartAudit(operation string, envStacks *EnvStacksConfig, user string) *AuditRecord {
+	record := &AuditRecord{
+		Time:      time.Now().UTC(),
+		Operation: operation,
+		User:      user,
+		Env:       envStacks.Env,
+		Stacks:    []AuditStack{},
+		GitCommit: strings.TrimSpace(versioning.GitHash()),
+		GitBranch: versioning.CurrentBranch(),
+		BuildURL:  os.Getenv("BUILD_URL"),
+		config:    envStacks.Config,
+	}
+	for _, label := range envStacks.StackLabels {
+		stack := envStacks.Stack(label)
+		record.Stacks = append(record.Stacks, AuditStack{Label: label, Name: stack.Name(), Outcome: OutcomeNotRun})
+	}
+	// log.Fatal exits without returning, record the failure on the way out
+	record.removeOnFatal = onFatal(func(msg string) { record.Finish(errors.New(msg)) })
+	return record
+}
+
+// Stack records the outcome of the operation on a stack, a nil record is not audited
+func (r *AuditRecord) Stack(stack *StackConfig, changeSetId string, outcome string) {
+	if r == nil {
+		return
+	}
+	for i := range r.Stacks {
+		if r.Stacks[i].Name == stack.Name() {
+			r.Stacks[i].ChangeSetId = changeSetId
+			r.Stacks[i].Outcome = outcome
+			return
+		}
+	}
+	r.Stacks = append(r.Stacks, AuditStack{Label: stack.Label(), Name: stack.Name(), ChangeSetId: changeSetId, Outcome: outcome})
+}
+
+// Finish writes the record with the outcome of the operation
+func (r *AuditRecord) Finish(err error) error {
+	if r == nil {
+		return nil
+	}
+	r.removeOnFatal()
+
+	r.Outcome = OutcomeSuccess
+	if err != nil {
+		r.Outcome = OutcomeFailed
+		r.Error = err.Error()
+	}
+	r.DurationSeconds = time.Since(r.Time).Seconds()
+	if werr := writeAuditRecord(r.config, r); werr != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the audit log: %v\n", werr)
+		return werr
+	}
+	return nil
+}
+
+// AuditLogFile is the JSONL audit log, the audit_log file of the stacks yaml or audit.jsonl in the state dir
+func (c *StacksConfig) AuditLogFile() string {
+	file, _ := c.ProcessValue(jsonptr.Get(c.Yaml, "/audit_log/file")).(string)
+	if len(file) == 0 {
+		return filepath.Join(c.StateDir(), "audit.jsonl")
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(c.FileName), file)
+	}
+	return file
+}
+
+// AuditStore is the optional audit_log s3 prefix of the stacks yaml, nil when not configured
+func (c *StacksConfig) AuditStore() (store.Store, error) {
+	location, _ := c.ProcessValue(jsonptr.Get(c.Yaml, "/audit_log/s3")).(string)
+	if len(location) == 0 {
+		return nil, nil
+	}
+	return store.New(location, filepath.Dir(c.FileName))
+}
+
+func writeAuditRecord(c *StacksConfig, r *AuditRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	file := c.AuditLogFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	st, err := c.AuditStore()
+	if err != nil || st == nil {
+		return err
+	}
+	key := fmt.Sprintf("%s/%s-%s-%d.json", r.Env, r.Time.Format("20060102T150405Z"), r.Operation, r.Time.UnixNano())
+	return st.Put(key, b)
+}
+
+// ReadAuditLog reads the audit records of an env, oldest first, from the audit_log s3 prefix when configured
+// or the local audit log file
+func ReadAuditLog(c *StacksConfig, env string) ([]*AuditRecord, error) {
+	records := []*AuditRecord{}
+	st, err := c.AuditStore()
+	if err != nil {
+		return nil, err
+	}
+
+	if st != nil {
+		keys, err := st.List(env + "/")
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			b, err := st.Get(k)
+			if err != nil {
+				return nil, err
+			}
+			if r, err := decodeAuditRecord(b); err == nil {
+				records = append(records, r)
+			}
+		}
+	} else {
+		f, err := os.Open(c.AuditLogFile())
+		if os.IsNotExist(err) {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			if r, err := decodeAuditRecord(scanner.Bytes()); err == nil && r.Env == env {
+				records = append(records, r)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+func decodeAuditRecord(b []byte) (*AuditRecord, error) {
+	r := &AuditRecord{}
+	if err := json.Unmarshal(b, r); err != nil {
+		log.Debugf("skipping audit record: %s %v", b, err)
+		return nil, err
+	}
+	return r, nil
+}
+
+// HasStack is true when the operation included one of the stack labels
+func (r *AuditRecord) HasStack(labels []string) bool {
+	for _, s := range r.Stacks {
+		if arrayContainsStr(labels, s.Label) {
+			return true
+		}
+	}
+	return false
+}
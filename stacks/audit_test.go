@@ -0,0 +1,95 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	viper.Set("StateDir", dir)
+	defer viper.Set("StateDir", "")
+	os.Setenv("BUILD_URL", "https://ci.example.com/job/1")
+	defer os.Unsetenv("BUILD_URL")
+
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	assert.Equal(t, dir+"/audit.jsonl", c.AuditLogFile())
+	qa3 := c.FetchEnvStacks("qa3")
+
+	record := StartAudit(AuditDeploy, qa3, "arn:aws:iam::000000000000:role/Developer")
+	record.Stack(qa3.Stack("nagios-elb"), "arn:changeset", OutcomeComplete)
+	assert.Nil(t, record.Finish(nil))
+
+	// a fatal error finishes the pending record
+	record = StartAudit(AuditDelete, c.FetchEnvStacks("qa3.nagios-server"), "arn:aws:iam::000000000000:user/me")
+	assert.Nil(t, (&fatalHook{}).Fire(&log.Entry{Message: "Stack: nagios-server-qa3 failed"}))
+	assert.Nil(t, (&fatalHook{}).Fire(&log.Entry{Message: "not recorded twice"}))
+
+	StartAudit(AuditDeploy, c.FetchEnvStacks("qa2"), "arn:aws:iam::000000000000:user/me").Finish(errors.New("boom"))
+
+	records, err := ReadAuditLog(c, "qa3")
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+
+	deploy := records[0]
+	assert.Equal(t, AuditDeploy, deploy.Operation)
+	assert.Equal(t, OutcomeSuccess, deploy.Outcome)
+	assert.Equal(t, "https://ci.example.com/job/1", deploy.BuildURL)
+	assert.Equal(t, AuditStack{Label: "nagios-elb", Name: "nagios-elb-qa3", ChangeSetId: "arn:changeset", Outcome: OutcomeComplete}, deploy.Stacks[0])
+	assert.Equal(t, OutcomeNotRun, deploy.Stacks[1].Outcome)
+	assert.True(t, deploy.HasStack([]string{"nagios-elb"}))
+
+	del := records[1]
+	assert.Equal(t, AuditDelete, del.Operation)
+	assert.Equal(t, OutcomeFailed, del.Outcome)
+	assert.Equal(t, "Stack: nagios-server-qa3 failed", del.Error)
+	assert.False(t, del.HasStack([]string{"nagios-elb"}))
+
+	records, err = ReadAuditLog(c, "qa2")
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "boom", records[0].Error)
+
+	// drymode is not audited
+	var none *AuditRecord
+	none.Stack(qa3.Stack("nagios-elb"), "", OutcomeComplete)
+	assert.Nil(t, none.Finish(nil))
+}
+
+func TestAuditStore(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	st, err := c.AuditStore()
+	assert.Nil(t, err)
+	assert.Nil(t, st)
+
+	c.Yaml["audit_log"] = map[string]interface{}{"file": "logs/audit.jsonl", "s3": "s3://audit-bucket/sdt"}
+	assert.Equal(t, "../resources/logs/audit.jsonl", c.AuditLogFile())
+	st, err = c.AuditStore()
+	assert.Nil(t, err)
+	assert.Equal(t, "s3://audit-bucket/sdt", st.String())
+}
@@ -27,22 +27,35 @@ import (
 
 	"github.com/capitalone/stack-deployment-tool/providers"
 	"github.com/capitalone/stack-deployment-tool/utils"
+	"github.com/capitalone/stack-deployment-tool/versioning"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/servicecatalog"
 )
 
 const (
 	max_wait_time = 15 * time.Minute
+
+	// polls of an unchanged status before it is taken as the result of the operation, not of the one before
+	staleStatusPolls = 2
 )
 
 type AWSStackApi struct {
 	providers.AWSApi
+	accountApis map[string]*AWSStackApi // account/region -> api
+	options     DeployOptions
+
+	templateBucket *TemplateBucket // where large templates are uploaded, see useTemplateBucket
 }
 
 func NewAWSStackApi(api *providers.AWSApi) *AWSStackApi {
-	return &AWSStackApi{AWSApi: *api}
+	return &AWSStackApi{AWSApi: *api, accountApis: make(map[string]*AWSStackApi)}
+}
+
+func (a *AWSStackApi) DeployOptions(opts DeployOptions) {
+	a.options = opts
 }
 
 func (a *AWSStackApi) FindDeploymentOutput(stackName string, outputKey string) (string, error) {
@@ -60,6 +73,83 @@ func (a *AWSStackApi) FindDeploymentOutput(stackName string, outputKey string) (
 	return "", fmt.Errorf("stack (%s) output key (%s) not found", stackName, outputKey)
 }
 
+func (a *AWSStackApi) FindExport(exportName string) (string, error) {
+	log.Debugf("FindExport(%s)", exportName)
+	params := &cloudformation.ListExportsInput{}
+	for {
+		resp, err := a.CFService().ListExports(params)
+		if err != nil {
+			return "", err
+		}
+		for _, e := range resp.Exports {
+			if aws.StringValue(e.Name) == exportName {
+				return aws.StringValue(e.Value), nil
+			}
+		}
+		if resp.NextToken == nil {
+			break
+		}
+		params.NextToken = resp.NextToken
+	}
+	return "", fmt.Errorf("export (%s) not found", exportName)
+}
+
+// FindProvisionedProductOutput looks up a Service Catalog provisioned product by its name,
+// since the stack name generated for it changes whenever it is reprovisioned.
+func (a *AWSStackApi) FindProvisionedProductOutput(productName string, outputKey string) (string, error) {
+	log.Debugf("FindProvisionedProductOutput(%s, %s)", productName, outputKey)
+	product, err := a.findProvisionedProduct(productName)
+	if err != nil {
+		return "", err
+	}
+	resp, err := a.SCService().DescribeRecord(&servicecatalog.DescribeRecordInput{Id: product.LastRecordId})
+	if err != nil {
+		return "", err
+	}
+	for _, o := range resp.RecordOutputs {
+		log.Debugf("provisioned product %s output: %#v", productName, o)
+		if aws.StringValue(o.OutputKey) == outputKey {
+			return aws.StringValue(o.OutputValue), nil
+		}
+	}
+	return "", fmt.Errorf("provisioned product (%s) output key (%s) not found", productName, outputKey)
+}
+
+func (a *AWSStackApi) findProvisionedProduct(productName string) (*servicecatalog.ProvisionedProductDetail, error) {
+	params := &servicecatalog.ScanProvisionedProductsInput{}
+	for {
+		resp, err := a.SCService().ScanProvisionedProducts(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range resp.ProvisionedProducts {
+			if aws.StringValue(p.Name) == productName && p.LastRecordId != nil {
+				return p, nil
+			}
+		}
+		if resp.NextPageToken == nil {
+			break
+		}
+		params.PageToken = resp.NextPageToken
+	}
+	return nil, fmt.Errorf("provisioned product (%s) not found", productName)
+}
+
+func (a *AWSStackApi) FindDeploymentOutputInAccount(account, region, role, stackName, outputKey string) (string, error) {
+	log.Debugf("FindDeploymentOutputInAccount(%s, %s, %s, %s)", account, region, stackName, outputKey)
+	apiKey := fmt.Sprintf("%s/%s/%s", account, region, role)
+	accountApi, ok := a.accountApis[apiKey]
+	if !ok {
+		api, err := providers.NewAWSApiForAccount(&a.AWSApi, account, region, role)
+		if err != nil {
+			return "", err
+		}
+		accountApi = NewAWSStackApi(api)
+		a.accountApis[apiKey] = accountApi
+	}
+	return accountApi.FindDeploymentOutput(stackName, outputKey)
+}
+
 func (a *AWSStackApi) FindStack(stackName string) *cloudformation.Stack {
 	stackOutput, err := a.CFService().DescribeStacks(&cloudformation.DescribeStacksInput{StackName: &stackName})
 
@@ -76,13 +166,22 @@ func (a *AWSStackApi) FindStack(stackName string) *cloudformation.Stack {
 	return stackOutput.Stacks[0]
 }
 
+func (a *AWSStackApi) FindStackStatus(stackName string) string {
+	stack := a.FindStack(stackName)
+	if stack == nil {
+		return "Not Found"
+	}
+	return *stack.StackStatus
+}
+
 func changeSetName(stackName string) string {
 	return fmt.Sprintf("%s-%d", stackName, time.Now().Unix())
 }
 
 // TODO: wait for stack param
+// updateStack updates the stack with a change set and returns its id, settings are applied before it, nil settings are left as is
 func (a *AWSStackApi) updateStack(stack *cloudformation.Stack, template string,
-	parameters map[string]interface{}, tags map[string]interface{}) {
+	parameters map[string]interface{}, tags map[string]interface{}, settings *stackSettings) (string, error) {
 
 	stackName := *stack.StackName
 	log.Debugf("updateStack stack: %s", stackName)
@@ -95,55 +194,89 @@ func (a *AWSStackApi) updateStack(stack *cloudformation.Stack, template string,
 
 	// short-circuit in drymode
 	if a.IsDryMode() {
-		return
+		return "", nil
 	}
 
 	changeSetName := changeSetName(stackName)
 
-	params := &cloudformation.CreateChangeSetInput{
-		Capabilities:     stack.Capabilities,
-		ChangeSetName:    aws.String(changeSetName),
-		StackName:        aws.String(stackName),
-		NotificationARNs: stack.NotificationARNs,
-		Parameters:       cfparams,
-		Tags:             cftags,
-		TemplateBody:     aws.String(template),
-	}
-
-	resp, err := a.CFService().CreateChangeSet(params)
+	body, url, err := a.templateSource(stackName, template)
+	if err != nil {
+		return "", err
+	}
+	params := &createChangeSetInput{
+		Capabilities:          stack.Capabilities,
+		ChangeSetName:         aws.String(changeSetName),
+		StackName:             aws.String(stackName),
+		NotificationARNs:      stack.NotificationARNs,
+		Parameters:            cfparams,
+		RollbackConfiguration: settings.rollbackConfiguration(),
+		Tags:                  cftags,
+		TemplateBody:          body,
+		TemplateURL:           url,
+	}
+
+	resp := &cloudformation.CreateChangeSetOutput{}
+	err = a.cfRequest("CreateChangeSet", params, resp)
 	if err != nil {
 		log.Errorf("Error determining a changeset: %s", err)
-		return
+		return "", err
 	}
 	if resp.Id != nil {
 		// wait for changeset to be created...
-		a.waitForChangeSet(*resp.Id)
+		changeSet := a.waitForChangeSet(*resp.Id)
+
+		if err = a.applyStackSettings(stackName, settings); err != nil {
+			return aws.StringValue(resp.Id), err
+		}
+		if isEmptyChangeSet(changeSet) {
+			log.Infof("Stack: %s has no changes to apply", stackName)
+			return aws.StringValue(resp.Id), nil
+		}
+		if err = changeSetError(changeSet); err != nil {
+			return aws.StringValue(resp.Id), err
+		}
 
 		a.printChangeSet(*resp.Id)
+		var restorePolicy string
+		if restorePolicy, err = a.overrideStackPolicy(stackName, settings); err != nil {
+			return aws.StringValue(resp.Id), err
+		}
 		_, err = a.CFService().ExecuteChangeSet(&cloudformation.ExecuteChangeSetInput{
 			ChangeSetName: aws.String(*resp.Id),
 		})
-
 		if err != nil {
-			log.Errorf("Error applying a changeset: %s", err)
+			err = fmt.Errorf("Error applying a changeset: %v", err)
+		} else {
+			err = a.waitForStackOperation(stackName, settings.monitoring())
+		}
+		if len(restorePolicy) > 0 {
+			if perr := a.setStackPolicy(stackName, restorePolicy); perr != nil {
+				log.Errorf("%v", perr)
+			}
+		}
+		if err == nil && url != nil {
+			a.cleanupTemplates(stackName)
 		}
-
-		err = a.waitForStackOperation(stackName)
 	}
+	return aws.StringValue(resp.Id), err
 }
 
 func (a *AWSStackApi) PrintChangesToStacks(envStacks *EnvStacksConfig) {
 	log.Debugf("PrintChangesToStacks: %#v", envStacks.StackLabels)
+	a.useTemplateBucket(envStacks.Config)
+	if err := envStacks.CheckExternalDeps(a); err != nil {
+		log.Warnf("%v", err)
+	}
+	if findings, err := PolicyFindings(envStacks); err != nil {
+		log.Warnf("%v", err)
+	} else {
+		printFindings(findings)
+	}
 	for _, stackLabel := range envStacks.StackLabels {
 		//stackmap := ToStrMap(envStacks.Fetch(stackLabel))
 		stack := envStacks.Stack(stackLabel)
-		templateName := stackLabel
 		stackmap := utils.ToStrMap(stack.FetchAll())
-		if n, ok := stackmap["template"]; ok {
-			templateName = n.(string)
-		}
-		p := filepath.Dir(envStacks.Config.FileName)
-		template := a.loadTemplateJSON(filepath.Join(p, templateName), filepath.Join(p, stack.Name()))
+		template := a.packagedTemplate(stack)
 		params := utils.ToStrMap(stackmap["parameters"])
 		tags := utils.ToStrMap(stackmap["tags"])
 		a.determineChangeSet(stack.Name(), template, params, tags)
@@ -177,13 +310,19 @@ func (a *AWSStackApi) determineChangeSet(stackName string, template string,
 
 	changeSetName := fmt.Sprintf("%s-%d", stackName, time.Now().Unix())
 
+	body, url, err := a.templateSource(stackName, template)
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
 	params := &cloudformation.CreateChangeSetInput{
 		ChangeSetName: aws.String(changeSetName),
 		StackName:     aws.String(stackName),
 		//NotificationARNs: stack.NotificationARNs,
 		Parameters:   cfparams,
 		Tags:         cftags,
-		TemplateBody: aws.String(template),
+		TemplateBody: body,
+		TemplateURL:  url,
 		// some rakefiles were default to DO_NOTHING, but i think we default to rollback..
 		//OnFailure: aws.String(cloudformation.OnFailureRollback),
 	}
@@ -212,44 +351,239 @@ func (a *AWSStackApi) deleteChangeSet(changeSetName string) {
 
 func (a *AWSStackApi) CreateOrUpdateStacks(envStacks *EnvStacksConfig) {
 	log.Debugf("Creating stacks: %#v", envStacks.StackLabels)
+	a.checkProtection(AuditDeploy, envStacks)
+	a.checkPolicies(envStacks)
+	defer a.lockEnv(envStacks)()
+	a.useTemplateBucket(envStacks.Config)
+	if err := envStacks.CheckExternalDeps(a); err != nil {
+		log.Fatalf("%v", err)
+	}
+	audit := a.startAudit(AuditDeploy, envStacks)
+	checkpoint := a.startCheckpoint(envStacks)
+	restorePoints := []*stackRestorePoint{}
 	for _, stackLabel := range envStacks.StackLabels {
 		//stack := ToStrMap(envStacks.Fetch(stackName))
 		stack := envStacks.Stack(stackLabel)
 		stackmap := utils.ToStrMap(stack.FetchAll())
-		templateName := stackLabel
-		if n, ok := stackmap["template"]; ok {
-			templateName = n.(string)
-		}
-		p := filepath.Dir(envStacks.Config.FileName)
-		template := a.loadTemplateJSON(filepath.Join(p, templateName), filepath.Join(p, stack.Name()))
+		template := a.packagedTemplate(stack)
 		params := utils.ToStrMap(stackmap["parameters"])
-		tags := utils.ToStrMap(stackmap["tags"])
+		if problems := checkParameters(template, params, false); len(problems) > 0 {
+			log.Fatalf("Stack: %s parameters:\n  %s", stack.Name(), strings.Join(problems, "\n  "))
+		}
+		settings, err := a.stackSettings(stack)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		hash := contentHash(template, params, utils.ToStrMap(stackmap["tags"]), settings)
+		tags := withContentHash(utils.ToStrMap(stackmap["tags"]), hash)
 
+		done, err := checkpoint.IsDone(stackLabel, hash)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if done {
+			log.Infof("Stack: %s already deployed, resuming after it", stack.Name())
+			continue
+		}
+
+		outcome := OutcomeComplete
+		changeSetId := ""
+		var rp *stackRestorePoint
 		existingStack := a.FindStack(stack.Name())
-		if existingStack == nil {
-			a.createStack(stack.Name(), template, params, tags)
+		if existingStack != nil && !a.options.Force && isStackUnchanged(existingStack, hash) {
+			log.Infof("Stack: %s unchanged, skipping (use --force to deploy)", stack.Name())
+			outcome = OutcomeUnchanged
+		} else if existingStack == nil {
+			rp = &stackRestorePoint{stackName: stack.Name(), created: true}
+			err = a.createStack(stack.Name(), template, params, tags, settings)
 		} else {
-			a.updateStack(existingStack, template, params, tags)
+			if a.options.Atomic && !a.IsDryMode() {
+				rp, err = a.restorePoint(existingStack)
+			}
+			if err == nil {
+				changeSetId, err = a.updateStack(existingStack, template, params, tags, settings)
+			}
 		}
 
+		if err != nil {
+			outcome = OutcomeFailed
+		}
+		audit.Stack(stack, changeSetId, outcome)
+		if cerr := checkpoint.Record(stackLabel, outcome, hash); cerr != nil {
+			log.Warnf("Error saving checkpoint: %v", cerr)
+		}
+		// a failed update was rolled back by CloudFormation, a failed create still needs deleting
+		if rp != nil && (err == nil || rp.created) {
+			restorePoints = append(restorePoints, rp)
+		}
+		if err == nil && outcome == OutcomeComplete {
+			a.saveSnapshot(envStacks.Config, newSnapshot(stack, template, params, tags))
+		}
+		if err != nil && a.options.Atomic && !a.IsDryMode() {
+			a.rollbackStacks(restorePoints)
+			if cerr := checkpoint.Remove(); cerr != nil {
+				log.Warnf("Error removing checkpoint: %v", cerr)
+			}
+			log.Fatalf("Stack: %s failed: %v, rolled back the stacks deployed by this run", stack.Name(), err)
+		}
+		if err != nil {
+			log.Fatalf("Stack: %s failed: %v (rerun with --resume to continue from it)", stack.Name(), err)
+		}
+	}
+	if err := checkpoint.Remove(); err != nil {
+		log.Warnf("Error removing checkpoint: %v", err)
 	}
+	audit.Finish(nil)
 	log.Info("Stacks Create Complete")
 }
 
+// RollbackStack redeploys a snapshot of a previous deploy of the stack
+func (a *AWSStackApi) RollbackStack(envStacks *EnvStacksConfig, to string) {
+	if len(envStacks.StackLabels) != 1 {
+		log.Fatalf("Rollback a single stack: -s <environment>.<stack label>, selected: %v", envStacks.StackLabels)
+	}
+	a.checkProtection(AuditRollback, envStacks)
+	a.useTemplateBucket(envStacks.Config)
+	stack := envStacks.Stack(envStacks.StackLabels[0])
+	st, err := envStacks.Config.SnapshotStore()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	snapshot, err := FindSnapshot(st, stack.Env(), stack.Label(), to)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Infof("Rolling back stack: %s to snapshot: %s deployed: %s git commit: %s",
+		snapshot.StackName, snapshot.Id, snapshot.Created, snapshot.GitCommit)
+
+	defer a.lockEnv(envStacks)()
+	audit := a.startAudit(AuditRollback, envStacks)
+	changeSetId := ""
+	existingStack := a.FindStack(snapshot.StackName)
+	if masked := maskedParams(snapshot.Parameters); existingStack == nil && len(masked) > 0 {
+		// the masked values would keep the current values, a deleted stack has none
+		err = fmt.Errorf("the stack was deleted and the snapshot doesnt store the NoEcho parameters: %s, deploy it instead",
+			strings.Join(masked, ", "))
+	} else if existingStack == nil {
+		err = a.createStack(snapshot.StackName, snapshot.Template, snapshot.Parameters, snapshot.Tags, nil)
+	} else {
+		changeSetId, err = a.updateStack(existingStack, snapshot.Template, snapshot.Parameters, snapshot.Tags, nil)
+	}
+	if err != nil {
+		audit.Stack(stack, changeSetId, OutcomeFailed)
+		log.Fatalf("Stack: %s rollback failed: %v", snapshot.StackName, err)
+	}
+
+	rollback := *snapshot
+	rollback.RollbackOf = snapshot.Id
+	rollback.setId(time.Now().UTC())
+	a.saveSnapshot(envStacks.Config, &rollback)
+	audit.Stack(stack, changeSetId, OutcomeComplete)
+	audit.Finish(nil)
+	log.Info("Stack Rollback Complete")
+}
+
+// startAudit starts the audit record of an operation, drymode is not audited
+func (a *AWSStackApi) startAudit(operation string, envStacks *EnvStacksConfig) *AuditRecord {
+	if a.IsDryMode() {
+		return nil
+	}
+	record := StartAudit(operation, envStacks, a.CallerArn())
+	record.Ticket = a.options.Ticket
+	return record
+}
+
+// checkProtection enforces the protection of the envs of the stacks before changing them, drymode only reports it
+func (a *AWSStackApi) checkProtection(operation string, envStacks *EnvStacksConfig) {
+	protections, err := envStacks.Protections()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(protections) == 0 {
+		return
+	}
+	branch := versioning.CurrentBranch()
+	for _, protection := range protections {
+		violations := protection.Violations(branch, time.Now(), a.options.Ticket)
+		if a.IsDryMode() {
+			log.Infof("Environment: %s is protected by: %s", protection.Env, protection)
+			for _, v := range violations {
+				log.Warnf("Would refuse to %s: %s", operation, v)
+			}
+			continue
+		}
+		if len(violations) > 0 {
+			log.Fatalf("Refusing to %s %s: %s", operation, protection.Env, strings.Join(violations, "; "))
+		}
+		if protection.Confirm && !protection.Confirmed(operation, confirmInput, os.Stderr) {
+			log.Fatalf("Refusing to %s %s: not confirmed", operation, protection.Env)
+		}
+	}
+}
+
+// saveSnapshot keeps the deploy history, failing to save it doesnt fail the deploy
+func (a *AWSStackApi) saveSnapshot(c *StacksConfig, snapshot *Snapshot) {
+	if a.IsDryMode() {
+		return
+	}
+	st, err := c.SnapshotStore()
+	if err == nil {
+		err = SaveSnapshot(st, snapshot)
+	}
+	if err != nil {
+		log.Warnf("Error saving the snapshot of stack: %s %v", snapshot.StackName, err)
+	}
+}
+
+// startCheckpoint loads the checkpoint to resume, or starts a new one. Drymode doesnt save it.
+func (a *AWSStackApi) startCheckpoint(envStacks *EnvStacksConfig) *Checkpoint {
+	checkpoint := NewCheckpoint(envStacks)
+	if a.options.Resume && a.options.Atomic {
+		log.Fatalf("--atomic cannot be combined with --resume, the interrupted run cannot be rolled back")
+	}
+	if a.options.Resume {
+		var err error
+		if checkpoint, err = LoadCheckpoint(envStacks); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("Resuming deploy of: %s started: %s", checkpoint.Env, checkpoint.Started)
+	}
+	if a.IsDryMode() {
+		checkpoint.file = ""
+	}
+	return checkpoint
+}
+
 func (a *AWSStackApi) DeleteStacks(envStacks *EnvStacksConfig) {
 	log.Debugf("Deleting stacks: %#v", envStacks.StackLabels)
+	a.checkProtection(AuditDelete, envStacks)
 
 	// short-circuit in drymode
 	if a.IsDryMode() {
+		for _, reason := range a.deleteBlockers(envStacks) {
+			log.Warnf("Would refuse to delete: %s", reason)
+		}
 		return
 	}
 
+	defer a.lockEnv(envStacks)()
+	if reasons := a.deleteBlockers(envStacks); len(reasons) > 0 {
+		log.Fatalf("Refusing to delete: %s", strings.Join(reasons, "; "))
+	}
+	audit := a.startAudit(AuditDelete, envStacks)
 	index := len(envStacks.StackLabels)
 	for index > 0 {
-		label := envStacks.StackLabels[index-1]
-		a.deleteStack(envStacks.Stack(label).Name())
+		stack := envStacks.Stack(envStacks.StackLabels[index-1])
+		// the stacks before it are its dependencies, they are kept while it exists
+		if err := a.deleteEnvStack(stack); err != nil {
+			audit.Stack(stack, "", OutcomeFailed)
+			audit.Finish(err)
+			log.Fatalf("Stack: %s delete failed: %v", stack.Name(), err)
+		}
+		audit.Stack(stack, "", OutcomeComplete)
 		index--
 	}
+	audit.Finish(nil)
 	log.Info("Stacks Delete Complete")
 }
 
@@ -261,26 +595,18 @@ func (a *AWSStackApi) StacksStatus(envStacks *EnvStacksConfig) {
 
 	for _, stackLabel := range envStacks.StackLabels {
 		stackName := envStacks.Stack(stackLabel).Name()
-		stack := a.FindStack(stackName)
-		if stack != nil {
-			tbl.WriteRow(stackName, *stack.StackStatus)
-		} else {
-			tbl.WriteRow(stackName, "Not Found")
-		}
+		tbl.WriteRow(stackName, a.FindStackStatus(stackName))
 	}
 	tbl.Footer()
 	fmt.Println()
 }
 
-func (a *AWSStackApi) loadTemplateJSON(templateNames ...string) string {
+// loadTemplate renders the first existing template file of the template names, applying the directives
+func loadTemplate(templateNames ...string) string {
 	var template string
 
 	for _, templateName := range templateNames {
-		// check for: .yml, .yaml, .json
-		possibles := []string{templateName, templateName + ".yml", templateName + ".yaml",
-			templateName + ".json", templateName + ".hjson"}
-
-		for _, p := range possibles {
+		for _, p := range templateFileNames(templateName) {
 			log.Debugf("looking for template: %s", p)
 			if utils.FileExists(p) {
 				b, err := ioutil.ReadFile(p)
@@ -318,6 +644,24 @@ func (a *AWSStackApi) loadTemplateJSON(templateNames ...string) string {
 	return template
 }
 
+// templateFileNames are the files checked for a template name: .yml, .yaml, .json
+func templateFileNames(templateName string) []string {
+	return []string{templateName, templateName + ".yml", templateName + ".yaml",
+		templateName + ".json", templateName + ".hjson"}
+}
+
+// findTemplateFile is the first existing template file of the template names
+func findTemplateFile(templateNames ...string) string {
+	for _, templateName := range templateNames {
+		for _, p := range templateFileNames(templateName) {
+			if utils.FileExists(p) {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
 func cftTags(tags map[string]interface{}) []*cloudformation.Tag {
 	cftags := []*cloudformation.Tag{}
 	for k, v := range tags {
@@ -329,9 +673,17 @@ func cftTags(tags map[string]interface{}) []*cloudformation.Tag {
 	return cftags
 }
 
+// cftParams are the parameters of a stack operation, masked NoEcho values keep the previous value of the stack
 func cftParams(parameters map[string]interface{}) []*cloudformation.Parameter {
 	cfparams := []*cloudformation.Parameter{}
 	for k, v := range parameters {
+		if v == noEchoValue {
+			cfparams = append(cfparams, &cloudformation.Parameter{
+				ParameterKey:     aws.String(k),
+				UsePreviousValue: aws.Bool(true),
+			})
+			continue
+		}
 		cfparams = append(cfparams, &cloudformation.Parameter{
 			ParameterKey:   aws.String(k),
 			ParameterValue: aws.String(v.(string)),
@@ -340,14 +692,31 @@ func cftParams(parameters map[string]interface{}) []*cloudformation.Parameter {
 	return cfparams
 }
 
+// maskedParams are the sorted parameters with the masked value of a NoEcho parameter
+func maskedParams(parameters map[string]interface{}) []string {
+	masked := map[string]bool{}
+	for k, v := range parameters {
+		if v == noEchoValue {
+			masked[k] = true
+		}
+	}
+	return setKeys(masked)
+}
+
 // TODO: on failure param
 // TODO: wait for stack param
 func (a *AWSStackApi) createStack(stackName string, template string,
-	parameters map[string]interface{}, tags map[string]interface{}) {
+	parameters map[string]interface{}, tags map[string]interface{}, settings *stackSettings) error {
 
 	log.Infof("createStack(%s)", stackName)
 	log.Debugf("createStack(%s, %s, %#v, %#v)", stackName, template, parameters, tags)
 
+	// a new stack has no previous value to keep
+	if masked := maskedParams(parameters); len(masked) > 0 {
+		return fmt.Errorf("Cannot create stack: %s the NoEcho parameters: %s are masked (%s), set their values",
+			stackName, strings.Join(masked, ", "), noEchoValue)
+	}
+
 	cftags := cftTags(tags)
 	log.Infof("CF Tags: %+v", cftags)
 
@@ -356,52 +725,78 @@ func (a *AWSStackApi) createStack(stackName string, template string,
 
 	// short-circuit in drymode
 	if a.IsDryMode() {
-		return
+		return nil
 	}
 
-	params := &cloudformation.CreateStackInput{
+	body, url, err := a.templateSource(stackName, template)
+	if err != nil {
+		return err
+	}
+	params := &createStackInput{
 		StackName: aws.String(stackName),
 		//NotificationARNs: stack.NotificationARNs,
 		Parameters:   cfparams,
 		Tags:         cftags,
-		TemplateBody: aws.String(template),
+		TemplateBody: body,
+		TemplateURL:  url,
 		// some rakefiles were default to DO_NOTHING, but i think we default to rollback..
 		OnFailure: aws.String(cloudformation.OnFailureRollback),
 	}
+	if settings != nil {
+		if len(settings.StackPolicy) > 0 {
+			params.StackPolicyBody = aws.String(settings.StackPolicy)
+		}
+		params.EnableTerminationProtection = settings.TerminationProtection
+		params.RollbackConfiguration = settings.rollbackConfiguration()
+	}
 
-	resp, err := a.CFService().CreateStack(params)
+	resp := &cloudformation.CreateStackOutput{}
+	err = a.cfRequest("CreateStack", params, resp)
 	if err != nil {
 		log.Errorf("Error creating stack: %+v", err)
-		return
+		return err
 	}
 	log.Infof("CreateStack Started: %s", resp)
-	err = a.waitForStackOperation(stackName)
+	err = a.waitForStackOperation(stackName, settings.monitoring())
 	if err != nil {
 		log.Errorf("Error waiting for stack operation: %+v", err)
+	} else if url != nil {
+		a.cleanupTemplates(stackName)
 	}
+	return err
 }
 
-func (a *AWSStackApi) deleteStack(stackName string) error {
+// deleteStack deletes the stack, when the delete fails it is deleted again keeping the failed retainResources
+func (a *AWSStackApi) deleteStack(stackName string, retainResources []string) error {
 	params := &cloudformation.DeleteStackInput{
-		StackName:       aws.String(stackName), // Required
-		RetainResources: []*string{
-		//aws.String("LogicalResourceId"), // Required
-		// More values...
-		},
-	}
-	_, err := a.CFService().DeleteStack(params)
-	if err != nil {
-		log.Errorf("Error deleting stack: %s", stackName)
-		return fmt.Errorf("Error deleting stack: %s %s", stackName, err)
+		StackName: aws.String(stackName), // Required
 	}
-	err = a.waitForStackOperation(stackName)
-	if err != nil && !strings.Contains(err.Error(), "does not exist") {
+	for {
+		_, err := a.CFService().DeleteStack(params)
+		if err != nil {
+			log.Errorf("Error deleting stack: %s", stackName)
+			return fmt.Errorf("Error deleting stack: %s %s", stackName, err)
+		}
+		err = a.waitForStackOperation(stackName, 0)
+		if err == nil || strings.Contains(err.Error(), "does not exist") {
+			return nil
+		}
 		log.Errorf("Error deleting stack: %s error: %s", stackName, err)
+		if len(params.RetainResources) > 0 || a.FindStackStatus(stackName) != cloudformation.StackStatusDeleteFailed {
+			return fmt.Errorf("Error deleting stack: %s %s", stackName, err)
+		}
+		retain, rerr := a.failedResources(stackName, retainResources)
+		if rerr != nil || len(retain) == 0 {
+			return fmt.Errorf("Error deleting stack: %s %s", stackName, err)
+		}
+		log.Warnf("Deleting stack: %s again, retaining: %s", stackName, strings.Join(retain, ", "))
+		params.RetainResources = aws.StringSlice(retain)
 	}
-	return nil
 }
 
-func (a *AWSStackApi) waitForStackOperation(stackName string) error {
+// waitForStackOperation shows the stack events until the operation completes,
+// and the progress of the rollback trigger monitoring after the last event
+func (a *AWSStackApi) waitForStackOperation(stackName string, monitoring time.Duration) error {
 	log.Infof("Waiting for stack operation to complete")
 
 	params := &cloudformation.DescribeStackEventsInput{
@@ -411,7 +806,8 @@ func (a *AWSStackApi) waitForStackOperation(stackName string) error {
 	eventIdsSeen := make(map[string]interface{})
 
 	startTime := time.Now()
-	waitTime := startTime.Add(max_wait_time)
+	waitTime := startTime.Add(max_wait_time + monitoring)
+	var lastEvent time.Time
 
 	tbl := utils.NewTableWriter(os.Stdout, 40, 45, 30)
 	tbl.WriteHeader("Status", "Type", "LogicalID")
@@ -419,24 +815,29 @@ func (a *AWSStackApi) waitForStackOperation(stackName string) error {
 	var result error
 	done := false
 	var nextToken *string
-	for time.Now().Before(waitTime) && !done {
+	var firstStatus string
+	started := false
+	for polls := 0; time.Now().Before(waitTime) && !done; polls++ {
 		stack := a.FindStack(stackName)
 
 		if stack == nil {
 			return fmt.Errorf("stack: %s does not exist", stackName)
 		}
 
-		if strings.HasSuffix(*stack.StackStatus, "_FAILED") || strings.HasSuffix(*stack.StackStatus, "_COMPLETE") {
-			if strings.HasSuffix(*stack.StackStatus, "_FAILED") {
-				result = fmt.Errorf("Stack operation failed: %s", *stack.StackStatus)
-			}
-			if !a.isChangeSetPending(stackName) {
-				done = true
-			} else {
-				log.Debugf("Changes Pending")
-			}
+		status := *stack.StackStatus
+		if polls == 0 {
+			firstStatus = status
+		}
+		started = started || stackOperationStarted(status, firstStatus, polls)
 
+		if started && (strings.HasSuffix(status, "_FAILED") || strings.HasSuffix(status, "_COMPLETE")) && !a.isChangeSetPending(stackName) {
+			done = true
+			// a rolled back create or update failed too
+			if strings.HasSuffix(status, "_FAILED") || strings.HasSuffix(status, "ROLLBACK_COMPLETE") {
+				result = fmt.Errorf("Stack operation failed: %s", status)
+			}
 		} else {
+			log.Debugf("Stack: %s status: %s, waiting", stackName, status)
 			time.Sleep(15 * time.Second)
 		}
 
@@ -446,11 +847,21 @@ func (a *AWSStackApi) waitForStackOperation(stackName string) error {
 			return err
 		}
 		nextToken = resp.NextToken
+		newEvents := false
 		for i := len(resp.StackEvents) - 1; i >= 0; i-- {
 			event := resp.StackEvents[i]
 			if !utils.KeyExists(*event.EventId, eventIdsSeen) {
 				eventIdsSeen[*event.EventId] = true
 				tbl.WriteRow(*event.ResourceStatus, *event.ResourceType, *event.LogicalResourceId)
+				newEvents = true
+				if event.Timestamp != nil && event.Timestamp.After(lastEvent) {
+					lastEvent = *event.Timestamp
+				}
+			}
+		}
+		if monitoring > 0 && !done && !newEvents && !lastEvent.IsZero() && strings.HasSuffix(*stack.StackStatus, "_IN_PROGRESS") {
+			if elapsed := time.Since(lastEvent); elapsed < monitoring {
+				log.Infof("Monitoring the rollback triggers of: %s %s of %s", stackName, elapsed/time.Second*time.Second, monitoring)
 			}
 		}
 	}
@@ -458,6 +869,12 @@ func (a *AWSStackApi) waitForStackOperation(stackName string) error {
 	return result
 }
 
+// stackOperationStarted is true once the stack status is of the operation waited for: right after the operation
+// starts, the stack can still show the status of the previous operation, e.g. UPDATE_ROLLBACK_COMPLETE
+func stackOperationStarted(status, firstStatus string, polls int) bool {
+	return strings.HasSuffix(status, "_IN_PROGRESS") || status != firstStatus || polls >= staleStatusPolls
+}
+
 func (a *AWSStackApi) isChangeSetPending(stackName string) bool {
 	resp, err := a.CFService().ListChangeSets(&cloudformation.ListChangeSetsInput{
 		StackName: aws.String(stackName),
@@ -477,25 +894,44 @@ func (a *AWSStackApi) isChangeSetPending(stackName string) bool {
 	return false
 }
 
-func (a *AWSStackApi) waitForChangeSet(changeSetName string) {
+// waitForChangeSet waits for the change set to be created, and returns it
+func (a *AWSStackApi) waitForChangeSet(changeSetName string) *cloudformation.DescribeChangeSetOutput {
 	startTime := time.Now()
 	waitTime := startTime.Add(max_wait_time)
-	done := false
 
-	for time.Now().Before(waitTime) && !done {
+	for time.Now().Before(waitTime) {
 		params := &cloudformation.DescribeChangeSetInput{
 			ChangeSetName: aws.String(changeSetName),
 		}
 		resp, err := a.CFService().DescribeChangeSet(params)
 		if err != nil {
 			log.Errorf("Error determining a changeset: %s", err)
-			return
+			return nil
 		}
 		if resp != nil && !strings.HasSuffix(*resp.Status, "_IN_PROGRESS") && !strings.HasSuffix(*resp.Status, "_PENDING") {
-			done = true
-		} else {
-			log.Infof("Waiting for change set: %s to be available: %s", changeSetName, *resp.Status)
-			time.Sleep(15 * time.Second)
+			return resp
 		}
+		log.Infof("Waiting for change set: %s to be available: %s", changeSetName, *resp.Status)
+		time.Sleep(15 * time.Second)
 	}
+	return nil
+}
+
+// changeSetError is the error of a change set that cant be executed, nil when it was created
+func changeSetError(changeSet *cloudformation.DescribeChangeSetOutput) error {
+	if changeSet == nil {
+		return fmt.Errorf("change set not available")
+	}
+	if status := aws.StringValue(changeSet.Status); status != cloudformation.ChangeSetStatusCreateComplete {
+		return fmt.Errorf("change set: %s status: %s %s", aws.StringValue(changeSet.ChangeSetName), status,
+			aws.StringValue(changeSet.StatusReason))
+	}
+	return nil
+}
+
+// isEmptyChangeSet is true when the change set failed because there is nothing to change
+func isEmptyChangeSet(changeSet *cloudformation.DescribeChangeSetOutput) bool {
+	return changeSet != nil && aws.StringValue(changeSet.Status) == cloudformation.ChangeSetStatusFailed &&
+		(strings.Contains(aws.StringValue(changeSet.StatusReason), "didn't contain changes") ||
+			strings.Contains(aws.StringValue(changeSet.StatusReason), "No updates are to be performed"))
 }
@@ -19,14 +19,84 @@ package stacks
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/capitalone/stack-deployment-tool/providers"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMain(m *testing.M) {
 	os.Exit(fakeAwsEnv(func() int { return m.Run() }))
 }
 
+func TestStackOperationStarted(t *testing.T) {
+	assert.False(t, stackOperationStarted("UPDATE_ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_COMPLETE", 0))
+	assert.False(t, stackOperationStarted("ROLLBACK_COMPLETE", "ROLLBACK_COMPLETE", 1))
+	assert.True(t, stackOperationStarted("ROLLBACK_COMPLETE", "ROLLBACK_COMPLETE", staleStatusPolls))
+	assert.True(t, stackOperationStarted("UPDATE_IN_PROGRESS", "UPDATE_IN_PROGRESS", 0))
+	assert.True(t, stackOperationStarted("UPDATE_COMPLETE", "UPDATE_ROLLBACK_COMPLETE", 1))
+}
+
+// fakeCloudFormation answers the cloudformation actions with the xml results, the other actions with an empty result.
+// Results starting with <ErrorResponse> are errors. calls counts the requests of each action.
+func fakeCloudFormation(results map[string]string) (api *AWSStackApi, calls map[string]int, server *httptest.Server) {
+	calls = map[string]int{}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.FormValue("Action")
+		calls[action]++
+		result := results[action]
+		if strings.HasPrefix(result, "<ErrorResponse>") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, result)
+			return
+		}
+		fmt.Fprintf(w, "<%sResponse><%sResult>%s</%sResult></%sResponse>", action, action, result, action, action)
+	}))
+	api = NewAWSStackApi(&providers.AWSApi{Session: session.New(aws.NewConfig().WithRegion("us-east-1").WithEndpoint(server.URL).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).WithMaxRetries(0))})
+	return api, calls, server
+}
+
+func TestUpdateStackChangeSetErrors(t *testing.T) {
+	stack := &cloudformation.Stack{StackName: aws.String("qa-app"), StackStatus: aws.String("UPDATE_COMPLETE")}
+	results := map[string]string{
+		"CreateChangeSet":   "<Id>arn:aws:cloudformation:us-east-1:000000000000:changeSet/qa-app-1/1</Id>",
+		"DescribeChangeSet": "<ChangeSetName>qa-app-1</ChangeSetName><Status>CREATE_COMPLETE</Status>",
+		"ExecuteChangeSet":  "<ErrorResponse><Error><Code>InvalidChangeSetStatus</Code><Message>change set is obsolete</Message></Error></ErrorResponse>",
+	}
+	a, calls, server := fakeCloudFormation(results)
+	defer server.Close()
+	_, err := a.updateStack(stack, "Resources: {}\n", nil, nil, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "change set is obsolete")
+	assert.Equal(t, 0, calls["DescribeStacks"])
+
+	// a change set that failed for another reason than no changes isnt executed
+	results["DescribeChangeSet"] = "<ChangeSetName>qa-app-1</ChangeSetName><Status>FAILED</Status><StatusReason>Template format error</StatusReason>"
+	a, calls, server = fakeCloudFormation(results)
+	defer server.Close()
+	_, err = a.updateStack(stack, "Resources: {}\n", nil, nil, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Template format error")
+	assert.Equal(t, 0, calls["ExecuteChangeSet"])
+
+	results["DescribeChangeSet"] = "<ChangeSetName>qa-app-1</ChangeSetName><Status>FAILED</Status><StatusReason>The submitted information didn't contain changes.</StatusReason>"
+	a, calls, server = fakeCloudFormation(results)
+	defer server.Close()
+	_, err = a.updateStack(stack, "Resources: {}\n", nil, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, calls["ExecuteChangeSet"])
+}
+
 /*
 func TestAwsApiCreateStackOnly(t *testing.T) {
 	log.SetLevel(log.DebugLevel)
@@ -95,3 +165,27 @@ func fakeAwsEnv(toRun func() int) int {
 		return toRun()
 	}
 }
+
+func TestCreateStackMaskedParams(t *testing.T) {
+	a, calls, server := fakeCloudFormation(map[string]string{})
+	defer server.Close()
+	err := a.createStack("qa-db", "Resources: {}\n", map[string]interface{}{"User": "app", "Password": noEchoValue, "ApiKey": noEchoValue}, nil, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "ApiKey, Password")
+	assert.Equal(t, 0, calls["CreateStack"])
+}
+
+func TestFindDeploymentOutputInAccount(t *testing.T) {
+	a, calls, server := fakeCloudFormation(map[string]string{
+		"DescribeStacks": "<Stacks><member><StackName>shared-vpc</StackName><Outputs><member>" +
+			"<OutputKey>VpcId</OutputKey><OutputValue>vpc-1</OutputValue></member></Outputs></member></Stacks>",
+	})
+	defer server.Close()
+	// the account api shares the endpoint, credentials and http client of the api
+	val, err := a.FindDeploymentOutputInAccount("", "us-west-2", "", "shared-vpc", "VpcId")
+	assert.Nil(t, err)
+	assert.Equal(t, "vpc-1", val)
+	assert.Equal(t, 1, calls["DescribeStacks"])
+	assert.Equal(t, "us-west-2", aws.StringValue(a.accountApis["/us-west-2/"].Session.Config.Region))
+	assert.Equal(t, "us-east-1", aws.StringValue(a.Session.Config.Region))
+}
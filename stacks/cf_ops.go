@@ -0,0 +1,119 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// The vendored sdk predates these cloudformation operations and fields, they are sent as custom requests
+// with the inputs and outputs below, named and tagged like the sdk shapes.
+
+// cfRequest sends a cloudformation api operation, filling output with the response
+func (a *AWSStackApi) cfRequest(operation string, input interface{}, output interface{}) error {
+	op := &request.Operation{Name: operation, HTTPMethod: "POST", HTTPPath: "/"}
+	return a.CFService().NewRequest(op, input, output).Send()
+}
+
+type listImportsInput struct {
+	_ struct{} `type:"structure"`
+
+	ExportName *string `type:"string" required:"true"`
+	NextToken  *string `min:"1" type:"string"`
+}
+
+type listImportsOutput struct {
+	_ struct{} `type:"structure"`
+
+	Imports   []*string `type:"list"`
+	NextToken *string   `min:"1" type:"string"`
+}
+
+// createStackInput is cloudformation.CreateStackInput with EnableTerminationProtection and RollbackConfiguration
+type createStackInput struct {
+	_ struct{} `type:"structure"`
+
+	EnableTerminationProtection *bool                       `type:"boolean"`
+	NotificationARNs            []*string                   `type:"list"`
+	OnFailure                   *string                     `type:"string" enum:"OnFailure"`
+	Parameters                  []*cloudformation.Parameter `type:"list"`
+	RollbackConfiguration       *rollbackConfiguration      `type:"structure"`
+	StackName                   *string                     `type:"string" required:"true"`
+	StackPolicyBody             *string                     `min:"1" type:"string"`
+	Tags                        []*cloudformation.Tag       `type:"list"`
+	TemplateBody                *string                     `min:"1" type:"string"`
+	TemplateURL                 *string                     `min:"1" type:"string"`
+}
+
+// createChangeSetInput is cloudformation.CreateChangeSetInput with RollbackConfiguration
+type createChangeSetInput struct {
+	_ struct{} `type:"structure"`
+
+	Capabilities          []*string                   `type:"list"`
+	ChangeSetName         *string                     `min:"1" type:"string" required:"true"`
+	NotificationARNs      []*string                   `type:"list"`
+	Parameters            []*cloudformation.Parameter `type:"list"`
+	RollbackConfiguration *rollbackConfiguration      `type:"structure"`
+	StackName             *string                     `min:"1" type:"string" required:"true"`
+	Tags                  []*cloudformation.Tag       `type:"list"`
+	TemplateBody          *string                     `min:"1" type:"string"`
+	TemplateURL           *string                     `min:"1" type:"string"`
+}
+
+type rollbackConfiguration struct {
+	_ struct{} `type:"structure"`
+
+	MonitoringTimeInMinutes *int64             `type:"integer"`
+	RollbackTriggers        []*rollbackTrigger `type:"list"`
+}
+
+type rollbackTrigger struct {
+	_ struct{} `type:"structure"`
+
+	Arn  *string `type:"string" required:"true"`
+	Type *string `type:"string" required:"true"`
+}
+
+type updateTerminationProtectionInput struct {
+	_ struct{} `type:"structure"`
+
+	EnableTerminationProtection *bool   `type:"boolean" required:"true"`
+	StackName                   *string `min:"1" type:"string" required:"true"`
+}
+
+type updateTerminationProtectionOutput struct {
+	_ struct{} `type:"structure"`
+
+	StackId *string `type:"string"`
+}
+
+// describeStacksOutput is the part of cloudformation.DescribeStacksOutput the sdk is missing
+type describeStacksOutput struct {
+	_ struct{} `type:"structure"`
+
+	NextToken *string        `min:"1" type:"string"`
+	Stacks    []*stackDetail `type:"list"`
+}
+
+type stackDetail struct {
+	_ struct{} `type:"structure"`
+
+	EnableTerminationProtection *bool   `type:"boolean"`
+	StackName                   *string `type:"string" required:"true"`
+}
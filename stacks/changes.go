@@ -0,0 +1,210 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+	"github.com/capitalone/stack-deployment-tool/versioning"
+
+	log "github.com/Sirupsen/logrus"
+	jsonptr "github.com/dustin/go-jsonpointer"
+)
+
+// changeDetector finds the stacks changed since a git ref
+type changeDetector struct {
+	config       *StacksConfig // stacks yaml rendered with the outputs looked up once for both
+	oldConfig    *StacksConfig // stacks yaml at the ref, nil when it did not exist
+	changedFiles map[string]bool
+	changed      map[string]bool // stack label -> changed, cached
+}
+
+// SelectChangedSince keeps the selected stacks affected by the changes since the git ref:
+// stacks whose template, included files or rendered stacks yaml section changed, and the stacks depending on them
+func (e *EnvStacksConfig) SelectChangedSince(ref string) error {
+	detector, err := newChangeDetector(e.Config, ref)
+	if err != nil {
+		return err
+	}
+
+	selected := []string{}
+	for _, label := range e.StackLabels {
+		affected := detector.stackChanged(e.Env, label)
+		for _, dep := range vertexLabels(e.Deps.Ancestors(e.Deps.FindVertexByName(label))) {
+			affected = affected || detector.stackChanged(e.Env, dep)
+		}
+		if affected {
+			selected = append(selected, label)
+		}
+	}
+	log.Infof("Stacks changed since %s: %v", ref, selected)
+	e.StackLabels = selected
+	return nil
+}
+
+func newChangeDetector(c *StacksConfig, ref string) (*changeDetector, error) {
+	files, err := versioning.GitChangedFiles(filepath.Dir(c.FileName), ref)
+	if err != nil {
+		return nil, err
+	}
+	outputs := newOutputCache(c.Templ.OutputFinder)
+	d := &changeDetector{
+		config:       &StacksConfig{Yaml: c.Yaml, FileName: c.FileName},
+		changedFiles: make(map[string]bool),
+		changed:      make(map[string]bool),
+	}
+	d.config.Templ = NewTemplate(outputs, d.config)
+	for _, f := range files {
+		d.changedFiles[f] = true
+	}
+
+	content, err := versioning.GitShowFile(ref, c.FileName)
+	if err != nil {
+		log.Infof("%v, all stacks changed", err)
+		return d, nil
+	}
+	yaml, err := utils.DecodeYAML(content)
+	if err != nil {
+		return nil, err
+	}
+	d.oldConfig = &StacksConfig{Yaml: yaml, FileName: c.FileName}
+	d.oldConfig.Templ = NewTemplate(outputs, d.oldConfig)
+	return d, nil
+}
+
+func (d *changeDetector) stackChanged(env, label string) bool {
+	if changed, found := d.changed[label]; found {
+		return changed
+	}
+	stack := d.config.envStackConfig(env, label)
+	changed := d.sectionChanged(stack) || d.templateChanged(stack)
+	log.Debugf("stack: %s changed: %v", label, changed)
+	d.changed[label] = changed
+	return changed
+}
+
+// sectionChanged compares the rendered stacks yaml section of the stack to the one at the ref
+func (d *changeDetector) sectionChanged(stack *StackConfig) bool {
+	if d.oldConfig == nil {
+		return true
+	}
+	oldYaml := jsonptr.Get(d.oldConfig.Yaml, "/stacks/"+escJsonPtr(stack.Env())+"/"+escJsonPtr(stack.Label()))
+	if oldYaml == nil {
+		return true
+	}
+	return !reflect.DeepEqual(stack.FetchAll(), d.oldConfig.ProcessValue(oldYaml))
+}
+
+// outputCache looks up each output, export and provisioned product output once, for rendering the stacks yaml
+// and the one at the ref. It is only used to detect the changes, deploys look the outputs up again.
+type outputCache struct {
+	finder DeploymentOutputFinder
+	values map[string]string
+}
+
+func newOutputCache(finder DeploymentOutputFinder) *outputCache {
+	return &outputCache{finder: finder, values: make(map[string]string)}
+}
+
+func (o *outputCache) lookup(key string, find func() (string, error)) (string, error) {
+	if val, found := o.values[key]; found {
+		return val, nil
+	}
+	val, err := find()
+	if err == nil {
+		o.values[key] = val
+	}
+	return val, err
+}
+
+func (o *outputCache) FindDeploymentOutput(stackName string, outputKey string) (string, error) {
+	if o.finder == nil {
+		return "", nil
+	}
+	return o.lookup("output/"+stackName+"/"+outputKey, func() (string, error) {
+		return o.finder.FindDeploymentOutput(stackName, outputKey)
+	})
+}
+
+func (o *outputCache) FindDeploymentOutputInAccount(account, region, role, stackName, outputKey string) (string, error) {
+	crossFinder, ok := o.finder.(CrossAccountOutputFinder)
+	if !ok {
+		return "", fmt.Errorf("cross account output lookups are not supported by: %T", o.finder)
+	}
+	return o.lookup("output/"+account+"/"+region+"/"+role+"/"+stackName+"/"+outputKey, func() (string, error) {
+		return crossFinder.FindDeploymentOutputInAccount(account, region, role, stackName, outputKey)
+	})
+}
+
+func (o *outputCache) FindExport(exportName string) (string, error) {
+	exportFinder, ok := o.finder.(ExportFinder)
+	if !ok {
+		return "", nil
+	}
+	return o.lookup("import/"+exportName, func() (string, error) {
+		return exportFinder.FindExport(exportName)
+	})
+}
+
+func (o *outputCache) FindProvisionedProductOutput(productName string, outputKey string) (string, error) {
+	productFinder, ok := o.finder.(ProvisionedProductOutputFinder)
+	if !ok {
+		return "", nil
+	}
+	return o.lookup("sc_output/"+productName+"/"+outputKey, func() (string, error) {
+		return productFinder.FindProvisionedProductOutput(productName, outputKey)
+	})
+}
+
+// templateChanged checks the template file of the stack and the files it includes
+func (d *changeDetector) templateChanged(stack *StackConfig) bool {
+	templateFile := findTemplateFile(stack.TemplatePaths()...)
+	if len(templateFile) == 0 {
+		return false
+	}
+	if d.fileChanged(templateFile) {
+		return true
+	}
+
+	f, err := os.Open(templateFile)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	for _, include := range utils.IncludedFiles(f) {
+		if d.fileChanged(filepath.Join(filepath.Dir(templateFile), include)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *changeDetector) fileChanged(file string) bool {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return true
+	}
+	if resolved, err := filepath.EvalSymlinks(absFile); err == nil {
+		absFile = resolved
+	}
+	return d.changedFiles[absFile]
+}
@@ -0,0 +1,121 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/capitalone/stack-deployment-tool/versioning"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const changesStacksYml = `
+stacks:
+  qa:
+    elb:
+      template: elb-template
+      parameters:
+        Port: 80
+    server:
+      depends_on: elb
+    dns:
+      parameters:
+        Name: '{{env.DNS_NAME}}'
+`
+
+func TestSelectChangedSince(t *testing.T) {
+	dir, err := ioutil.TempDir("", "changes")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	git := func(args ...string) {
+		_, err := versioning.Sh("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		assert.Nil(t, err)
+	}
+	changedSince := func() []string {
+		c := NewConfig(filepath.Join(dir, "stacks.yml"), tempOutputFinder())
+		e := c.FetchEnvStacks("qa")
+		assert.Nil(t, e.SelectChangedSince("HEAD"))
+		return e.StackLabels
+	}
+
+	os.Setenv("DNS_NAME", "qa.example.com")
+	write("stacks.yml", changesStacksYml)
+	write("elb-template.yml", "UserData: !Local::IncludeFileLines user-data.sh\n")
+	write("user-data.sh", "echo hello\n")
+	write("server.yml", "Resources: {}\n")
+	write("dns.json", "{}\n")
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+
+	assert.Equal(t, []string{}, changedSince())
+
+	// included file of elb changed, server depends on elb
+	write("user-data.sh", "echo bye\n")
+	assert.Equal(t, []string{"elb", "server"}, changedSince())
+	git("checkout", "-q", "--", "user-data.sh")
+
+	write("server.yml", "Resources: {Queue: {}}\n")
+	assert.Equal(t, []string{"server"}, changedSince())
+	git("checkout", "-q", "--", "server.yml")
+
+	// formatting changes of the stacks yaml render the same
+	write("stacks.yml", changesStacksYml+"# comment\n")
+	assert.Equal(t, []string{}, changedSince())
+
+	write("stacks.yml", strings.Replace(changesStacksYml, "{{env.DNS_NAME}}", "www.{{env.DNS_NAME}}", 1))
+	assert.Equal(t, []string{"dns"}, changedSince())
+
+	c := NewConfig(filepath.Join(dir, "stacks.yml"), tempOutputFinder())
+	assert.NotNil(t, c.FetchEnvStacks("qa").SelectChangedSince("nosuchref"))
+}
+
+type countingOutputFinder struct {
+	lookups int
+}
+
+func (f *countingOutputFinder) FindDeploymentOutput(stackName string, outputKey string) (string, error) {
+	f.lookups++
+	return stackName + "-" + outputKey, nil
+}
+
+func TestOutputCache(t *testing.T) {
+	finder := &countingOutputFinder{}
+	outputs := newOutputCache(finder)
+	for i := 0; i < 2; i++ {
+		val, err := outputs.FindDeploymentOutput("elb", "DNSName")
+		assert.Nil(t, err)
+		assert.Equal(t, "elb-DNSName", val)
+	}
+	assert.Equal(t, 1, finder.lookups)
+
+	val, err := outputs.FindExport("vpc-id")
+	assert.Nil(t, err)
+	assert.Equal(t, "", val)
+	_, err = outputs.FindDeploymentOutputInAccount("123", "us-east-1", "", "elb", "DNSName")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,135 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+const (
+	OutcomeComplete  = "complete"
+	OutcomeUnchanged = "unchanged"
+	OutcomeFailed    = "failed"
+)
+
+// Checkpoint is the progress of a multi-stack deploy, so a failed run can be resumed
+type Checkpoint struct {
+	Env      string            `json:"env"`
+	Stacks   []string          `json:"stacks"`   // selected stack labels in deploy order
+	Outcomes map[string]string `json:"outcomes"` // stack label -> outcome, missing when not deployed yet
+	Hashes   map[string]string `json:"hashes"`   // stack label -> content hash of the rendered template, parameters and tags
+	Started  time.Time         `json:"started"`
+	Updated  time.Time         `json:"updated"`
+
+	file string
+}
+
+// NewCheckpoint starts the checkpoint of a deploy of the env stacks
+func NewCheckpoint(envStacks *EnvStacksConfig) *Checkpoint {
+	return &Checkpoint{
+		Env:      envStacks.Env,
+		Stacks:   envStacks.StackLabels,
+		Outcomes: make(map[string]string),
+		Hashes:   make(map[string]string),
+		Started:  time.Now().UTC(),
+		file:     checkpointFile(envStacks),
+	}
+}
+
+// LoadCheckpoint loads the checkpoint of the last incomplete deploy of the env stacks
+func LoadCheckpoint(envStacks *EnvStacksConfig) (*Checkpoint, error) {
+	file := checkpointFile(envStacks)
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("no deploy to resume for: %s (%s)", envStacks.Env, file)
+	}
+	c := &Checkpoint{file: file}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("cannot load checkpoint: %s %v", file, err)
+	}
+	if c.Outcomes == nil {
+		c.Outcomes = make(map[string]string)
+	}
+	if c.Hashes == nil {
+		c.Hashes = make(map[string]string)
+	}
+	if !reflect.DeepEqual(c.Stacks, envStacks.StackLabels) {
+		return nil, fmt.Errorf("the selected stacks changed since the checkpoint, was: %v now: %v", c.Stacks, envStacks.StackLabels)
+	}
+	return c, nil
+}
+
+func checkpointFile(envStacks *EnvStacksConfig) string {
+	return filepath.Join(envStacks.Config.StateDir(), "checkpoint-"+envStacks.Env+".json")
+}
+
+// Record saves the outcome of deploying a stack
+func (c *Checkpoint) Record(label, outcome, hash string) error {
+	c.Outcomes[label] = outcome
+	c.Hashes[label] = hash
+	return c.Save()
+}
+
+// IsDone is true for a stack deployed by the checkpointed run,
+// and an error when the stack changed since it was deployed
+func (c *Checkpoint) IsDone(label, hash string) (bool, error) {
+	outcome := c.Outcomes[label]
+	if outcome != OutcomeComplete && outcome != OutcomeUnchanged {
+		return false, nil
+	}
+	if c.Hashes[label] != hash {
+		return false, fmt.Errorf("stack: %s changed since it was deployed, cannot resume", label)
+	}
+	return true, nil
+}
+
+func (c *Checkpoint) Save() error {
+	if len(c.file) == 0 { // not persisted, i.e. in drymode
+		return nil
+	}
+	c.Updated = time.Now().UTC()
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.file), 0755); err != nil {
+		return err
+	}
+	// write and rename, so an interrupted save keeps the previous checkpoint
+	if err := ioutil.WriteFile(c.file+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(c.file+".tmp", c.file)
+}
+
+// Remove deletes the checkpoint of a completed deploy
+func (c *Checkpoint) Remove() error {
+	if len(c.file) == 0 {
+		return nil
+	}
+	if err := os.Remove(c.file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	viper.Set("StateDir", dir)
+	defer viper.Set("StateDir", "")
+
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	qa3 := c.FetchEnvStacks("qa3")
+
+	_, err = LoadCheckpoint(qa3)
+	assert.NotNil(t, err)
+
+	checkpoint := NewCheckpoint(qa3)
+	assert.Nil(t, checkpoint.Record("nagios-elb", OutcomeComplete, "hash1"))
+	assert.Nil(t, checkpoint.Record("nagios-internal-dns", OutcomeFailed, "hash2"))
+
+	loaded, err := LoadCheckpoint(qa3)
+	assert.Nil(t, err)
+	assert.Equal(t, qa3.StackLabels, loaded.Stacks)
+
+	done, err := loaded.IsDone("nagios-elb", "hash1")
+	assert.Nil(t, err)
+	assert.True(t, done)
+
+	// failed stacks are deployed again
+	done, err = loaded.IsDone("nagios-internal-dns", "hash2")
+	assert.Nil(t, err)
+	assert.False(t, done)
+
+	done, err = loaded.IsDone("nagios-server", "hash3")
+	assert.Nil(t, err)
+	assert.False(t, done)
+
+	// completed stacks cannot change
+	_, err = loaded.IsDone("nagios-elb", "hash4")
+	assert.NotNil(t, err)
+
+	// nor the selection
+	_, err = LoadCheckpoint(c.FetchEnvStacks("qa3.nagios-elb"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, loaded.Remove())
+	_, err = LoadCheckpoint(qa3)
+	assert.NotNil(t, err)
+}
+
+func TestStateDir(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	assert.Equal(t, "../resources/.sdt", c.StateDir())
+}
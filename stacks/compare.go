@@ -0,0 +1,186 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+)
+
+// PlaceholderOutputFinder renders stack outputs, exports and provisioned product outputs as placeholders naming them,
+// so the references can be compared without looking them up
+type PlaceholderOutputFinder struct{}
+
+func (PlaceholderOutputFinder) FindDeploymentOutput(stackName string, outputKey string) (string, error) {
+	return fmt.Sprintf("<output %s %s>", stackName, outputKey), nil
+}
+
+func (PlaceholderOutputFinder) FindDeploymentOutputInAccount(account, region, role, stackName, outputKey string) (string, error) {
+	return fmt.Sprintf("<output %s:%s %s %s>", account, region, stackName, outputKey), nil
+}
+
+func (PlaceholderOutputFinder) FindExport(exportName string) (string, error) {
+	return fmt.Sprintf("<import %s>", exportName), nil
+}
+
+func (PlaceholderOutputFinder) FindProvisionedProductOutput(productName string, outputKey string) (string, error) {
+	return fmt.Sprintf("<sc_output %s %s>", productName, outputKey), nil
+}
+
+// EnvComparison are the differences between the rendered stacks of two environments
+type EnvComparison struct {
+	A, B    string // environments
+	OnlyInA []string
+	OnlyInB []string
+	Stacks  []*StackComparison // stacks of both environments that differ
+}
+
+type StackComparison struct {
+	Label        string
+	Values       []*ValueDifference
+	TemplateDiff string // unified diff of the rendered templates, when they differ
+}
+
+// ValueDifference is a value of the stack yaml that differs, Path is slash separated: parameters/InstanceType
+type ValueDifference struct {
+	Path     string
+	A, B     string
+	InA, InB bool
+}
+
+func (d *ValueDifference) String() string {
+	return fmt.Sprintf("%s: %s => %s", d.Path, displayValue(d.A, d.InA), displayValue(d.B, d.InB))
+}
+
+func displayValue(val string, exists bool) string {
+	if !exists {
+		return "(none)"
+	}
+	if len(val) == 0 {
+		return "''"
+	}
+	return val
+}
+
+// CompareEnvStacks compares the rendered stacks yaml (parameters, tags and stack options) and templates of the
+// stacks with the same label in the environments a and b
+func CompareEnvStacks(a, b *EnvStacksConfig) *EnvComparison {
+	cmp := &EnvComparison{A: a.Env, B: b.Env}
+	for _, label := range a.StackLabels {
+		if _, ok := b.Stacks[label]; !ok {
+			cmp.OnlyInA = append(cmp.OnlyInA, label)
+		}
+	}
+	for _, label := range b.StackLabels {
+		if _, ok := a.Stacks[label]; !ok {
+			cmp.OnlyInB = append(cmp.OnlyInB, label)
+		}
+	}
+
+	// in the deploy order of a
+	for _, label := range a.StackLabels {
+		stackB, ok := b.Stacks[label]
+		if !ok {
+			continue
+		}
+		stackA := a.Stacks[label]
+		if sc := compareStacks(&stackA, &stackB); len(sc.Values) > 0 || len(sc.TemplateDiff) > 0 {
+			cmp.Stacks = append(cmp.Stacks, sc)
+		}
+	}
+	return cmp
+}
+
+func compareStacks(a, b *StackConfig) *StackComparison {
+	sc := &StackComparison{Label: a.Label()}
+	valsA := map[string]string{}
+	valsB := map[string]string{}
+	flattenValues("", a.FetchAll(), valsA)
+	flattenValues("", b.FetchAll(), valsB)
+	valsA["stack_name"], valsB["stack_name"] = a.Name(), b.Name()
+
+	paths := map[string]bool{}
+	for p := range valsA {
+		paths[p] = true
+	}
+	for p := range valsB {
+		paths[p] = true
+	}
+	for _, p := range setKeys(paths) {
+		va, inA := valsA[p]
+		vb, inB := valsB[p]
+		if inA != inB || va != vb {
+			sc.Values = append(sc.Values, &ValueDifference{Path: p, A: va, B: vb, InA: inA, InB: inB})
+		}
+	}
+
+	fileA := findTemplateFile(a.TemplatePaths()...)
+	fileB := findTemplateFile(b.TemplatePaths()...)
+	sc.TemplateDiff = utils.UnifiedDiff(a.QualifiedLabel()+" "+fileA, b.QualifiedLabel()+" "+fileB,
+		loadTemplate(a.TemplatePaths()...), loadTemplate(b.TemplatePaths()...))
+	return sc
+}
+
+// flattenValues adds the scalar values of val to out by their slash separated path, list items are [i]
+func flattenValues(path string, val interface{}, out map[string]string) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			p := k
+			if len(path) > 0 {
+				p = path + "/" + k
+			}
+			flattenValues(p, item, out)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenValues(fmt.Sprintf("%s[%d]", path, i), item, out)
+		}
+	case nil:
+		out[path] = "null"
+	default:
+		out[path] = fmt.Sprint(v)
+	}
+}
+
+// Print writes the comparison, the stacks only in one environment, then the values and templates that differ
+func (cmp *EnvComparison) Print(w io.Writer) {
+	if len(cmp.OnlyInA) > 0 {
+		fmt.Fprintf(w, "Only in %s: %s\n", cmp.A, strings.Join(cmp.OnlyInA, ", "))
+	}
+	if len(cmp.OnlyInB) > 0 {
+		fmt.Fprintf(w, "Only in %s: %s\n", cmp.B, strings.Join(cmp.OnlyInB, ", "))
+	}
+	for _, sc := range cmp.Stacks {
+		fmt.Fprintf(w, "\n%s (%s => %s):\n", sc.Label, cmp.A, cmp.B)
+		for _, d := range sc.Values {
+			fmt.Fprintf(w, "  %s\n", d.String())
+		}
+		if len(sc.TemplateDiff) > 0 {
+			fmt.Fprintf(w, "  template:\n%s", sc.TemplateDiff)
+		}
+	}
+}
+
+// Empty is true when the environments render the same stacks
+func (cmp *EnvComparison) Empty() bool {
+	return len(cmp.OnlyInA) == 0 && len(cmp.OnlyInB) == 0 && len(cmp.Stacks) == 0
+}
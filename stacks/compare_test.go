@@ -0,0 +1,93 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareEnvStacks(t *testing.T) {
+	os.Setenv("INF_VERSION", "3")
+	defer os.Unsetenv("INF_VERSION")
+	qa := NewConfig(ResourcePath("stacks.yml"), PlaceholderOutputFinder{}).FetchEnvStacks("qa")
+	prod := NewConfig(ResourcePath("stacks.yml"), PlaceholderOutputFinder{}).FetchEnvStacks("prod[nagios-server]")
+
+	cmp := CompareEnvStacks(qa, prod)
+	assert.False(t, cmp.Empty())
+	assert.Equal(t, []string{"nagios-elb"}, cmp.OnlyInA)
+	assert.Empty(t, cmp.OnlyInB)
+	assert.Equal(t, 1, len(cmp.Stacks))
+	sc := cmp.Stacks[0]
+	assert.Equal(t, "nagios-server", sc.Label)
+	assert.Empty(t, sc.TemplateDiff)
+	assert.Equal(t, 10, len(sc.Values))
+	assert.Equal(t, "parameters/InstanceType: t2.micro => m3.medium", sc.Values[1].String())
+	assert.Equal(t, "parameters/NagiosELB: <output nagios-elb-qa ELBNAME> => <output nagios-elb-prod ELBNAME>", sc.Values[3].String())
+
+	var out bytes.Buffer
+	cmp.Print(&out)
+	assert.Contains(t, out.String(), "Only in qa: nagios-elb\n")
+	assert.Contains(t, out.String(), "\nnagios-server (qa => prod):\n  parameters/Environment: qa => prod\n")
+
+	same := NewConfig(ResourcePath("stacks.yml"), PlaceholderOutputFinder{}).FetchEnvStacks("qa")
+	assert.True(t, CompareEnvStacks(qa, same).Empty())
+}
+
+func TestCompareStacksTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compare")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"stacks.yml": "stacks:\n  qa:\n    app:\n      template: app.yml\n      on_failure: DELETE\n" +
+			"  prod:\n    app:\n      template: app-prod.yml\n      termination_protection: true\n",
+		"app.yml":      "Resources:\n  Queue:\n    Type: AWS::SQS::Queue\n",
+		"app-prod.yml": "Resources:\n  Queue:\n    Type: AWS::SQS::Queue\n    DeletionPolicy: Retain\n",
+	}
+	for name, content := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	c := NewConfig(filepath.Join(dir, "stacks.yml"), nil)
+	cmp := CompareEnvStacks(c.FetchEnvStacks("qa"), c.FetchEnvStacks("prod"))
+	assert.Equal(t, 1, len(cmp.Stacks))
+	sc := cmp.Stacks[0]
+	assert.Equal(t, []string{"on_failure: DELETE => (none)", "template: app.yml => app-prod.yml",
+		"termination_protection: (none) => true"}, []string{sc.Values[0].String(), sc.Values[1].String(), sc.Values[2].String()})
+	assert.Contains(t, sc.TemplateDiff, "--- qa.app "+filepath.Join(dir, "app.yml")+"\n")
+	assert.Contains(t, sc.TemplateDiff, "     Type: AWS::SQS::Queue\n+    DeletionPolicy: Retain\n")
+}
+
+func TestFlattenValues(t *testing.T) {
+	out := map[string]string{}
+	flattenValues("", map[string]interface{}{
+		"parameters":        map[string]interface{}{"Port": 22, "Empty": nil},
+		"rollback_triggers": []interface{}{"arn:a", map[string]interface{}{"arn": "arn:b"}},
+	}, out)
+	assert.Equal(t, map[string]string{
+		"parameters/Port":          "22",
+		"parameters/Empty":         "null",
+		"rollback_triggers[0]":     "arn:a",
+		"rollback_triggers[1]/arn": "arn:b",
+	}, out)
+}
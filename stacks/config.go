@@ -19,8 +19,10 @@ package stacks
 
 import (
 	"fmt"
+	"path"
+	"path/filepath"
 	"reflect"
-	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/capitalone/stack-deployment-tool/graph"
@@ -28,6 +30,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	jsonptr "github.com/dustin/go-jsonpointer"
+	"github.com/spf13/viper"
 )
 
 type Fetcher interface {
@@ -47,11 +50,16 @@ type EnvStacksConfig struct {
 	Env         string
 	StackLabels []string
 	Stacks      map[string]StackConfig // stack id to stackconfig mapping
+	Deps        *graph.DAG             // dependencies between all the stacks of the env
 	Config      *StacksConfig
+
+	// stacks of other envs the selected stacks depend on, but were not selected
+	ExternalDeps map[string]StackConfig
 }
 
 type StackConfig struct {
 	Yaml   map[string]interface{} // scoped yaml to the stack
+	env    string
 	label  string
 	name   string
 	Config *StacksConfig
@@ -85,52 +93,288 @@ func (c *StacksConfig) FetchAll() interface{} {
 	return c.ProcessValue(c.Yaml)
 }
 
+// StateDir is where the local deploy state is kept, the StateDir config value or .sdt next to the stacks yaml
+func (c *StacksConfig) StateDir() string {
+	if dir := viper.GetString("StateDir"); len(dir) > 0 {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(c.FileName), ".sdt")
+}
+
+// EnvNames are the environments under stacks that define at least one stack, sorted
+func (c *StacksConfig) EnvNames() []string {
+	envs := []string{}
+	for env, val := range utils.ToStrMap(jsonptr.Get(c.Yaml, "/stacks")) {
+		if m, ok := val.(map[string]interface{}); ok && len(envStackLabels(m)) > 0 {
+			envs = append(envs, env)
+		}
+	}
+	sort.Strings(envs)
+	return envs
+}
+
+// StackSelection changes the set of stacks picked by a stack ref
+type StackSelection struct {
+	WithDeps   bool     // add all the stacks the selected stacks depend on
+	Downstream bool     // add all the stacks depending on the selected stacks
+	Exclude    []string // stack labels or glob patterns to remove
+}
+
 // "build.nagios-elb" or "build[nagios-elb,nagios-app]"
 //                    or just "build" - which implies all stacks
 func (c *StacksConfig) FetchEnvStacks(stackRef string) *EnvStacksConfig {
-	stackEnvs := regexp.MustCompile("\\.|\\[").Split(strings.Replace(stackRef, "]", "", 1), -1)
+	return c.FetchEnvStacksSelection(stackRef, nil)
+}
 
-	if len(stackEnvs) < 1 {
-		return nil
-	}
+// FetchEnvStacksSelection is FetchEnvStacks with the selection applied, stack labels in the
+// ref can be glob patterns: "build[nagios-*]"
+func (c *StacksConfig) FetchEnvStacksSelection(stackRef string, selection *StackSelection) *EnvStacksConfig {
+	env, patterns := parseStackRef(stackRef)
 
-	env := stackEnvs[0]
 	st := jsonptr.Get(c.Yaml, "/stacks/"+escJsonPtr(env))
 	if st == nil {
 		log.Fatalf("Environment: %s not found", env)
 	}
 	envStackYaml := utils.ToStrMap(st)
 
-	if len(stackEnvs) == 1 { // only env, so add all the stacks for this env.
-		for k, v := range envStackYaml {
-			if reflect.TypeOf(v).Kind() == reflect.Map {
-				stackEnvs = append(stackEnvs, k)
-			}
-		}
+	stackDeps, err := c.stackDependencies(env, envStackYaml)
+	if err != nil {
+		log.Fatalf("Environment: %s %v", env, err)
+	}
+	deps, err := depsGraph(stackDeps)
+	if err != nil {
+		log.Fatalf("Environment: %s %v", env, err)
+	}
+
+	selected, err := selectStackLabels(envStackLabels(envStackYaml), patterns, deps, selection)
+	if err != nil {
+		log.Fatalf("Environment: %s %v", env, err)
 	}
 
-	var stackLabels []string
 	stacks := make(map[string]StackConfig)
+	for _, s := range selected {
+		log.Debugf("newStackConfig: %+v", s)
+		stacks[s] = *c.envStackConfig(env, s)
+	}
 
-	for _, s := range stackEnvs[1:] {
-		log.Debugf("looking for stack: %+v", s)
-		stackLabels = append(stackLabels, s)
-		stackYaml := jsonptr.Get(envStackYaml, "/"+escJsonPtr(s))
-		if stackYaml != nil {
-			log.Debugf("newStackConfig: %+v", s)
-			stacks[s] = *newStackConfig(s, utils.ToStrMap(stackYaml), c)
+	// other env stacks the selection depends on, that need to exist before deploying
+	externalDeps := make(map[string]StackConfig)
+	for _, s := range selected {
+		for _, d := range stackDeps[s] {
+			if _, _, qualified := splitQualifiedLabel(d); qualified && !arrayContainsStr(selected, d) {
+				externalDeps[d] = *c.envStackConfig(env, d)
+			}
 		}
 	}
 
-	stackLabels = orderedArray(stackLabels, depsGraph(stacks))
+	stackLabels := orderedArray(selected, deps)
 	log.Debugf("stackLabels: %#v", stackLabels)
 	return &EnvStacksConfig{
 		Yaml: envStackYaml, // scoped yaml
-		Env:  env, StackLabels: stackLabels, Config: c, Stacks: stacks}
+		Env:  env, StackLabels: stackLabels, Config: c, Stacks: stacks, Deps: deps, ExternalDeps: externalDeps}
+}
+
+// envStackConfig creates the stack config for a label in the env, or an "env.label" from another env
+func (c *StacksConfig) envStackConfig(env, label string) *StackConfig {
+	if otherEnv, otherLabel, qualified := splitQualifiedLabel(label); qualified {
+		env, label = otherEnv, otherLabel
+	}
+	stackYaml := jsonptr.Get(c.Yaml, "/stacks/"+escJsonPtr(env)+"/"+escJsonPtr(label))
+	return newStackConfig(env, label, utils.ToStrMap(stackYaml), c)
+}
+
+// StackName resolves an "env.label" stack reference to the stack name
+func (c *StacksConfig) StackName(qualifiedLabel string) (string, error) {
+	env, label, qualified := splitQualifiedLabel(qualifiedLabel)
+	if !qualified {
+		return "", fmt.Errorf("stack label: %s must include the environment: <environment>.<stack label>", qualifiedLabel)
+	}
+	if _, ok := jsonptr.Get(c.Yaml, "/stacks/"+escJsonPtr(env)+"/"+escJsonPtr(label)).(map[string]interface{}); !ok {
+		return "", fmt.Errorf("stack: %s not found", qualifiedLabel)
+	}
+	return c.envStackConfig(env, label).Name(), nil
+}
+
+// splitQualifiedLabel splits an "env.label" reference to a stack in another env
+func splitQualifiedLabel(label string) (string, string, bool) {
+	idx := strings.Index(label, ".")
+	if idx <= 0 || idx == len(label)-1 {
+		return "", label, false
+	}
+	return label[:idx], label[idx+1:], true
+}
+
+// stackDependencies maps the stacks of the env to their dependencies, including the stacks of
+// other envs referenced with "env.label" and their dependencies.
+// References to the env's own stacks are unqualified labels.
+func (c *StacksConfig) stackDependencies(env string, envStackYaml map[string]interface{}) (map[string][]string, error) {
+	stackDeps := make(map[string][]string)
+	pending := []string{}
+	for label, labelDeps := range envDependencies(envStackYaml) {
+		stackDeps[label] = qualifyLabels(env, env, labelDeps)
+		pending = append(pending, stackDeps[label]...)
+	}
+
+	for len(pending) > 0 {
+		label := pending[0]
+		pending = pending[1:]
+		otherEnv, otherLabel, qualified := splitQualifiedLabel(label)
+		if _, found := stackDeps[label]; found || !qualified {
+			continue
+		}
+		otherEnvYaml, ok := jsonptr.Get(c.Yaml, "/stacks/"+escJsonPtr(otherEnv)).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("depends_on unknown environment: %s", label)
+		}
+		otherStackYaml, ok := otherEnvYaml[otherLabel].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("depends_on unknown stack: %s", label)
+		}
+		stackDeps[label] = qualifyLabels(env, otherEnv, stringList(otherStackYaml["depends_on"]))
+		pending = append(pending, stackDeps[label]...)
+	}
+	return stackDeps, nil
+}
+
+// qualifyLabels makes the labels of stackEnv relative to env
+func qualifyLabels(env, stackEnv string, labels []string) []string {
+	result := []string{}
+	for _, l := range labels {
+		labelEnv, label, qualified := splitQualifiedLabel(l)
+		if !qualified {
+			labelEnv = stackEnv
+		}
+		if labelEnv == env {
+			result = append(result, label)
+		} else {
+			result = append(result, labelEnv+"."+label)
+		}
+	}
+	return result
+}
+
+// parseStackRef splits a stack ref into the env and the stack label patterns,
+// no patterns means all the stacks of the env
+func parseStackRef(stackRef string) (string, []string) {
+	idx := strings.IndexAny(stackRef, ".[")
+	if idx < 0 {
+		return stackRef, nil
+	}
+	patterns := []string{}
+	for _, p := range strings.Split(strings.TrimSuffix(stackRef[idx+1:], "]"), ",") {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			patterns = append(patterns, p)
+		}
+	}
+	return stackRef[:idx], patterns
+}
+
+// matchStackLabels returns the labels matching the label or glob pattern
+func matchStackLabels(labels []string, pattern string) ([]string, error) {
+	matches := []string{}
+	for _, label := range labels {
+		matched, err := path.Match(pattern, label)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stack pattern: %s %v", pattern, err)
+		}
+		if matched {
+			matches = append(matches, label)
+		}
+	}
+	return matches, nil
+}
+
+// selectStackLabels picks the env stacks matching the patterns, then applies the selection
+func selectStackLabels(envLabels []string, patterns []string, deps *graph.DAG, selection *StackSelection) ([]string, error) {
+	if selection == nil {
+		selection = &StackSelection{}
+	}
+
+	selected := []string{}
+	if len(patterns) == 0 {
+		selected = append(selected, envLabels...)
+	}
+	for _, p := range patterns {
+		matches, err := matchStackLabels(envLabels, p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no stacks found for: %s", p)
+		}
+		selected = appendUniqueStrs(selected, matches...)
+	}
+
+	for _, label := range selected {
+		v := deps.FindVertexByName(label)
+		if selection.WithDeps {
+			selected = appendUniqueStrs(selected, vertexLabels(deps.Ancestors(v))...)
+		}
+		if selection.Downstream {
+			selected = appendUniqueStrs(selected, vertexLabels(deps.Descendants(v))...)
+		}
+	}
+
+	for _, p := range selection.Exclude {
+		excluded, err := matchStackLabels(selected, p)
+		if err != nil {
+			return nil, err
+		}
+		remaining := []string{}
+		for _, label := range selected {
+			if !arrayContainsStr(excluded, label) {
+				remaining = append(remaining, label)
+			}
+		}
+		selected = remaining
+	}
+	return selected, nil
+}
+
+// vertexLabels are the stack labels of the vertices, skipping the root
+func vertexLabels(verts []*graph.Vertex) []string {
+	labels := []string{}
+	for _, v := range verts {
+		if v != root {
+			labels = append(labels, v.Name)
+		}
+	}
+	return labels
+}
+
+// envStackLabels are the labels of all the stacks in an env, sorted
+func envStackLabels(envStackYaml map[string]interface{}) []string {
+	labels := []string{}
+	for k, v := range envStackYaml {
+		if v != nil && reflect.TypeOf(v).Kind() == reflect.Map && !arrayContainsStr(envSettingKeys, k) {
+			labels = append(labels, k)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// envDependencies maps each stack label in the env to the labels it depends on
+func envDependencies(envStackYaml map[string]interface{}) map[string][]string {
+	deps := make(map[string][]string)
+	for _, label := range envStackLabels(envStackYaml) {
+		deps[label] = stringList(utils.ToStrMap(envStackYaml[label])["depends_on"])
+	}
+	return deps
 }
 
+// orderedArray orders the stack labels so that dependencies come before the stacks depending on them
 func orderedArray(stackLabels []string, deps *graph.DAG) []string {
-	result := childrenNames(deps.Root, deps)
+	verts, err := deps.TopologicalSort()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	result := []string{}
+	for _, v := range verts {
+		if v.Name != root.Name && arrayContainsStr(stackLabels, v.Name) {
+			result = append(result, v.Name)
+		}
+	}
 	log.Debugf("orderedArray result: %#v\n", result)
 	return result
 }
@@ -141,41 +385,42 @@ type LabeledVertex interface {
 
 var root = &graph.Vertex{Name: "ROOT"}
 
-func depsGraph(stacks map[string]StackConfig) *graph.DAG {
+// depsGraph builds the DAG from the stack label -> depends on labels mapping,
+// stacks without dependencies hang off the root
+func depsGraph(stackDeps map[string][]string) (*graph.DAG, error) {
 	// order stack names based on DAG
 	depsGraph := graph.NewDAG()
 
 	depsGraph.AddRoot(root)
-	for _, st := range stacks {
-		s := st
+	labels := []string{}
+	for label := range stackDeps {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		parents := 0
 		// depends on maps to the stack label
-		for _, d := range s.dependsOn() {
-			src := stacks[d]
-			if s.Label() != d { // dont add a connection to myself.
-				depsGraph.AddEdgeBetweenVertices(src.Label(), s.Label())
-			} else { // add it off the root
-				depsGraph.AddEdgeBetweenVertices(root.Name, s.Label())
+		for _, d := range stackDeps[label] {
+			if d == label { // dont add a connection to myself.
+				continue
+			}
+			if _, found := stackDeps[d]; !found {
+				return nil, fmt.Errorf("stack: %s depends_on unknown stack: %s", label, d)
 			}
+			depsGraph.AddEdgeBetweenVertices(d, label)
+			parents++
 		}
-		if len(s.dependsOn()) == 0 {
+		if parents == 0 {
 			// add to the root
-			depsGraph.AddEdgeBetweenVertices(root.Name, s.Label())
+			depsGraph.AddEdgeBetweenVertices(root.Name, label)
 		}
 	}
-	depsGraph.TransitiveReduction()
-	return depsGraph
-}
-
-func childrenNames(vertex *graph.Vertex, deps *graph.DAG) []string {
-	var verts []string
-	start := deps.VertexList(vertex)
-	for _, v := range start {
-		if v != root {
-			verts = append(verts, v.Name)
-		}
+	if cycle := depsGraph.FindCycle(); cycle != nil {
+		return nil, &graph.CycleError{Path: cycle}
 	}
-
-	return verts
+	depsGraph.TransitiveReduction()
+	return depsGraph, nil
 }
 
 func (s *StacksConfig) ProcessValue(val interface{}) interface{} {
@@ -188,10 +433,11 @@ func (s *StacksConfig) ProcessValue(val interface{}) interface{} {
 			mapval[k] = s.ProcessValue(v)
 		}
 		result = mapval
-	case reflect.Array:
-		arrval := val.([]interface{})
-		for i, v := range arrval {
-			arrval[i] = s.ProcessValue(v)
+	case reflect.Array, reflect.Slice:
+		// yaml decodes lists as []interface{}
+		arrval := []interface{}{}
+		for i := 0; i < rval.Len(); i++ {
+			arrval = append(arrval, s.ProcessValue(rval.Index(i).Interface()))
 		}
 		result = arrval
 	case reflect.String:
@@ -213,16 +459,48 @@ func (e *EnvStacksConfig) FetchAll() interface{} {
 	return e.Config.ProcessValue(e.Yaml)
 }
 
+// Stack returns a selected stack, or a stack of another env the selection depends on
 func (e *EnvStacksConfig) Stack(stackLabel string) *StackConfig {
 	if s, ok := e.Stacks[stackLabel]; ok {
 		return &s
 	}
+	if s, ok := e.ExternalDeps[stackLabel]; ok {
+		return &s
+	}
+	return nil
+}
+
+// CheckExternalDeps verifies the stacks of other envs the selection depends on are deployed
+func (e *EnvStacksConfig) CheckExternalDeps(statusFinder StackStatusFinder) error {
+	labels := []string{}
+	for label := range e.ExternalDeps {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		stack := e.ExternalDeps[label]
+		status := statusFinder.FindStackStatus(stack.Name())
+		if !isDeployedStatus(status) {
+			return fmt.Errorf("stack: %s (%s) required by %s is not deployed, status: %s (use --with-deps to deploy it first)",
+				label, stack.Name(), e.Env, status)
+		}
+	}
 	return nil
 }
 
+// isDeployedStatus is true for a stack with a successful *_COMPLETE status
+func isDeployedStatus(status string) bool {
+	switch status {
+	case "DELETE_COMPLETE", "ROLLBACK_COMPLETE":
+		return false
+	}
+	return strings.HasSuffix(status, "_COMPLETE")
+}
+
 // StackConfig
 
-func newStackConfig(label string, yaml map[string]interface{}, c *StacksConfig) *StackConfig {
+func newStackConfig(env, label string, yaml map[string]interface{}, c *StacksConfig) *StackConfig {
 	name := label
 	if utils.KeyExists("stack_name", yaml) {
 		val := c.ProcessValue(fetchValue(yaml, "stack_name"))
@@ -233,6 +511,7 @@ func newStackConfig(label string, yaml map[string]interface{}, c *StacksConfig)
 	return &StackConfig{
 		Config: c,
 		Yaml:   yaml,
+		env:    env,
 		label:  label,
 		name:   name,
 	}
@@ -250,20 +529,50 @@ func (s *StackConfig) Label() string {
 	return s.label
 }
 
+func (s *StackConfig) Env() string {
+	return s.env
+}
+
+// QualifiedLabel is the "env.label" reference to the stack
+func (s *StackConfig) QualifiedLabel() string {
+	return s.env + "." + s.label
+}
+
 func (s *StackConfig) Name() string {
 	return s.name
 }
 
+// TemplatePaths are the template file paths to look for, the template value or the label, then the stack name
+func (s *StackConfig) TemplatePaths() []string {
+	templateName := s.Label()
+	if n, ok := s.Fetch("template").(string); ok {
+		templateName = n
+	}
+	p := filepath.Dir(s.Config.FileName)
+	return []string{filepath.Join(p, templateName), filepath.Join(p, s.Name())}
+}
+
 func (s *StackConfig) Hashcode() interface{} {
 	return s.Label() // label is unique
 }
 
 func (s *StackConfig) dependsOn() []string {
-	switch deps := s.Yaml["depends_on"].(type) {
+	return stringList(s.Yaml["depends_on"])
+}
+
+// stringList handles a single string or a list of strings, i.e. depends_on labels, yaml decodes lists as []interface{}
+func stringList(val interface{}) []string {
+	switch deps := val.(type) {
 	case string:
 		return []string{deps}
 	case []string:
 		return deps
+	case []interface{}:
+		result := []string{}
+		for _, d := range deps {
+			result = append(result, fmt.Sprint(d))
+		}
+		return result
 	default:
 		return []string{}
 	}
@@ -275,6 +584,24 @@ func escJsonPtr(item string) string {
 	return strings.Replace(strings.Replace(item, "~", "~0", -1), "/", "~1", -1)
 }
 
+func arrayContainsStr(arr []string, val string) bool {
+	for _, v := range arr {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUniqueStrs(arr []string, vals ...string) []string {
+	for _, v := range vals {
+		if !arrayContainsStr(arr, v) {
+			arr = append(arr, v)
+		}
+	}
+	return arr
+}
+
 func fetchValue(yaml map[string]interface{}, item string) interface{} {
 	if v, ok := yaml[item]; ok {
 		return v
@@ -20,6 +20,7 @@ package stacks
 import (
 	"fmt"
 	"os"
+	"sort"
 	"testing"
 
 	"github.com/capitalone/stack-deployment-tool/utils"
@@ -160,6 +161,172 @@ func TestDependsOn(t *testing.T) {
 	assert.Contains(t, prod.StackLabels, "nagios-server")
 }
 
+func TestDependsOnList(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+
+	qa3 := c.FetchEnvStacks("qa3")
+	assert.Equal(t, []string{"nagios-elb", "nagios-internal-dns", "nagios-server"}, qa3.StackLabels)
+	assert.Equal(t, []string{"nagios-elb", "nagios-internal-dns"}, qa3.Stack("nagios-server").dependsOn())
+
+	// dependencies that were not selected dont drop the selected stack
+	selected := c.FetchEnvStacks("qa3.nagios-server")
+	assert.Equal(t, []string{"nagios-server"}, selected.StackLabels)
+}
+
+func TestProcessValueList(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	list := []interface{}{`{{env key="SDT_UNSET_VAR" default="a"}}`, map[string]interface{}{"b": `{{env key="SDT_UNSET_VAR" default="b"}}`}}
+	assert.Equal(t, []interface{}{"a", map[string]interface{}{"b": "b"}}, c.ProcessValue(list))
+}
+
+func TestDepsGraphErrors(t *testing.T) {
+	_, err := depsGraph(map[string][]string{
+		"nagios-elb":    {"nagios-server"},
+		"nagios-server": {"nagios-elb"},
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, "dependency cycle: nagios-elb -> nagios-server -> nagios-elb", err.Error())
+
+	_, err = depsGraph(map[string][]string{
+		"nagios-server": {"nagios-elbb"},
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unknown stack: nagios-elbb")
+
+	deps, err := depsGraph(map[string][]string{
+		"nagios-elb": {"nagios-elb"}, // depends on self
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nagios-elb"}, orderedArray([]string{"nagios-elb"}, deps))
+}
+
+func TestParseStackRef(t *testing.T) {
+	env, patterns := parseStackRef("qa")
+	assert.Equal(t, "qa", env)
+	assert.Nil(t, patterns)
+
+	env, patterns = parseStackRef("qa.nagios-elb")
+	assert.Equal(t, "qa", env)
+	assert.Equal(t, []string{"nagios-elb"}, patterns)
+
+	env, patterns = parseStackRef("qa[nagios-elb, nagios-*]")
+	assert.Equal(t, "qa", env)
+	assert.Equal(t, []string{"nagios-elb", "nagios-*"}, patterns)
+}
+
+func TestFetchEnvStacksSelection(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+
+	multi := c.FetchEnvStacks("qa2[nagios-server, nagios-r53]")
+	assert.Equal(t, []string{"nagios-r53", "nagios-server"}, multi.StackLabels)
+
+	glob := c.FetchEnvStacks("qa2[nagios-r*]")
+	assert.Equal(t, []string{"nagios-r53"}, glob.StackLabels)
+
+	withDeps := c.FetchEnvStacksSelection("qa2.nagios-server", &StackSelection{WithDeps: true})
+	assert.Equal(t, []string{"nagios-elb", "nagios-server"}, withDeps.StackLabels)
+	assert.NotNil(t, withDeps.Stack("nagios-elb"))
+
+	downstream := c.FetchEnvStacksSelection("qa2.nagios-internal-dns", &StackSelection{Downstream: true})
+	assert.Equal(t, []string{"nagios-internal-dns", "nagios-r53"}, downstream.StackLabels)
+
+	excluded := c.FetchEnvStacksSelection("qa2", &StackSelection{Exclude: []string{"nagios-elb", "*-dns"}})
+	assert.Equal(t, []string{"nagios-r53", "nagios-server"}, excluded.StackLabels)
+	assert.Nil(t, excluded.Stack("nagios-elb"))
+}
+
+func TestSelectStackLabelsErrors(t *testing.T) {
+	deps, err := depsGraph(map[string][]string{"nagios-elb": {}})
+	assert.Nil(t, err)
+
+	_, err = selectStackLabels([]string{"nagios-elb"}, []string{"nagios-srv"}, deps, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, "no stacks found for: nagios-srv", err.Error())
+
+	_, err = selectStackLabels([]string{"nagios-elb"}, []string{"nagios-[elb"}, deps, nil)
+	assert.NotNil(t, err)
+}
+
+type mapStatusFinder map[string]string
+
+func (f mapStatusFinder) FindStackStatus(stackName string) string {
+	if status, ok := f[stackName]; ok {
+		return status
+	}
+	return "Not Found"
+}
+
+func TestCrossEnvDependencies(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+
+	qa4 := c.FetchEnvStacks("qa4")
+	assert.Equal(t, []string{"nagios-elb", "nagios-server"}, qa4.StackLabels)
+	assert.Equal(t, []string{"shared.vpc", "shared.vpc-endpoints"}, sortedKeys(qa4.ExternalDeps))
+	assert.Equal(t, "shared-vpc", qa4.Stack("shared.vpc").Name())
+	assert.Equal(t, "shared", qa4.Stack("shared.vpc").Env())
+	assert.Equal(t, "qa4.nagios-server", qa4.Stack("nagios-server").QualifiedLabel())
+
+	withDeps := c.FetchEnvStacksSelection("qa4.nagios-elb", &StackSelection{WithDeps: true})
+	assert.Equal(t, []string{"shared.vpc", "shared.vpc-endpoints", "nagios-elb"}, withDeps.StackLabels)
+	assert.Equal(t, "shared-vpc-endpoints", withDeps.Stack("shared.vpc-endpoints").Name())
+	assert.Equal(t, "vpc-endpoints", withDeps.Stack("shared.vpc-endpoints").Label())
+	assert.Empty(t, withDeps.ExternalDeps)
+
+	stackDeps, err := c.stackDependencies("qa", map[string]interface{}{
+		"nagios-elb": map[string]interface{}{"depends_on": "shared.vppc"},
+	})
+	assert.Nil(t, stackDeps)
+	assert.Equal(t, "depends_on unknown stack: shared.vppc", err.Error())
+
+	_, err = c.stackDependencies("qa", map[string]interface{}{
+		"nagios-elb": map[string]interface{}{"depends_on": "shard.vpc"},
+	})
+	assert.Equal(t, "depends_on unknown environment: shard.vpc", err.Error())
+}
+
+func TestCheckExternalDeps(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	qa4 := c.FetchEnvStacks("qa4")
+
+	assert.Nil(t, qa4.CheckExternalDeps(mapStatusFinder{
+		"shared-vpc":           "UPDATE_COMPLETE",
+		"shared-vpc-endpoints": "CREATE_COMPLETE",
+	}))
+
+	err := qa4.CheckExternalDeps(mapStatusFinder{"shared-vpc": "CREATE_COMPLETE"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "shared.vpc-endpoints (shared-vpc-endpoints) required by qa4 is not deployed, status: Not Found")
+
+	err = qa4.CheckExternalDeps(mapStatusFinder{
+		"shared-vpc":           "ROLLBACK_COMPLETE",
+		"shared-vpc-endpoints": "CREATE_COMPLETE",
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "status: ROLLBACK_COMPLETE")
+}
+
+func TestStackName(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+
+	name, err := c.StackName("shared.vpc-endpoints")
+	assert.Nil(t, err)
+	assert.Equal(t, "shared-vpc-endpoints", name)
+
+	_, err = c.StackName("vpc")
+	assert.NotNil(t, err)
+	_, err = c.StackName("shared.vpcc")
+	assert.NotNil(t, err)
+}
+
+func sortedKeys(m map[string]StackConfig) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func indexInArray(key string, arr []string) int {
 	result := -1
 	for i, v := range arr {
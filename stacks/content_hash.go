@@ -0,0 +1,85 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const (
+	// stack tag with the hash of the template body, parameters and tags of the last deploy
+	ContentHashTag = "sdt:content-hash"
+)
+
+// contentHash hashes the rendered template body, parameters, tags and settings of a stack,
+// the content hash tag itself is left out
+func contentHash(template string, parameters map[string]interface{}, tags map[string]interface{}, settings *stackSettings) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "template:%d:%s\n", len(template), template)
+	writeSorted(h, "parameter", parameters)
+	writeSorted(h, "tag", tags)
+	// stacks without settings keep the hash they had before settings
+	if s := settings.String(); len(s) > 0 {
+		fmt.Fprintf(h, "settings:%s\n", s)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func writeSorted(w io.Writer, prefix string, m map[string]interface{}) {
+	keys := []string{}
+	for k := range m {
+		if k != ContentHashTag {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s:%q=%q\n", prefix, k, fmt.Sprint(m[k]))
+	}
+}
+
+// withContentHash copies the tags with the content hash tag added
+func withContentHash(tags map[string]interface{}, hash string) map[string]interface{} {
+	result := make(map[string]interface{})
+	for k, v := range tags {
+		result[k] = v
+	}
+	result[ContentHashTag] = hash
+	return result
+}
+
+// stackContentHash is the content hash tag of a deployed stack
+func stackContentHash(stack *cloudformation.Stack) string {
+	for _, tag := range stack.Tags {
+		if tag.Key != nil && *tag.Key == ContentHashTag && tag.Value != nil {
+			return *tag.Value
+		}
+	}
+	return ""
+}
+
+// isStackUnchanged is true when the stack was deployed successfully with the same content
+func isStackUnchanged(stack *cloudformation.Stack, hash string) bool {
+	return stack.StackStatus != nil && isDeployedStatus(*stack.StackStatus) &&
+		*stack.StackStatus != cloudformation.StackStatusUpdateRollbackComplete && stackContentHash(stack) == hash
+}
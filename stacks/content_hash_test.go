@@ -0,0 +1,66 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentHash(t *testing.T) {
+	params := map[string]interface{}{"Port": "80", "Name": "elb"}
+	tags := map[string]interface{}{"Owner": "me"}
+	hash := contentHash("{}", params, tags, nil)
+	assert.Len(t, hash, 64)
+
+	assert.Equal(t, hash, contentHash("{}", map[string]interface{}{"Name": "elb", "Port": "80"}, tags, nil))
+	assert.Equal(t, hash, contentHash("{}", params, withContentHash(tags, "abc"), nil))
+	assert.NotEqual(t, hash, contentHash("{ }", params, tags, nil))
+	assert.NotEqual(t, hash, contentHash("{}", map[string]interface{}{"Port": "81", "Name": "elb"}, tags, nil))
+	assert.NotEqual(t, hash, contentHash("{}", params, map[string]interface{}{"Owner": "you"}, nil))
+	// values dont move between parameters and tags
+	assert.NotEqual(t, contentHash("", map[string]interface{}{"A": "1"}, nil, nil), contentHash("", nil, map[string]interface{}{"A": "1"}, nil))
+
+	// settings only change the hash when there are some
+	assert.Equal(t, hash, contentHash("{}", params, tags, &stackSettings{}))
+	protected := contentHash("{}", params, tags, &stackSettings{TerminationProtection: aws.Bool(true)})
+	assert.NotEqual(t, hash, protected)
+	assert.NotEqual(t, protected, contentHash("{}", params, tags, &stackSettings{TerminationProtection: aws.Bool(false)}))
+	assert.NotEqual(t, hash, contentHash("{}", params, tags, &stackSettings{StackPolicy: allowAllStackPolicy}))
+}
+
+func TestIsStackUnchanged(t *testing.T) {
+	stack := &cloudformation.Stack{
+		StackStatus: aws.String(cloudformation.StackStatusUpdateComplete),
+		Tags: []*cloudformation.Tag{
+			{Key: aws.String("Owner"), Value: aws.String("me")},
+			{Key: aws.String(ContentHashTag), Value: aws.String("abc")},
+		},
+	}
+	assert.Equal(t, "abc", stackContentHash(stack))
+	assert.True(t, isStackUnchanged(stack, "abc"))
+	assert.False(t, isStackUnchanged(stack, "abd"))
+
+	stack.StackStatus = aws.String(cloudformation.StackStatusUpdateRollbackComplete)
+	assert.False(t, isStackUnchanged(stack, "abc"))
+
+	assert.Equal(t, "", stackContentHash(&cloudformation.Stack{}))
+}
@@ -0,0 +1,285 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// most keys a DeleteObjects request takes
+const maxDeleteObjects = 1000
+
+// stackImport is an export of a stack being deleted that is imported by stacks that remain
+type stackImport struct {
+	StackName  string
+	ExportName string
+	ImportedBy []string
+}
+
+// listImports are the names of the stacks importing the export
+func (a *AWSStackApi) listImports(exportName string) ([]string, error) {
+	params := &listImportsInput{ExportName: aws.String(exportName)}
+	imports := []string{}
+	for {
+		resp := &listImportsOutput{}
+		if err := a.cfRequest("ListImports", params, resp); err != nil {
+			if aerr, ok := err.(awserr.Error); ok && strings.Contains(aerr.Message(), "is not imported by any stack") {
+				return imports, nil
+			}
+			return nil, err
+		}
+		imports = append(imports, aws.StringValueSlice(resp.Imports)...)
+		if resp.NextToken == nil {
+			return imports, nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+// stackExports maps the ids of the stacks to the names of their exports
+func (a *AWSStackApi) stackExports() (map[string][]string, error) {
+	exports := map[string][]string{}
+	params := &cloudformation.ListExportsInput{}
+	for {
+		resp, err := a.CFService().ListExports(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range resp.Exports {
+			id := aws.StringValue(e.ExportingStackId)
+			exports[id] = append(exports[id], aws.StringValue(e.Name))
+		}
+		if resp.NextToken == nil {
+			return exports, nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+// checkImports finds the exports of the stacks being deleted that remaining stacks import,
+// cloudformation refuses to delete them
+func (a *AWSStackApi) checkImports(envStacks *EnvStacksConfig) ([]stackImport, error) {
+	deleting := map[string]string{} // stack id -> name
+	names := []string{}
+	for _, label := range envStacks.StackLabels {
+		name := envStacks.Stack(label).Name()
+		names = append(names, name)
+		if stack := a.FindStack(name); stack != nil {
+			deleting[aws.StringValue(stack.StackId)] = name
+		}
+	}
+	if len(deleting) == 0 {
+		return nil, nil
+	}
+	exports, err := a.stackExports()
+	if err != nil {
+		return nil, err
+	}
+	stackExports := map[string][]string{}
+	for id, name := range deleting {
+		stackExports[name] = exports[id]
+	}
+	return blockingImports(names, stackExports, a.listImports)
+}
+
+// blockingImports are the exports of the stacks being deleted imported by stacks that are not
+func blockingImports(deleting []string, exports map[string][]string, importers func(exportName string) ([]string, error)) ([]stackImport, error) {
+	blocking := []stackImport{}
+	for _, name := range deleting {
+		for _, export := range exports[name] {
+			imports, err := importers(export)
+			if err != nil {
+				return nil, fmt.Errorf("Error listing imports of: %s %v", export, err)
+			}
+			remaining := []string{}
+			for _, importer := range imports {
+				if !arrayContainsStr(deleting, importer) {
+					remaining = append(remaining, importer)
+				}
+			}
+			if len(remaining) > 0 {
+				sort.Strings(remaining)
+				blocking = append(blocking, stackImport{StackName: name, ExportName: export, ImportedBy: remaining})
+			}
+		}
+	}
+	return blocking, nil
+}
+
+// deleteBlockers are the reasons cloudformation would refuse to delete the stacks, imports are reported in a table
+func (a *AWSStackApi) deleteBlockers(envStacks *EnvStacksConfig) []string {
+	reasons := []string{}
+	imports, err := a.checkImports(envStacks)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(imports) > 0 {
+		printImports(imports)
+		reasons = append(reasons, "the exports above are imported by stacks that are not deleted, delete or change them first")
+	}
+
+	names := []string{}
+	for _, label := range envStacks.StackLabels {
+		names = append(names, envStacks.Stack(label).Name())
+	}
+	protected, err := a.terminationProtected(names)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(protected) > 0 {
+		reasons = append(reasons, fmt.Sprintf("termination protection is enabled on: %s, set termination_protection: false and deploy them first",
+			strings.Join(protected, ", ")))
+	}
+	return reasons
+}
+
+func printImports(imports []stackImport) {
+	tbl := utils.NewTableWriter(os.Stdout, 40, 40, 60)
+	tbl.WriteHeader("Stack", "Export", "Imported By")
+	for _, i := range imports {
+		tbl.WriteRow(i.StackName, i.ExportName, strings.Join(i.ImportedBy, ", "))
+	}
+	tbl.Footer()
+}
+
+// emptyStackBuckets deletes every object version of the buckets of the stack, a bucket must be empty to be deleted.
+// The retained buckets are left as they are, their delete fails and they are kept.
+func (a *AWSStackApi) emptyStackBuckets(stackName string, retain []string) error {
+	params := &cloudformation.ListStackResourcesInput{StackName: aws.String(stackName)}
+	resources := []*cloudformation.StackResourceSummary{}
+	err := a.CFService().ListStackResourcesPages(params, func(page *cloudformation.ListStackResourcesOutput, lastPage bool) bool {
+		resources = append(resources, page.StackResourceSummaries...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, bucket := range bucketsToEmpty(resources, retain) {
+		log.Infof("Emptying bucket: %s of stack: %s", bucket, stackName)
+		if err := a.emptyBucket(bucket); err != nil {
+			return fmt.Errorf("Error emptying bucket: %s %v", bucket, err)
+		}
+	}
+	return nil
+}
+
+// bucketsToEmpty are the names of the existing buckets of the stack resources, except the retained logical ids
+func bucketsToEmpty(resources []*cloudformation.StackResourceSummary, retain []string) []string {
+	buckets := []string{}
+	for _, r := range resources {
+		if aws.StringValue(r.ResourceType) != "AWS::S3::Bucket" || r.PhysicalResourceId == nil ||
+			aws.StringValue(r.ResourceStatus) == cloudformation.ResourceStatusDeleteComplete {
+			continue
+		}
+		if arrayContainsStr(retain, aws.StringValue(r.LogicalResourceId)) {
+			log.Infof("Not emptying retained bucket: %s", aws.StringValue(r.PhysicalResourceId))
+			continue
+		}
+		buckets = append(buckets, aws.StringValue(r.PhysicalResourceId))
+	}
+	return buckets
+}
+
+func (a *AWSStackApi) emptyBucket(bucket string) error {
+	objects := []*s3.ObjectIdentifier{}
+	err := a.S3Service().ListObjectVersionsPages(&s3.ListObjectVersionsInput{Bucket: aws.String(bucket)},
+		func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			for _, v := range page.Versions {
+				objects = append(objects, &s3.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
+			}
+			for _, m := range page.DeleteMarkers {
+				objects = append(objects, &s3.ObjectIdentifier{Key: m.Key, VersionId: m.VersionId})
+			}
+			return true
+		})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchBucket" {
+			return nil
+		}
+		return err
+	}
+	for _, batch := range deleteBatches(objects) {
+		resp, err := a.S3Service().DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: batch, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(resp.Errors) > 0 {
+			e := resp.Errors[0]
+			return fmt.Errorf("%d objects not deleted, i.e.: %s %s", len(resp.Errors), aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
+	return nil
+}
+
+func deleteBatches(objects []*s3.ObjectIdentifier) [][]*s3.ObjectIdentifier {
+	batches := [][]*s3.ObjectIdentifier{}
+	for len(objects) > maxDeleteObjects {
+		batches = append(batches, objects[:maxDeleteObjects])
+		objects = objects[maxDeleteObjects:]
+	}
+	if len(objects) > 0 {
+		batches = append(batches, objects)
+	}
+	return batches
+}
+
+// deleteEnvStack empties the buckets of the stack when it has empty_buckets, except the retained ones, and deletes it
+func (a *AWSStackApi) deleteEnvStack(stack *StackConfig) error {
+	if isTrue(stack.Fetch("empty_buckets")) {
+		if err := a.emptyStackBuckets(stack.Name(), retainResources(stack)); err != nil {
+			log.Errorf("%v", err)
+			return err
+		}
+	}
+	return a.deleteStack(stack.Name(), retainResources(stack))
+}
+
+// failedResources are the retain logical ids whose delete failed, only those can be retained
+func (a *AWSStackApi) failedResources(stackName string, retain []string) ([]string, error) {
+	failed := []string{}
+	params := &cloudformation.ListStackResourcesInput{StackName: aws.String(stackName)}
+	err := a.CFService().ListStackResourcesPages(params, func(page *cloudformation.ListStackResourcesOutput, lastPage bool) bool {
+		for _, r := range page.StackResourceSummaries {
+			id := aws.StringValue(r.LogicalResourceId)
+			if aws.StringValue(r.ResourceStatus) == cloudformation.ResourceStatusDeleteFailed && arrayContainsStr(retain, id) {
+				failed = append(failed, id)
+			}
+		}
+		return true
+	})
+	return failed, err
+}
+
+// retainResources are the logical ids the stack keeps when its delete fails, the retain_resources of the stack
+func retainResources(stack *StackConfig) []string {
+	return stringList(stack.Fetch("retain_resources"))
+}
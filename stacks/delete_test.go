@@ -0,0 +1,95 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockingImports(t *testing.T) {
+	exports := map[string][]string{
+		"vpc-qa":  {"qa-VpcId", "qa-SubnetIds"},
+		"elb-qa":  {"qa-ElbDNS"},
+		"logs-qa": nil,
+	}
+	importers := map[string][]string{
+		"qa-VpcId":     {"elb-qa", "db-qa", "app-qa"},
+		"qa-SubnetIds": {"elb-qa"},
+		"qa-ElbDNS":    {"app-qa"},
+	}
+	listImports := func(export string) ([]string, error) { return importers[export], nil }
+
+	blocking, err := blockingImports([]string{"vpc-qa", "elb-qa", "logs-qa"}, exports, listImports)
+	assert.Nil(t, err)
+	assert.Equal(t, []stackImport{
+		{StackName: "vpc-qa", ExportName: "qa-VpcId", ImportedBy: []string{"app-qa", "db-qa"}},
+		{StackName: "elb-qa", ExportName: "qa-ElbDNS", ImportedBy: []string{"app-qa"}},
+	}, blocking)
+
+	// importers deleted along with the exporting stack dont block
+	blocking, err = blockingImports([]string{"vpc-qa", "elb-qa", "db-qa", "app-qa"}, exports, listImports)
+	assert.Nil(t, err)
+	assert.Empty(t, blocking)
+
+	_, err = blockingImports([]string{"vpc-qa"}, exports, func(string) ([]string, error) { return nil, errors.New("throttled") })
+	assert.NotNil(t, err)
+}
+
+func TestDeleteBatches(t *testing.T) {
+	objects := []*s3.ObjectIdentifier{}
+	for i := 0; i < 2001; i++ {
+		objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(fmt.Sprint(i))})
+	}
+	batches := deleteBatches(objects)
+	assert.Len(t, batches, 3)
+	assert.Len(t, batches[0], maxDeleteObjects)
+	assert.Len(t, batches[2], 1)
+	assert.Equal(t, "2000", aws.StringValue(batches[2][0].Key))
+	assert.Empty(t, deleteBatches(nil))
+}
+
+func TestBucketsToEmpty(t *testing.T) {
+	bucket := func(id, name, status string) *cloudformation.StackResourceSummary {
+		return &cloudformation.StackResourceSummary{LogicalResourceId: aws.String(id), PhysicalResourceId: aws.String(name),
+			ResourceType: aws.String("AWS::S3::Bucket"), ResourceStatus: aws.String(status)}
+	}
+	resources := []*cloudformation.StackResourceSummary{
+		bucket("DataBucket", "qa-data", cloudformation.ResourceStatusCreateComplete),
+		bucket("LogBucket", "qa-logs", cloudformation.ResourceStatusCreateComplete),
+		bucket("OldBucket", "qa-old", cloudformation.ResourceStatusDeleteComplete),
+		{LogicalResourceId: aws.String("Queue"), PhysicalResourceId: aws.String("q"), ResourceType: aws.String("AWS::SQS::Queue")},
+	}
+	assert.Equal(t, []string{"qa-data"}, bucketsToEmpty(resources, []string{"LogBucket"}))
+	assert.Equal(t, []string{"qa-data", "qa-logs"}, bucketsToEmpty(resources, nil))
+}
+
+func TestRetainResources(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	build := c.FetchEnvStacks("build")
+	assert.Equal(t, []string{"LogBucket"}, retainResources(build.Stack("nagios-elb")))
+	assert.True(t, isTrue(build.Stack("nagios-elb").Fetch("empty_buckets")))
+	assert.Empty(t, retainResources(build.Stack("nagios-server")))
+	assert.False(t, isTrue(build.Stack("nagios-server").Fetch("empty_buckets")))
+}
@@ -0,0 +1,136 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// DiffStacks prints a unified diff of the deployed templates and parameters of the stacks, and the rendered ones
+func (a *AWSStackApi) DiffStacks(envStacks *EnvStacksConfig, color bool) {
+	a.useTemplateBucket(envStacks.Config)
+	for _, stackLabel := range envStacks.StackLabels {
+		stack := envStacks.Stack(stackLabel)
+		fmt.Printf("# %s (%s)\n", stackLabel, stack.Name())
+		deployed := a.FindStack(stack.Name())
+		if deployed == nil {
+			fmt.Println("not deployed")
+			continue
+		}
+		resp, err := a.CFService().GetTemplate(&cloudformation.GetTemplateInput{StackName: deployed.StackName})
+		if err != nil {
+			log.Fatalf("Error getting the template of stack: %s %v", stack.Name(), err)
+		}
+
+		template := a.previewTemplate(stack)
+		params := withDefaults(template, utils.ToStrMap(utils.ToStrMap(stack.FetchAll())["parameters"]))
+		templateFile := findTemplateFile(stack.TemplatePaths()...)
+		diff := templateDiff(stack.Name()+" deployed", templateFile, aws.StringValue(resp.TemplateBody), template) +
+			parametersDiff(stack.Name()+" deployed parameters", stackLabel+" parameters", deployed.Parameters, params)
+		if len(diff) == 0 {
+			fmt.Println("no differences")
+			continue
+		}
+		if color {
+			diff = utils.ColorDiff(diff)
+		}
+		fmt.Print(diff)
+	}
+}
+
+// templateDiff is the unified diff of the deployed and the rendered template. Yaml templates are compared as they are,
+// when one of them is json both are compared as yaml with sorted keys.
+func templateDiff(deployedName, name, deployed, template string) string {
+	if isJSONTemplate(deployed) || isJSONTemplate(template) {
+		deployed, template = normalizeTemplate(deployed), normalizeTemplate(template)
+	}
+	return utils.UnifiedDiff(deployedName, name, deployed, template)
+}
+
+func isJSONTemplate(template string) bool {
+	return strings.HasPrefix(strings.TrimSpace(template), "{")
+}
+
+// normalizeTemplate is the template as yaml with sorted keys and long form intrinsic functions,
+// or the template as it is when it can't be decoded
+func normalizeTemplate(template string) string {
+	doc, err := decodeTemplate(template)
+	if err != nil {
+		log.Warnf("Comparing the template text, error decoding it: %v", err)
+		return template
+	}
+	return string(utils.EncodeYAML(doc))
+}
+
+// parametersDiff is the unified diff of the deployed and the rendered parameters, one "key: value" line each.
+// The deployed NoEcho values are masked, they are not compared.
+func parametersDiff(deployedName, name string, deployed []*cloudformation.Parameter, params map[string]interface{}) string {
+	deployedLines := []string{}
+	noEcho := map[string]bool{}
+	for _, p := range deployed {
+		key, val := aws.StringValue(p.ParameterKey), aws.StringValue(p.ParameterValue)
+		if val == noEchoValue {
+			noEcho[key] = true
+		}
+		deployedLines = append(deployedLines, key+": "+val)
+	}
+	lines := []string{}
+	for key, val := range params {
+		if noEcho[key] {
+			val = noEchoValue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %v", key, val))
+	}
+	sort.Strings(deployedLines)
+	sort.Strings(lines)
+	return utils.UnifiedDiff(deployedName, name, joinLines(deployedLines), joinLines(lines))
+}
+
+// withDefaults are the parameters with the Defaults of the template for the ones not passed,
+// CloudFormation deployed the stack with them
+func withDefaults(template string, params map[string]interface{}) map[string]interface{} {
+	all := map[string]interface{}{}
+	doc, err := decodeTemplate(template)
+	if err != nil {
+		log.Warnf("Comparing the parameters without the template Defaults, error decoding it: %v", err)
+	}
+	for name, decl := range utils.ToStrMap(doc["Parameters"]) {
+		if val, ok := utils.ToStrMap(decl)["Default"]; ok {
+			all[name] = val
+		}
+	}
+	for name, val := range params {
+		all[name] = val
+	}
+	return all
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,83 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateDiff(t *testing.T) {
+	yml := "Resources:\n  Queue:\n    Type: AWS::SQS::Queue\n    Properties:\n      QueueName: !Sub '${Env}-queue'\n"
+	assert.Equal(t, "", templateDiff("deployed", "local", yml, yml))
+
+	changed := "Resources:\n  Queue:\n    Type: AWS::SQS::Queue\n    Properties:\n      QueueName: !Sub '${Env}-jobs'\n"
+	assert.Equal(t, `--- deployed
++++ local
+@@ -2,4 +2,4 @@
+   Queue:
+     Type: AWS::SQS::Queue
+     Properties:
+-      QueueName: !Sub '${Env}-queue'
++      QueueName: !Sub '${Env}-jobs'
+`, templateDiff("deployed", "local", yml, changed))
+
+	// the same template deployed as json
+	json := `{"Resources":{"Queue":{"Properties":{"QueueName":{"Fn::Sub":"${Env}-queue"}},"Type":"AWS::SQS::Queue"}}}`
+	assert.Equal(t, "", templateDiff("deployed", "local", json, yml))
+	diff := templateDiff("deployed", "local", json, changed)
+	assert.Contains(t, diff, "-        Fn::Sub: ${Env}-queue\n+        Fn::Sub: ${Env}-jobs\n")
+}
+
+func TestParametersDiff(t *testing.T) {
+	deployed := []*cloudformation.Parameter{
+		{ParameterKey: aws.String("InstanceType"), ParameterValue: aws.String("t2.micro")},
+		{ParameterKey: aws.String("Password"), ParameterValue: aws.String("****")},
+		{ParameterKey: aws.String("Env"), ParameterValue: aws.String("qa")},
+	}
+	params := map[string]interface{}{"Env": "qa", "InstanceType": "t2.micro", "Password": "secret"}
+	assert.Equal(t, "", parametersDiff("deployed", "local", deployed, params))
+
+	params["InstanceType"] = "m5.large"
+	params["KeyName"] = "ops"
+	assert.Equal(t, `--- deployed
++++ local
+@@ -1,3 +1,4 @@
+ Env: qa
+-InstanceType: t2.micro
++InstanceType: m5.large
++KeyName: ops
+ Password: ****
+`, parametersDiff("deployed", "local", deployed, params))
+}
+
+func TestWithDefaults(t *testing.T) {
+	template := "Parameters:\n  Env:\n    Type: String\n  InstanceType:\n    Type: String\n    Default: t2.micro\n  Port:\n    Type: Number\n    Default: 8080\n"
+	params := withDefaults(template, map[string]interface{}{"Env": "qa", "Port": 443})
+	assert.Equal(t, map[string]interface{}{"Env": "qa", "InstanceType": "t2.micro", "Port": 443}, params)
+
+	deployed := []*cloudformation.Parameter{
+		{ParameterKey: aws.String("Env"), ParameterValue: aws.String("qa")},
+		{ParameterKey: aws.String("InstanceType"), ParameterValue: aws.String("t2.micro")},
+		{ParameterKey: aws.String("Port"), ParameterValue: aws.String("8080")},
+	}
+	assert.Equal(t, "", parametersDiff("deployed", "local", deployed, withDefaults(template, map[string]interface{}{"Env": "qa"})))
+}
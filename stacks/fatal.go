@@ -0,0 +1,71 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type fatalCleanup struct {
+	fn func(msg string)
+}
+
+var (
+	fatalCleanups []*fatalCleanup
+	fatalMu       sync.Mutex
+	fatalHookOnce sync.Once
+)
+
+// fatalHook runs the pending cleanups, newest first, log.Fatal exits without returning
+type fatalHook struct{}
+
+func (h *fatalHook) Levels() []log.Level {
+	return []log.Level{log.FatalLevel, log.PanicLevel}
+}
+
+func (h *fatalHook) Fire(entry *log.Entry) error {
+	fatalMu.Lock()
+	cleanups := fatalCleanups
+	fatalCleanups = nil
+	fatalMu.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i].fn(entry.Message)
+	}
+	return nil
+}
+
+// onFatal runs fn with the message of a fatal error until the returned remove func is called
+func onFatal(fn func(msg string)) (remove func()) {
+	fatalHookOnce.Do(func() { log.AddHook(&fatalHook{}) })
+	cleanup := &fatalCleanup{fn: fn}
+	fatalMu.Lock()
+	fatalCleanups = append(fatalCleanups, cleanup)
+	fatalMu.Unlock()
+	return func() {
+		fatalMu.Lock()
+		defer fatalMu.Unlock()
+		for i, c := range fatalCleanups {
+			if c == cleanup {
+				fatalCleanups = append(fatalCleanups[:i], fatalCleanups[i+1:]...)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnFatal(t *testing.T) {
+	ran := []string{}
+	onFatal(func(msg string) { ran = append(ran, "first: "+msg) })
+	remove := onFatal(func(msg string) { ran = append(ran, "removed: "+msg) })
+	onFatal(func(msg string) { ran = append(ran, "last: "+msg) })
+	remove()
+
+	assert.Nil(t, (&fatalHook{}).Fire(&log.Entry{Message: "boom"}))
+	assert.Equal(t, []string{"last: boom", "first: boom"}, ran)
+
+	// cleanups run once
+	assert.Nil(t, (&fatalHook{}).Fire(&log.Entry{Message: "again"}))
+	assert.Len(t, ran, 2)
+}
@@ -0,0 +1,116 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/graph"
+)
+
+const (
+	GraphFormatASCII   = "ascii"
+	GraphFormatDot     = "dot"
+	GraphFormatMermaid = "mermaid"
+)
+
+type StackStatusFinder interface {
+	FindStackStatus(stackName string) string
+}
+
+// WriteStacksGraph writes the dependency graph of the selected stacks of each env,
+// statusFinder is optional and adds the live status of each stack.
+func WriteStacksGraph(w io.Writer, format string, envs []*EnvStacksConfig, statusFinder StackStatusFinder) error {
+	switch strings.ToLower(format) {
+	case GraphFormatDot:
+		fmt.Fprintf(w, "digraph \"stacks\" {\n")
+		fmt.Fprintf(w, "  rankdir=LR;\n")
+		for _, e := range envs {
+			e.StacksGraph().WriteDotCluster(w, e.Env, e.graphLabelFunc(statusFinder, "\n"))
+		}
+		fmt.Fprintf(w, "}\n")
+	case GraphFormatMermaid:
+		fmt.Fprintf(w, "graph TD\n")
+		for _, e := range envs {
+			e.StacksGraph().WriteMermaidSubgraph(w, e.Env, e.graphLabelFunc(statusFinder, "\n"))
+		}
+	case GraphFormatASCII:
+		for i, e := range envs {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s:\n", e.Env)
+			e.StacksGraph().WriteASCII(w, e.graphLabelFunc(statusFinder, " "))
+		}
+	default:
+		return fmt.Errorf("unknown graph format: %s (supported: %s, %s, %s)", format,
+			GraphFormatASCII, GraphFormatDot, GraphFormatMermaid)
+	}
+	return nil
+}
+
+// StacksGraph is the dependency graph of the selected stacks, without the root. Deps is reduced, a selected stack
+// may depend on another through stacks not selected, so the edges are taken from the stacks each one reaches
+// and reduced again.
+func (e *EnvStacksConfig) StacksGraph() *graph.DAG {
+	sub := graph.NewDAG()
+	sub.AddRoot(root)
+	selected := []*graph.Vertex{}
+	for _, v := range e.Deps.SortedVertices() {
+		if v.Name != root.Name && arrayContainsStr(e.StackLabels, v.Name) {
+			sub.AddVertex(v)
+			selected = append(selected, v)
+		}
+	}
+	for _, v := range selected {
+		for _, d := range e.Deps.Descendants(v) {
+			if sub.VertexExists(d) {
+				sub.AddEdge(&graph.Edge{Parent: v, Child: d})
+			}
+		}
+	}
+	for _, v := range selected {
+		if len(sub.Parents(v)) == 0 {
+			sub.AddEdge(&graph.Edge{Parent: root, Child: v})
+		}
+	}
+	sub.TransitiveReduction()
+	return sub.Subgraph(func(v *graph.Vertex) bool {
+		return v.Name != root.Name
+	})
+}
+
+// graphLabelFunc labels each vertex with the stack label, stack name and the optional status
+func (e *EnvStacksConfig) graphLabelFunc(statusFinder StackStatusFinder, sep string) graph.VertexLabelFunc {
+	return func(v *graph.Vertex) string {
+		parts := []string{v.Name}
+		stack := e.Stack(v.Name)
+		if stack == nil {
+			return v.Name
+		}
+		if stack.Name() != stack.Label() {
+			parts = append(parts, "("+stack.Name()+")")
+		}
+		if statusFinder != nil {
+			parts = append(parts, "["+statusFinder.FindStackStatus(stack.Name())+"]")
+		}
+		return strings.Join(parts, sep)
+	}
+}
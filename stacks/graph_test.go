@@ -0,0 +1,78 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type FakeStatusFinder struct{}
+
+func (f *FakeStatusFinder) FindStackStatus(stackName string) string {
+	return "CREATE_COMPLETE"
+}
+
+func TestEnvNames(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks.yml"), tempOutputFinder())
+	// yamlref has no stacks
+	assert.Equal(t, []string{"build", "dev", "issue63", "prod", "qa"}, c.EnvNames())
+}
+
+func TestWriteStacksGraph(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	envs := []*EnvStacksConfig{c.FetchEnvStacks("qa3")}
+
+	out := bytes.NewBuffer([]byte{})
+	assert.Nil(t, WriteStacksGraph(out, GraphFormatASCII, envs, &FakeStatusFinder{}))
+	assert.Equal(t, `qa3:
+nagios-elb (nagios-elb-qa3) [CREATE_COMPLETE]
+└── nagios-server (nagios-server-qa3) [CREATE_COMPLETE]
+nagios-internal-dns (nagios-dns-qa3) [CREATE_COMPLETE]
+└── nagios-server (nagios-server-qa3) [CREATE_COMPLETE] (see above)
+`, out.String())
+
+	out.Reset()
+	assert.Nil(t, WriteStacksGraph(out, GraphFormatDot, envs, nil))
+	assert.Contains(t, out.String(), `"qa3.nagios-elb" -> "qa3.nagios-server";`)
+	assert.Contains(t, out.String(), `"qa3.nagios-internal-dns" [label="nagios-internal-dns\n(nagios-dns-qa3)"];`)
+
+	out.Reset()
+	assert.Nil(t, WriteStacksGraph(out, GraphFormatMermaid, envs, nil))
+	assert.Contains(t, out.String(), "qa3_nagios_internal_dns --> qa3_nagios_server")
+
+	assert.NotNil(t, WriteStacksGraph(out, "svg", envs, nil))
+}
+
+func TestStacksGraphSelection(t *testing.T) {
+	deps, err := depsGraph(map[string][]string{"vpc": {}, "db": {"vpc"}, "app": {"db", "vpc"}})
+	assert.Nil(t, err)
+	edges := func(labels ...string) []string {
+		names := []string{}
+		for _, e := range (&EnvStacksConfig{Deps: deps, StackLabels: labels}).StacksGraph().SortedEdges() {
+			names = append(names, e.Parent.Name+"->"+e.Child.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"db->app", "vpc->db"}, edges("vpc", "db", "app"))
+	// app depends on vpc through db too, the reduced deps only have vpc->db->app
+	assert.Equal(t, []string{"vpc->app"}, edges("vpc", "app"))
+	assert.Equal(t, []string{}, edges("app"))
+}
@@ -0,0 +1,148 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+var (
+	// resourceActions are the IAM actions CloudFormation needs to create, update and delete a resource type
+	resourceActions = map[string][]string{
+		"AWS::ApiGateway::Deployment": {"apigateway:DELETE", "apigateway:GET", "apigateway:PATCH", "apigateway:POST", "apigateway:PUT"},
+		"AWS::ApiGateway::Method":     {"apigateway:DELETE", "apigateway:GET", "apigateway:PATCH", "apigateway:POST", "apigateway:PUT"},
+		"AWS::ApiGateway::Resource":   {"apigateway:DELETE", "apigateway:GET", "apigateway:PATCH", "apigateway:POST", "apigateway:PUT"},
+		"AWS::ApiGateway::RestApi":    {"apigateway:DELETE", "apigateway:GET", "apigateway:PATCH", "apigateway:POST", "apigateway:PUT"},
+		"AWS::ApiGateway::Stage":      {"apigateway:DELETE", "apigateway:GET", "apigateway:PATCH", "apigateway:POST", "apigateway:PUT"},
+		"AWS::AutoScaling::AutoScalingGroup": {"autoscaling:CreateAutoScalingGroup", "autoscaling:CreateOrUpdateTags",
+			"autoscaling:DeleteAutoScalingGroup", "autoscaling:DeleteTags", "autoscaling:DescribeAutoScalingGroups",
+			"autoscaling:DescribeScalingActivities", "autoscaling:UpdateAutoScalingGroup"},
+		"AWS::AutoScaling::LaunchConfiguration": {"autoscaling:CreateLaunchConfiguration", "autoscaling:DeleteLaunchConfiguration",
+			"autoscaling:DescribeLaunchConfigurations", "iam:PassRole"},
+		"AWS::AutoScaling::ScalingPolicy": {"autoscaling:DeletePolicy", "autoscaling:DescribePolicies", "autoscaling:PutScalingPolicy"},
+		"AWS::CloudFormation::Stack": {"cloudformation:CreateStack", "cloudformation:DeleteStack", "cloudformation:DescribeStacks",
+			"cloudformation:UpdateStack"},
+		"AWS::CloudFormation::WaitCondition":       {},
+		"AWS::CloudFormation::WaitConditionHandle": {},
+		"AWS::CloudWatch::Alarm":                   {"cloudwatch:DeleteAlarms", "cloudwatch:DescribeAlarms", "cloudwatch:PutMetricAlarm"},
+		"AWS::DynamoDB::Table": {"dynamodb:CreateTable", "dynamodb:DeleteTable", "dynamodb:DescribeContinuousBackups",
+			"dynamodb:DescribeTable", "dynamodb:DescribeTimeToLive", "dynamodb:ListTagsOfResource", "dynamodb:TagResource",
+			"dynamodb:UntagResource", "dynamodb:UpdateContinuousBackups", "dynamodb:UpdateTable", "dynamodb:UpdateTimeToLive"},
+		"AWS::EC2::EIP": {"ec2:AllocateAddress", "ec2:AssociateAddress", "ec2:DescribeAddresses", "ec2:DisassociateAddress",
+			"ec2:ReleaseAddress"},
+		"AWS::EC2::Instance": {"ec2:CreateTags", "ec2:DeleteTags", "ec2:DescribeInstances", "ec2:ModifyInstanceAttribute",
+			"ec2:RunInstances", "ec2:StartInstances", "ec2:StopInstances", "ec2:TerminateInstances", "iam:PassRole"},
+		"AWS::EC2::InternetGateway": {"ec2:CreateInternetGateway", "ec2:CreateTags", "ec2:DeleteInternetGateway",
+			"ec2:DescribeInternetGateways"},
+		"AWS::EC2::LaunchTemplate": {"ec2:CreateLaunchTemplate", "ec2:CreateLaunchTemplateVersion", "ec2:DeleteLaunchTemplate",
+			"ec2:DescribeLaunchTemplateVersions", "ec2:DescribeLaunchTemplates", "ec2:ModifyLaunchTemplate", "iam:PassRole"},
+		"AWS::EC2::NatGateway": {"ec2:CreateNatGateway", "ec2:DeleteNatGateway", "ec2:DescribeNatGateways"},
+		"AWS::EC2::Route":      {"ec2:CreateRoute", "ec2:DeleteRoute", "ec2:DescribeRouteTables", "ec2:ReplaceRoute"},
+		"AWS::EC2::RouteTable": {"ec2:CreateRouteTable", "ec2:CreateTags", "ec2:DeleteRouteTable", "ec2:DescribeRouteTables"},
+		"AWS::EC2::SecurityGroup": {"ec2:AuthorizeSecurityGroupEgress", "ec2:AuthorizeSecurityGroupIngress", "ec2:CreateSecurityGroup",
+			"ec2:CreateTags", "ec2:DeleteSecurityGroup", "ec2:DeleteTags", "ec2:DescribeSecurityGroups",
+			"ec2:RevokeSecurityGroupEgress", "ec2:RevokeSecurityGroupIngress"},
+		"AWS::EC2::SecurityGroupEgress": {"ec2:AuthorizeSecurityGroupEgress", "ec2:DescribeSecurityGroups", "ec2:RevokeSecurityGroupEgress"},
+		"AWS::EC2::SecurityGroupIngress": {"ec2:AuthorizeSecurityGroupIngress", "ec2:DescribeSecurityGroups",
+			"ec2:RevokeSecurityGroupIngress"},
+		"AWS::EC2::Subnet": {"ec2:CreateSubnet", "ec2:CreateTags", "ec2:DeleteSubnet", "ec2:DeleteTags", "ec2:DescribeSubnets",
+			"ec2:ModifySubnetAttribute"},
+		"AWS::EC2::SubnetRouteTableAssociation": {"ec2:AssociateRouteTable", "ec2:DescribeRouteTables", "ec2:DisassociateRouteTable"},
+		"AWS::EC2::VPC": {"ec2:CreateTags", "ec2:CreateVpc", "ec2:DeleteTags", "ec2:DeleteVpc", "ec2:DescribeVpcAttribute",
+			"ec2:DescribeVpcs", "ec2:ModifyVpcAttribute"},
+		"AWS::EC2::VPCGatewayAttachment": {"ec2:AttachInternetGateway", "ec2:DescribeInternetGateways", "ec2:DetachInternetGateway"},
+		"AWS::ECS::Cluster":              {"ecs:CreateCluster", "ecs:DeleteCluster", "ecs:DescribeClusters", "ecs:TagResource", "ecs:UntagResource"},
+		"AWS::ECS::Service": {"ecs:CreateService", "ecs:DeleteService", "ecs:DescribeServices", "ecs:UpdateService",
+			"iam:PassRole"},
+		"AWS::ECS::TaskDefinition": {"ecs:DeregisterTaskDefinition", "ecs:DescribeTaskDefinition", "ecs:RegisterTaskDefinition",
+			"iam:PassRole"},
+		"AWS::ElasticLoadBalancing::LoadBalancer": {"elasticloadbalancing:AddTags", "elasticloadbalancing:ApplySecurityGroupsToLoadBalancer",
+			"elasticloadbalancing:AttachLoadBalancerToSubnets", "elasticloadbalancing:ConfigureHealthCheck",
+			"elasticloadbalancing:CreateLoadBalancer", "elasticloadbalancing:CreateLoadBalancerListeners",
+			"elasticloadbalancing:DeleteLoadBalancer", "elasticloadbalancing:DeleteLoadBalancerListeners",
+			"elasticloadbalancing:DescribeLoadBalancerAttributes", "elasticloadbalancing:DescribeLoadBalancers",
+			"elasticloadbalancing:DetachLoadBalancerFromSubnets", "elasticloadbalancing:ModifyLoadBalancerAttributes",
+			"elasticloadbalancing:RemoveTags"},
+		"AWS::ElasticLoadBalancingV2::Listener": {"elasticloadbalancing:CreateListener", "elasticloadbalancing:DeleteListener",
+			"elasticloadbalancing:DescribeListeners", "elasticloadbalancing:ModifyListener"},
+		"AWS::ElasticLoadBalancingV2::ListenerRule": {"elasticloadbalancing:CreateRule", "elasticloadbalancing:DeleteRule",
+			"elasticloadbalancing:DescribeRules", "elasticloadbalancing:ModifyRule"},
+		"AWS::ElasticLoadBalancingV2::LoadBalancer": {"elasticloadbalancing:AddTags", "elasticloadbalancing:CreateLoadBalancer",
+			"elasticloadbalancing:DeleteLoadBalancer", "elasticloadbalancing:DescribeLoadBalancerAttributes",
+			"elasticloadbalancing:DescribeLoadBalancers", "elasticloadbalancing:ModifyLoadBalancerAttributes",
+			"elasticloadbalancing:RemoveTags", "elasticloadbalancing:SetSecurityGroups", "elasticloadbalancing:SetSubnets"},
+		"AWS::ElasticLoadBalancingV2::TargetGroup": {"elasticloadbalancing:AddTags", "elasticloadbalancing:CreateTargetGroup",
+			"elasticloadbalancing:DeleteTargetGroup", "elasticloadbalancing:DescribeTargetGroupAttributes",
+			"elasticloadbalancing:DescribeTargetGroups", "elasticloadbalancing:ModifyTargetGroup",
+			"elasticloadbalancing:ModifyTargetGroupAttributes", "elasticloadbalancing:RemoveTags"},
+		"AWS::Events::Rule": {"events:DeleteRule", "events:DescribeRule", "events:PutRule", "events:PutTargets",
+			"events:RemoveTargets", "iam:PassRole"},
+		"AWS::IAM::InstanceProfile": {"iam:AddRoleToInstanceProfile", "iam:CreateInstanceProfile", "iam:DeleteInstanceProfile",
+			"iam:GetInstanceProfile", "iam:PassRole", "iam:RemoveRoleFromInstanceProfile"},
+		"AWS::IAM::ManagedPolicy": {"iam:AttachRolePolicy", "iam:CreatePolicy", "iam:CreatePolicyVersion", "iam:DeletePolicy",
+			"iam:DeletePolicyVersion", "iam:DetachRolePolicy", "iam:GetPolicy", "iam:ListPolicyVersions"},
+		"AWS::IAM::Policy": {"iam:DeleteGroupPolicy", "iam:DeleteRolePolicy", "iam:DeleteUserPolicy", "iam:PutGroupPolicy",
+			"iam:PutRolePolicy", "iam:PutUserPolicy"},
+		"AWS::IAM::Role": {"iam:AttachRolePolicy", "iam:CreateRole", "iam:DeleteRole", "iam:DeleteRolePolicy",
+			"iam:DetachRolePolicy", "iam:GetRole", "iam:GetRolePolicy", "iam:ListAttachedRolePolicies", "iam:ListRolePolicies",
+			"iam:PassRole", "iam:PutRolePolicy", "iam:TagRole", "iam:UntagRole", "iam:UpdateAssumeRolePolicy", "iam:UpdateRole"},
+		"AWS::KMS::Alias": {"kms:CreateAlias", "kms:DeleteAlias", "kms:ListAliases", "kms:UpdateAlias"},
+		"AWS::KMS::Key": {"kms:CreateKey", "kms:DescribeKey", "kms:DisableKeyRotation", "kms:EnableKeyRotation",
+			"kms:GetKeyPolicy", "kms:ListResourceTags", "kms:PutKeyPolicy", "kms:ScheduleKeyDeletion", "kms:TagResource",
+			"kms:UntagResource"},
+		"AWS::Lambda::Alias": {"lambda:CreateAlias", "lambda:DeleteAlias", "lambda:GetAlias", "lambda:UpdateAlias"},
+		"AWS::Lambda::EventSourceMapping": {"lambda:CreateEventSourceMapping", "lambda:DeleteEventSourceMapping",
+			"lambda:GetEventSourceMapping", "lambda:UpdateEventSourceMapping"},
+		"AWS::Lambda::Function": {"iam:PassRole", "lambda:CreateFunction", "lambda:DeleteFunction", "lambda:GetFunction",
+			"lambda:GetFunctionConfiguration", "lambda:ListTags", "lambda:TagResource", "lambda:UntagResource",
+			"lambda:UpdateFunctionCode", "lambda:UpdateFunctionConfiguration", "s3:GetObject"},
+		"AWS::Lambda::LayerVersion": {"lambda:DeleteLayerVersion", "lambda:GetLayerVersion", "lambda:PublishLayerVersion", "s3:GetObject"},
+		"AWS::Lambda::Permission":   {"lambda:AddPermission", "lambda:RemovePermission"},
+		"AWS::Lambda::Version":      {"lambda:GetFunctionConfiguration", "lambda:PublishVersion"},
+		"AWS::Logs::LogGroup": {"logs:CreateLogGroup", "logs:DeleteLogGroup", "logs:DeleteRetentionPolicy", "logs:DescribeLogGroups",
+			"logs:PutRetentionPolicy", "logs:TagLogGroup", "logs:UntagLogGroup"},
+		"AWS::RDS::DBInstance": {"rds:AddTagsToResource", "rds:CreateDBInstance", "rds:DeleteDBInstance", "rds:DescribeDBInstances",
+			"rds:ListTagsForResource", "rds:ModifyDBInstance", "rds:RemoveTagsFromResource"},
+		"AWS::RDS::DBParameterGroup": {"rds:CreateDBParameterGroup", "rds:DeleteDBParameterGroup", "rds:DescribeDBParameterGroups",
+			"rds:DescribeDBParameters", "rds:ModifyDBParameterGroup"},
+		"AWS::RDS::DBSubnetGroup": {"rds:CreateDBSubnetGroup", "rds:DeleteDBSubnetGroup", "rds:DescribeDBSubnetGroups",
+			"rds:ModifyDBSubnetGroup"},
+		"AWS::Route53::HostedZone": {"route53:ChangeTagsForResource", "route53:CreateHostedZone", "route53:DeleteHostedZone",
+			"route53:GetChange", "route53:GetHostedZone", "route53:ListResourceRecordSets"},
+		"AWS::Route53::RecordSet": {"route53:ChangeResourceRecordSets", "route53:GetChange", "route53:GetHostedZone",
+			"route53:ListResourceRecordSets"},
+		"AWS::S3::Bucket": {"s3:CreateBucket", "s3:DeleteBucket", "s3:GetBucketLocation", "s3:ListBucket", "s3:PutBucketAcl",
+			"s3:PutBucketCORS", "s3:PutBucketLogging", "s3:PutBucketNotification", "s3:PutBucketPublicAccessBlock",
+			"s3:PutBucketTagging", "s3:PutBucketVersioning", "s3:PutEncryptionConfiguration", "s3:PutLifecycleConfiguration"},
+		"AWS::S3::BucketPolicy": {"s3:DeleteBucketPolicy", "s3:GetBucketPolicy", "s3:PutBucketPolicy"},
+		"AWS::SecretsManager::Secret": {"secretsmanager:CreateSecret", "secretsmanager:DeleteSecret", "secretsmanager:DescribeSecret",
+			"secretsmanager:GetRandomPassword", "secretsmanager:PutSecretValue", "secretsmanager:TagResource",
+			"secretsmanager:UntagResource", "secretsmanager:UpdateSecret"},
+		"AWS::Serverless::Api": {"apigateway:DELETE", "apigateway:GET", "apigateway:PATCH", "apigateway:POST", "apigateway:PUT"},
+		"AWS::Serverless::Function": {"iam:AttachRolePolicy", "iam:CreateRole", "iam:DeleteRole", "iam:DeleteRolePolicy",
+			"iam:DetachRolePolicy", "iam:GetRole", "iam:PassRole", "iam:PutRolePolicy", "lambda:AddPermission",
+			"lambda:CreateFunction", "lambda:DeleteFunction", "lambda:GetFunction", "lambda:GetFunctionConfiguration",
+			"lambda:RemovePermission", "lambda:TagResource", "lambda:UntagResource", "lambda:UpdateFunctionCode",
+			"lambda:UpdateFunctionConfiguration", "s3:GetObject"},
+		"AWS::SNS::Subscription": {"sns:GetSubscriptionAttributes", "sns:SetSubscriptionAttributes", "sns:Subscribe", "sns:Unsubscribe"},
+		"AWS::SNS::Topic": {"sns:CreateTopic", "sns:DeleteTopic", "sns:GetTopicAttributes", "sns:ListSubscriptionsByTopic",
+			"sns:SetTopicAttributes", "sns:Subscribe", "sns:TagResource", "sns:Unsubscribe", "sns:UntagResource"},
+		"AWS::SNS::TopicPolicy": {"sns:SetTopicAttributes"},
+		"AWS::SQS::Queue": {"sqs:CreateQueue", "sqs:DeleteQueue", "sqs:GetQueueAttributes", "sqs:GetQueueUrl", "sqs:ListQueueTags",
+			"sqs:SetQueueAttributes", "sqs:TagQueue", "sqs:UntagQueue"},
+		"AWS::SQS::QueuePolicy": {"sqs:SetQueueAttributes"},
+		"AWS::SSM::Parameter": {"ssm:AddTagsToResource", "ssm:DeleteParameter", "ssm:GetParameters", "ssm:PutParameter",
+			"ssm:RemoveTagsFromResource"},
+	}
+)
@@ -0,0 +1,251 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/locks"
+	"github.com/capitalone/stack-deployment-tool/store"
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const IAMPolicyVersion = "2012-10-17"
+
+var (
+	// stackActions are the CloudFormation actions sdt uses on the stacks it deploys
+	stackActions = []string{
+		"cloudformation:CreateChangeSet",
+		"cloudformation:CreateStack",
+		"cloudformation:DeleteChangeSet",
+		"cloudformation:DeleteStack",
+		"cloudformation:DescribeChangeSet",
+		"cloudformation:DescribeStackEvents",
+		"cloudformation:DescribeStacks",
+		"cloudformation:ExecuteChangeSet",
+		"cloudformation:GetStackPolicy",
+		"cloudformation:GetTemplate",
+		"cloudformation:ListChangeSets",
+		"cloudformation:ListStackResources",
+		"cloudformation:SetStackPolicy",
+		"cloudformation:UpdateTerminationProtection",
+	}
+
+	// accountActions dont take a stack resource: outputs, exports and imports of other stacks,
+	// the outputs of provisioned products, and the cross account roles of outputs in other accounts
+	accountActions = []string{
+		"cloudformation:DescribeStacks",
+		"cloudformation:ListExports",
+		"cloudformation:ListImports",
+		"servicecatalog:DescribeRecord",
+		"servicecatalog:ScanProvisionedProducts",
+		"sts:AssumeRole",
+	}
+
+	// transformResources are the macros of templates with a Transform, their change sets are created on them too
+	transformResources = []string{"arn:aws:cloudformation:*:aws:transform/*"}
+)
+
+// IAMPolicy is an IAM policy document
+type IAMPolicy struct {
+	Version   string
+	Statement []*IAMStatement
+}
+
+type IAMStatement struct {
+	Sid      string `json:",omitempty"`
+	Effect   string
+	Action   []string
+	Resource []string
+}
+
+func allow(sid string, actions []string, resources ...string) *IAMStatement {
+	return &IAMStatement{Sid: sid, Effect: "Allow", Action: actions, Resource: resources}
+}
+
+// StacksIAMPolicy is the policy needed to deploy the stacks: the CloudFormation, S3 and EC2 actions sdt uses,
+// and the actions to create, update and delete the resources of their templates
+func StacksIAMPolicy(envStacks *EnvStacksConfig) (*IAMPolicy, error) {
+	stackArns := map[string]bool{}
+	types := map[string]bool{}
+	emptyBuckets, transformed := false, false
+	for _, stackLabel := range envStacks.StackLabels {
+		stack := envStacks.Stack(stackLabel)
+		stackArns[fmt.Sprintf("arn:aws:cloudformation:*:*:stack/%s/*", stack.Name())] = true
+		emptyBuckets = emptyBuckets || isTrue(stack.Fetch("empty_buckets"))
+		templateFile := findTemplateFile(stack.TemplatePaths()...)
+		hasTransform, err := templateResourceTypes(templateFile, loadTemplate(stack.TemplatePaths()...), types)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the template of: %s %v", stack.Name(), err)
+		}
+		transformed = transformed || hasTransform
+	}
+
+	policy := &IAMPolicy{Version: IAMPolicyVersion}
+	policy.Statement = append(policy.Statement,
+		allow("SdtStacks", stackActions, setKeys(stackArns)...),
+		allow("SdtAccount", accountActions, "*"),
+		allow("SdtImages", []string{"ec2:DescribeImages"}, "*"))
+	if transformed {
+		policy.Statement = append(policy.Statement,
+			allow("SdtTransforms", []string{"cloudformation:CreateChangeSet"}, transformResources...))
+	}
+
+	stores, err := storeStatements(envStacks.Config)
+	if err != nil {
+		return nil, err
+	}
+	policy.Statement = append(policy.Statement, stores...)
+	if emptyBuckets {
+		// the buckets of the stacks are only known once they are deployed
+		policy.Statement = append(policy.Statement, allow("SdtEmptyBuckets",
+			[]string{"s3:DeleteObject", "s3:DeleteObjectVersion", "s3:ListBucket", "s3:ListBucketVersions"}, "*"))
+	}
+
+	actions, unknown := resourceTypesActions(setKeys(types))
+	if len(unknown) > 0 {
+		log.Warnf("No actions for the resource types: %s, add them to the policy", strings.Join(unknown, ", "))
+	}
+	if len(actions) > 0 {
+		policy.Statement = append(policy.Statement, allow("StackResources", actions, "*"))
+	}
+	return policy, nil
+}
+
+// templateResourceTypes adds the resource types of the template, and of its local nested stack templates, to types.
+// It is true when one of the templates has a Transform.
+func templateResourceTypes(templateFile string, template string, types map[string]bool) (bool, error) {
+	doc, err := decodeTemplate(template)
+	if err != nil {
+		return false, err
+	}
+	transformed := doc["Transform"] != nil
+	for _, res := range utils.ToStrMap(doc["Resources"]) {
+		if t, ok := utils.ToStrMap(res)["Type"].(string); ok {
+			types[t] = true
+		}
+	}
+	dir := filepath.Dir(templateFile)
+	for _, ref := range findLocalRefs(template, dir) {
+		if ref.Type != "AWS::CloudFormation::Stack" {
+			continue
+		}
+		path := localPath(ref.Path, dir)
+		nestedTransform, err := templateResourceTypes(path, loadTemplate(path), types)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", path, err)
+		}
+		transformed = transformed || nestedTransform
+	}
+	return transformed, nil
+}
+
+// resourceTypesActions are the sorted actions of the resource types, and the sorted types without actions
+func resourceTypesActions(types []string) ([]string, []string) {
+	actions := map[string]bool{}
+	unknown := []string{}
+	for _, t := range types {
+		typeActions, ok := resourceTypeActions(t)
+		if !ok {
+			unknown = append(unknown, t)
+		}
+		for _, action := range typeActions {
+			actions[action] = true
+		}
+	}
+	return setKeys(actions), unknown
+}
+
+// resourceTypeActions are the actions of the resource type, false when it is not known
+func resourceTypeActions(resourceType string) ([]string, bool) {
+	if actions, ok := resourceActions[resourceType]; ok {
+		return actions, true
+	}
+	// custom resources are lambda functions (or sns topics) invoked by CloudFormation
+	if strings.HasPrefix(resourceType, "Custom::") || resourceType == "AWS::CloudFormation::CustomResource" {
+		return []string{"lambda:InvokeFunction", "sns:Publish"}, true
+	}
+	return nil, false
+}
+
+// storeStatements allow the template bucket, the S3 prefixes of the deploy history, audit log and locks,
+// and the DynamoDB table of the locks
+func storeStatements(c *StacksConfig) ([]*IAMStatement, error) {
+	prefixes := map[string][]string{} // bucket -> key prefixes
+	bucket, err := c.TemplateBucket()
+	if err != nil {
+		return nil, err
+	}
+	if bucket != nil {
+		prefixes[bucket.Bucket] = append(prefixes[bucket.Bucket], strings.SplitN(bucket.Key, "{{", 2)[0], artifactPrefix)
+	}
+
+	statements := []*IAMStatement{}
+	for _, get := range []func() (store.Store, error){c.SnapshotStore, c.AuditStore} {
+		st, err := get()
+		if err != nil {
+			return nil, err
+		}
+		if s3st, ok := st.(*store.S3Store); ok {
+			prefixes[s3st.Bucket] = append(prefixes[s3st.Bucket], s3st.Prefix)
+		}
+	}
+	backend, err := c.LockBackend()
+	if err != nil {
+		return nil, err
+	}
+	switch b := backend.(type) {
+	case *locks.S3Backend:
+		prefixes[b.Bucket] = append(prefixes[b.Bucket], b.Prefix)
+	case *locks.DynamoDBBackend:
+		statements = append(statements, allow("SdtLocks",
+			[]string{"dynamodb:DeleteItem", "dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:Scan"},
+			fmt.Sprintf("arn:aws:dynamodb:*:*:table/%s", b.Table)))
+	}
+	if len(prefixes) == 0 {
+		return statements, nil
+	}
+
+	buckets := map[string]bool{}
+	objects := map[string]bool{}
+	for b, keyPrefixes := range prefixes {
+		buckets["arn:aws:s3:::"+b] = true
+		for _, p := range keyPrefixes {
+			objects[fmt.Sprintf("arn:aws:s3:::%s/%s*", b, p)] = true
+		}
+	}
+	return append([]*IAMStatement{
+		allow("SdtBuckets", []string{"s3:ListBucket", "s3:ListBucketVersions"}, setKeys(buckets)...),
+		allow("SdtObjects", []string{"s3:DeleteObject", "s3:GetObject", "s3:PutObject"}, setKeys(objects)...),
+	}, statements...), nil
+}
+
+// setKeys are the sorted keys of the set
+func setKeys(set map[string]bool) []string {
+	keys := []string{}
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,85 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceTypeActions(t *testing.T) {
+	actions, ok := resourceTypeActions("AWS::S3::Bucket")
+	assert.True(t, ok)
+	assert.Contains(t, actions, "s3:CreateBucket")
+	actions, ok = resourceTypeActions("Custom::AmiLookup")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"lambda:InvokeFunction", "sns:Publish"}, actions)
+	actions, ok = resourceTypeActions("AWS::Glue::Job")
+	assert.False(t, ok)
+	assert.Empty(t, actions)
+
+	actions, unknown := resourceTypesActions([]string{"AWS::Glue::Job", "AWS::SQS::Queue", "AWS::SQS::QueuePolicy", "Vendor::Thing"})
+	assert.Equal(t, 8, len(actions))
+	assert.Equal(t, "sqs:CreateQueue", actions[0])
+	assert.Equal(t, []string{"AWS::Glue::Job", "Vendor::Thing"}, unknown)
+}
+
+func TestTemplateResourceTypes(t *testing.T) {
+	dir := writePackageTestFiles(t)
+	defer os.RemoveAll(dir)
+
+	types := map[string]bool{}
+	transformed, err := templateResourceTypes(filepath.Join(dir, "template.yml"), packageTestTemplate, types)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"AWS::CloudFormation::Stack", "AWS::Lambda::Function", "AWS::Serverless::Function"}, setKeys(types))
+	assert.False(t, transformed)
+	transformed, err = templateResourceTypes(filepath.Join(dir, "template.yml"), "Transform: AWS::Serverless-2016-10-31\n"+packageTestTemplate, types)
+	assert.Nil(t, err)
+	assert.True(t, transformed)
+
+	_, err = templateResourceTypes(filepath.Join(dir, "template.yml"), "Resources: [", types)
+	assert.NotNil(t, err)
+}
+
+func TestStacksIAMPolicy(t *testing.T) {
+	c := NewConfig(ResourcePath("bluegreen/stack_blue.yaml"), nil)
+	c.Yaml["template_bucket"] = "my-templates"
+	c.Yaml["deploy_history"] = map[string]interface{}{"store": "s3://my-state/sdt/snapshots"}
+	c.Yaml["locks"] = map[string]interface{}{"store": "dynamodb://sdt-locks"}
+
+	policy, err := StacksIAMPolicy(c.FetchEnvStacks("dev-techops.blue"))
+	assert.Nil(t, err)
+	assert.Equal(t, IAMPolicyVersion, policy.Version)
+
+	sids := []string{}
+	for _, s := range policy.Statement {
+		sids = append(sids, s.Sid)
+	}
+	assert.Equal(t, []string{"SdtStacks", "SdtAccount", "SdtImages", "SdtBuckets", "SdtObjects", "SdtLocks", "StackResources"}, sids)
+	assert.Equal(t, []string{"arn:aws:cloudformation:*:*:stack/blue/*"}, policy.Statement[0].Resource)
+	assert.Contains(t, policy.Statement[1].Action, "sts:AssumeRole")
+	assert.Equal(t, []string{"arn:aws:s3:::my-state", "arn:aws:s3:::my-templates"}, policy.Statement[3].Resource)
+	assert.Equal(t, []string{"arn:aws:s3:::my-state/sdt/snapshots*", "arn:aws:s3:::my-templates/sdt/artifacts/*",
+		"arn:aws:s3:::my-templates/sdt/templates/*"}, policy.Statement[4].Resource)
+	assert.Equal(t, []string{"arn:aws:dynamodb:*:*:table/sdt-locks"}, policy.Statement[5].Resource)
+	assert.Contains(t, policy.Statement[6].Action, "autoscaling:CreateAutoScalingGroup")
+	assert.Equal(t, []string{"*"}, policy.Statement[6].Resource)
+}
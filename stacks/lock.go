@@ -0,0 +1,118 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/capitalone/stack-deployment-tool/locks"
+
+	log "github.com/Sirupsen/logrus"
+	jsonptr "github.com/dustin/go-jsonpointer"
+)
+
+const DefaultLockTTL = time.Hour
+
+// LockBackend is where the environment locks are kept, the locks store of the stacks yaml:
+// s3://bucket/prefix, dynamodb://table or a directory, by default locks in the state dir.
+// Without a locks block environments are not locked.
+func (c *StacksConfig) LockBackend() (locks.Backend, error) {
+	if jsonptr.Get(c.Yaml, "/locks") == nil {
+		return nil, nil
+	}
+	location, _ := c.ProcessValue(jsonptr.Get(c.Yaml, "/locks/store")).(string)
+	if len(location) == 0 {
+		location = filepath.Join(c.StateDir(), "locks")
+	}
+	return locks.New(location, filepath.Dir(c.FileName))
+}
+
+// LockTTL is how long a lock is held before others may take it over, the locks ttl of the stacks yaml
+func (c *StacksConfig) LockTTL() (time.Duration, error) {
+	ttl, _ := c.ProcessValue(jsonptr.Get(c.Yaml, "/locks/ttl")).(string)
+	if len(ttl) == 0 {
+		return DefaultLockTTL, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil {
+		return 0, fmt.Errorf("invalid locks ttl: %s %v", ttl, err)
+	}
+	return d, nil
+}
+
+// Envs are the environments of the selected stacks, sorted, --with-deps may select stacks of other environments
+func (e *EnvStacksConfig) Envs() []string {
+	envs := map[string]bool{e.Env: true}
+	for _, label := range e.StackLabels {
+		if stack := e.Stack(label); stack != nil {
+			envs[stack.Env()] = true
+		}
+	}
+	return setKeys(envs)
+}
+
+// lockEnv takes the locks on the environments of the stacks, in sorted order so two runs dont deadlock,
+// and renews them while held. The returned func releases them.
+func (a *AWSStackApi) lockEnv(envStacks *EnvStacksConfig) (unlock func()) {
+	noop := func() {}
+	if a.IsDryMode() {
+		return noop
+	}
+	backend, err := envStacks.Config.LockBackend()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if backend == nil {
+		return noop
+	}
+	ttl, err := envStacks.Config.LockTTL()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	held := []*locks.Lock{}
+	stops := []func(){}
+	release := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			stops[i]()
+			if err := backend.Release(held[i]); err != nil {
+				log.Errorf("Error releasing lock: %s %v", held[i].Key, err)
+			}
+		}
+	}
+	// log.Fatal exits without returning, dont leave the locks behind
+	removeOnFatal := onFatal(func(string) { release() })
+
+	for _, env := range envStacks.Envs() {
+		lock := locks.NewLock(env, ttl)
+		if owner := a.CallerArn(); len(owner) > 0 {
+			lock.Owner = owner
+		}
+		if err := locks.Acquire(backend, lock, a.options.WaitForLock); err != nil {
+			log.Fatalf("%v", err)
+		}
+		held = append(held, lock)
+		stops = append(stops, locks.Keep(backend, lock))
+	}
+	return func() {
+		removeOnFatal()
+		release()
+	}
+}
@@ -0,0 +1,63 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockBackend(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	backend, err := c.LockBackend()
+	assert.Nil(t, err)
+	assert.Nil(t, backend)
+
+	viper.Set("StateDir", "/tmp/sdt-state")
+	defer viper.Set("StateDir", "")
+	c.Yaml["locks"] = map[string]interface{}{}
+	backend, err = c.LockBackend()
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join("/tmp/sdt-state", "locks"), backend.String())
+	ttl, err := c.LockTTL()
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultLockTTL, ttl)
+
+	c.Yaml["locks"] = map[string]interface{}{"store": "s3://lock-bucket/sdt", "ttl": "90m"}
+	backend, err = c.LockBackend()
+	assert.Nil(t, err)
+	assert.Equal(t, "s3://lock-bucket/sdt", backend.String())
+	ttl, err = c.LockTTL()
+	assert.Nil(t, err)
+	assert.Equal(t, 90*time.Minute, ttl)
+
+	c.Yaml["locks"] = map[string]interface{}{"ttl": "an hour"}
+	_, err = c.LockTTL()
+	assert.NotNil(t, err)
+}
+
+func TestEnvs(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	assert.Equal(t, []string{"qa4"}, c.FetchEnvStacks("qa4.nagios-elb").Envs())
+	// locked in sorted order, whichever env is selected
+	assert.Equal(t, []string{"qa4", "shared"}, c.FetchEnvStacksSelection("qa4.nagios-elb", &StackSelection{WithDeps: true}).Envs())
+}
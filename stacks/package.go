@@ -0,0 +1,372 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// packaged artifacts are uploaded to the template bucket under this prefix, named by their content hash
+	artifactPrefix = "sdt/artifacts/"
+)
+
+var (
+	// packageProperties are the resource properties that can point at a local file or directory
+	packageProperties = map[string]string{
+		"AWS::CloudFormation::Stack":    "TemplateURL",
+		"AWS::Lambda::Function":         "Code",
+		"AWS::Lambda::LayerVersion":     "Content",
+		"AWS::Serverless::Function":     "CodeUri",
+		"AWS::Serverless::LayerVersion": "ContentUri",
+	}
+
+	// zip entries get a fixed time, so the same files always give the same content hash
+	zipModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// localRef is a resource property of a template pointing at a local file or directory
+type localRef struct {
+	Resource string // logical id
+	Type     string
+	Property string
+	Path     string // as written in the template, relative to the template
+}
+
+// artifact is a packaged local reference
+type artifact struct {
+	Key  string
+	Body []byte
+}
+
+// findLocalRefs are the packageable resource properties of the template pointing at existing local paths,
+// other values (s3 or https urls, intrinsic functions) are left alone
+func findLocalRefs(template string, dir string) []*localRef {
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(template), &doc); err != nil {
+		log.Debugf("Not packaging, error parsing the template: %v", err)
+		return nil
+	}
+	resources := utils.ToStrMap(utils.DeepToStrMap(doc)["Resources"])
+	ids := []string{}
+	for id := range resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	refs := []*localRef{}
+	for _, id := range ids {
+		resource, ok := resources[id].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		resourceType, _ := resource["Type"].(string)
+		property, ok := packageProperties[resourceType]
+		if !ok {
+			continue
+		}
+		path, ok := utils.ToStrMap(resource["Properties"])[property].(string)
+		if !ok || !isLocalPath(path, dir) {
+			continue
+		}
+		refs = append(refs, &localRef{Resource: id, Type: resourceType, Property: property, Path: path})
+	}
+	return refs
+}
+
+func isLocalPath(path string, dir string) bool {
+	if len(path) == 0 || strings.Contains(path, "://") {
+		return false
+	}
+	return utils.FileExists(localPath(path, dir))
+}
+
+func localPath(path string, dir string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// artifactKey is the content addressed key of an artifact
+func artifactKey(body []byte, ext string) string {
+	return fmt.Sprintf("%s%x%s", artifactPrefix, sha256.Sum256(body), ext)
+}
+
+// zipPath zips a file, or the files in a directory relative to it, reproducibly
+func zipPath(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	root := path
+	if !info.IsDir() {
+		root = filepath.Dir(path)
+	}
+	files := []string{}
+	err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, p := range files {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		header, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return nil, err
+		}
+		name, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil, err
+		}
+		header.Name = filepath.ToSlash(name)
+		header.Method = zip.Deflate
+		header.Modified = zipModTime
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = fw.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// packagedTemplate is the template of the stack with its local references packaged, see packageTemplate
+func (a *AWSStackApi) packagedTemplate(stack *StackConfig) string {
+	template := loadTemplate(stack.TemplatePaths()...)
+	template, err := a.packageTemplate(findTemplateFile(stack.TemplatePaths()...), template)
+	if err != nil {
+		log.Fatalf("Error packaging the template of: %s %v", stack.Name(), err)
+	}
+	return template
+}
+
+// previewTemplate is the packaged template of the stack without uploading the artifacts, to show it rather than deploy it
+func (a *AWSStackApi) previewTemplate(stack *StackConfig) string {
+	dryMode := a.IsDryMode()
+	a.DryMode(true)
+	defer a.DryMode(dryMode)
+	return a.packagedTemplate(stack)
+}
+
+// packageTemplate uploads the local files and directories the template references to the template bucket,
+// and points the references at them. Nested stack templates are rendered and packaged too.
+func (a *AWSStackApi) packageTemplate(templateFile string, template string) (string, error) {
+	dir := filepath.Dir(templateFile)
+	refs := findLocalRefs(template, dir)
+	if len(refs) == 0 {
+		return template, nil
+	}
+	if a.templateBucket == nil {
+		return "", fmt.Errorf("%s references local paths, configure a template_bucket to package them", templateFile)
+	}
+
+	values := map[*localRef]interface{}{}
+	for _, ref := range refs {
+		art, err := a.packageRef(ref, dir)
+		if err != nil {
+			return "", err
+		}
+		if err = a.uploadArtifact(art); err != nil {
+			return "", err
+		}
+		values[ref] = a.artifactValue(ref, art)
+	}
+	return rewriteRefs(template, refs, values)
+}
+
+// packageRef zips the local path of the reference, or renders and packages a nested stack template
+func (a *AWSStackApi) packageRef(ref *localRef, dir string) (*artifact, error) {
+	path := localPath(ref.Path, dir)
+	log.Infof("Packaging %s %s: %s", ref.Resource, ref.Property, path)
+	if ref.Type == "AWS::CloudFormation::Stack" {
+		nested, err := a.packageTemplate(path, loadTemplate(path))
+		if err != nil {
+			return nil, err
+		}
+		if len(nested) == 0 {
+			return nil, fmt.Errorf("empty nested template: %s", path)
+		}
+		return &artifact{Key: artifactKey([]byte(nested), ".template"), Body: []byte(nested)}, nil
+	}
+
+	var body []byte
+	var err error
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".zip" || ext == ".jar" {
+		body, err = ioutil.ReadFile(path)
+	} else {
+		body, err = zipPath(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error packaging %s %s: %v", ref.Resource, ref.Property, err)
+	}
+	return &artifact{Key: artifactKey(body, ".zip"), Body: body}, nil
+}
+
+// uploadArtifact uploads the artifact to the template bucket, unless it is already there
+func (a *AWSStackApi) uploadArtifact(art *artifact) error {
+	bucket := a.templateBucket.Bucket
+	if a.IsDryMode() {
+		log.Infof("Would upload: s3://%s/%s (%d bytes)", bucket, art.Key, len(art.Body))
+		return nil
+	}
+	_, err := a.S3Service().HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(art.Key)})
+	if err == nil {
+		log.Debugf("Already uploaded: s3://%s/%s", bucket, art.Key)
+		return nil
+	}
+	log.Infof("Uploading: s3://%s/%s (%d bytes)", bucket, art.Key, len(art.Body))
+	_, err = a.S3Service().PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(art.Key),
+		Body:                 bytes.NewReader(art.Body),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+	if err != nil {
+		return fmt.Errorf("Error uploading: s3://%s/%s %v", bucket, art.Key, err)
+	}
+	return nil
+}
+
+// artifactValue is the property value pointing at the uploaded artifact
+func (a *AWSStackApi) artifactValue(ref *localRef, art *artifact) interface{} {
+	bucket := a.templateBucket.Bucket
+	switch ref.Property {
+	case "TemplateURL":
+		return templateURL(aws.StringValue(a.Session.Config.Region), bucket, art.Key)
+	case "Code", "Content":
+		return map[string]interface{}{"S3Bucket": bucket, "S3Key": art.Key}
+	}
+	return fmt.Sprintf("s3://%s/%s", bucket, art.Key)
+}
+
+// rewriteRefs replaces the local references of the template with their values. JSON templates are rewritten
+// as documents, yaml ones line by line to keep the short form intrinsic functions (!Ref, !Sub ...)
+func rewriteRefs(template string, refs []*localRef, values map[*localRef]interface{}) (string, error) {
+	if strings.HasPrefix(strings.TrimSpace(template), "{") {
+		doc := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(template), &doc); err != nil {
+			return "", err
+		}
+		resources := utils.ToStrMap(doc["Resources"])
+		for _, ref := range refs {
+			properties := utils.ToStrMap(utils.ToStrMap(resources[ref.Resource])["Properties"])
+			properties[ref.Property] = values[ref]
+		}
+		return string(utils.EncodeJSON(doc)), nil
+	}
+
+	// a line is rewritten for every resource with the same property and path, rewrite each of them once
+	rewritten := map[string]bool{}
+	for _, ref := range refs {
+		if rewritten[ref.Property+":"+ref.Path] {
+			continue
+		}
+		rewritten[ref.Property+":"+ref.Path] = true
+		re := regexp.MustCompile(`(?m)^([ \t]*(?:- )?` + regexp.QuoteMeta(ref.Property) + `:[ \t]*)["']?` +
+			regexp.QuoteMeta(ref.Path) + `["']?([ \t]*(?:#.*)?)$`)
+		if !re.MatchString(template) {
+			return "", fmt.Errorf("could not rewrite %s %s: %s, keep it on the same line as the property",
+				ref.Resource, ref.Property, ref.Path)
+		}
+		value := strings.Replace(yamlInline(values[ref]), "$", "$$", -1)
+		template = re.ReplaceAllString(template, "${1}"+value+"${2}")
+	}
+	return template, nil
+}
+
+// yamlInline is a string or a string map as an inline yaml value
+func yamlInline(value interface{}) string {
+	quote := func(s string) string { return "'" + strings.Replace(s, "'", "''", -1) + "'" }
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return quote(fmt.Sprint(value))
+	}
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		pairs = append(pairs, k+": "+quote(fmt.Sprint(m[k])))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// PackageStacks prints the packaged templates of the stacks, or writes them to <stack name>.template files in outDir
+func (a *AWSStackApi) PackageStacks(envStacks *EnvStacksConfig, outDir string) {
+	a.useTemplateBucket(envStacks.Config)
+	for _, stackLabel := range envStacks.StackLabels {
+		stack := envStacks.Stack(stackLabel)
+		template := a.packagedTemplate(stack)
+		if len(outDir) == 0 {
+			fmt.Printf("# %s\n%s\n", stack.Name(), template)
+			continue
+		}
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			log.Fatalf("%v", err)
+		}
+		p := filepath.Join(outDir, stack.Name()+".template")
+		if err := ioutil.WriteFile(p, []byte(template), 0644); err != nil {
+			log.Fatalf("Error writing: %s %v", p, err)
+		}
+		log.Infof("Wrote: %s", p)
+	}
+}
@@ -0,0 +1,175 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+)
+
+const packageTestTemplate = `Resources:
+  Fn:
+    Type: AWS::Lambda::Function
+    Properties:
+      Code: src # the handler
+      Role: !GetAtt Role.Arn
+  Api:
+    Type: AWS::Serverless::Function
+    Properties:
+      CodeUri: './src'
+  Nested:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: nested/stack.yml
+  Remote:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: https://s3.amazonaws.com/b/k
+  Param:
+    Type: AWS::Lambda::Function
+    Properties:
+      Code: !Ref CodeParam
+`
+
+func writePackageTestFiles(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "package")
+	assert.Nil(t, err)
+	files := map[string]string{
+		"template.yml":       packageTestTemplate,
+		"src/index.js":       "exports.handler = () => {}",
+		"src/lib/util.js":    "module.exports = {}",
+		"nested/stack.yml":   "Resources:\n  Fn:\n    Type: AWS::Lambda::Function\n    Properties:\n      Code: ../src\n",
+		"nested/unused.json": "{}",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.Nil(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestFindLocalRefs(t *testing.T) {
+	dir := writePackageTestFiles(t)
+	defer os.RemoveAll(dir)
+
+	refs := findLocalRefs(packageTestTemplate, dir)
+	assert.Equal(t, []*localRef{
+		{Resource: "Api", Type: "AWS::Serverless::Function", Property: "CodeUri", Path: "./src"},
+		{Resource: "Fn", Type: "AWS::Lambda::Function", Property: "Code", Path: "src"},
+		{Resource: "Nested", Type: "AWS::CloudFormation::Stack", Property: "TemplateURL", Path: "nested/stack.yml"},
+	}, refs)
+	assert.Empty(t, findLocalRefs(packageTestTemplate, os.TempDir()))
+	assert.Empty(t, findLocalRefs("not: [a template", dir))
+}
+
+func TestZipPath(t *testing.T) {
+	dir := writePackageTestFiles(t)
+	defer os.RemoveAll(dir)
+
+	b, err := zipPath(filepath.Join(dir, "src"))
+	assert.Nil(t, err)
+	again, err := zipPath(filepath.Join(dir, "src"))
+	assert.Nil(t, err)
+	assert.Equal(t, artifactKey(b, ".zip"), artifactKey(again, ".zip"))
+
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	assert.Nil(t, err)
+	names := []string{}
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"index.js", "lib/util.js"}, names)
+
+	b, err = zipPath(filepath.Join(dir, "src", "index.js"))
+	assert.Nil(t, err)
+	r, err = zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	assert.Nil(t, err)
+	assert.Equal(t, "index.js", r.File[0].Name)
+}
+
+func TestPackageTemplate(t *testing.T) {
+	dir := writePackageTestFiles(t)
+	defer os.RemoveAll(dir)
+
+	a := &AWSStackApi{}
+	a.Session = session.New(aws.NewConfig().WithRegion("us-east-1"))
+	a.DryMode(true)
+	_, err := a.packageTemplate(filepath.Join(dir, "template.yml"), packageTestTemplate)
+	assert.NotNil(t, err)
+
+	a.templateBucket = &TemplateBucket{Bucket: "my-templates"}
+	packaged, err := a.packageTemplate(filepath.Join(dir, "template.yml"), packageTestTemplate)
+	assert.Nil(t, err)
+	assert.Contains(t, packaged, "      Code: {S3Bucket: 'my-templates', S3Key: 'sdt/artifacts/")
+	assert.Contains(t, packaged, ".zip'} # the handler\n")
+	assert.Contains(t, packaged, "      CodeUri: 's3://my-templates/sdt/artifacts/")
+	assert.Contains(t, packaged, "      TemplateURL: 'https://s3.amazonaws.com/my-templates/sdt/artifacts/")
+	assert.Contains(t, packaged, "      Role: !GetAtt Role.Arn\n")
+	assert.Contains(t, packaged, "      TemplateURL: https://s3.amazonaws.com/b/k\n")
+	assert.Contains(t, packaged, "      Code: !Ref CodeParam\n")
+	assert.Empty(t, findLocalRefs(packaged, dir))
+
+	again, err := a.packageTemplate(filepath.Join(dir, "template.yml"), packageTestTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, packaged, again)
+
+	unchanged := "Resources:\n  Fn:\n    Type: AWS::Lambda::Function\n    Properties:\n      Code: !Ref CodeParam\n"
+	packaged, err = (&AWSStackApi{}).packageTemplate(filepath.Join(dir, "template.yml"), unchanged)
+	assert.Nil(t, err)
+	assert.Equal(t, unchanged, packaged)
+}
+
+func TestRewriteRefsJSON(t *testing.T) {
+	template := `{"Resources": {"Fn": {"Type": "AWS::Lambda::Function", "Properties": {"Code": "src", "Handler": "index.handler"}}}}`
+	ref := &localRef{Resource: "Fn", Type: "AWS::Lambda::Function", Property: "Code", Path: "src"}
+	packaged, err := rewriteRefs(template, []*localRef{ref}, map[*localRef]interface{}{
+		ref: map[string]interface{}{"S3Bucket": "b", "S3Key": "k.zip"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"Resources":{"Fn":{"Properties":{"Code":{"S3Bucket":"b","S3Key":"k.zip"},"Handler":"index.handler"},"Type":"AWS::Lambda::Function"}}}`, packaged)
+
+	_, err = rewriteRefs("Resources:\n  Fn:\n    Properties:\n      Code:\n        src\n", []*localRef{ref},
+		map[*localRef]interface{}{ref: "s3://b/k"})
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "Fn Code"))
+}
+
+func TestRewriteRefsSamePath(t *testing.T) {
+	template := "Resources:\n  A:\n    Type: AWS::Serverless::Function\n    Properties:\n      CodeUri: ./src\n" +
+		"  B:\n    Type: AWS::Serverless::Function\n    Properties:\n      CodeUri: ./src\n"
+	a := &localRef{Resource: "A", Type: "AWS::Serverless::Function", Property: "CodeUri", Path: "./src"}
+	b := &localRef{Resource: "B", Type: "AWS::Serverless::Function", Property: "CodeUri", Path: "./src"}
+	packaged, err := rewriteRefs(template, []*localRef{a, b}, map[*localRef]interface{}{a: "s3://b/k.zip", b: "s3://b/k.zip"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(packaged, "      CodeUri: 's3://b/k.zip'\n"))
+}
+
+func TestYamlInline(t *testing.T) {
+	assert.Equal(t, "'s3://b/it''s'", yamlInline("s3://b/it's"))
+	assert.Equal(t, "{S3Bucket: 'b', S3Key: 'k'}", yamlInline(map[string]interface{}{"S3Key": "k", "S3Bucket": "b"}))
+}
@@ -0,0 +1,135 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// checkParameters are the problems of the parameters of a stack against the Parameters of its template:
+// parameters not declared, missing required ones, and values not allowed by the constraints.
+// With skipEmpty, empty values (e.g. outputs rendered offline) are not checked against the constraints.
+func checkParameters(template string, params map[string]interface{}, skipEmpty bool) []string {
+	doc, err := decodeTemplate(template)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid template: %v", err)}
+	}
+	declared := utils.ToStrMap(doc["Parameters"])
+	problems := []string{}
+	for _, name := range mapKeys(params) {
+		if _, ok := declared[name]; !ok {
+			problems = append(problems, fmt.Sprintf("Parameters/%s: not declared by the template", name))
+		}
+	}
+	for _, name := range mapKeys(declared) {
+		decl := utils.ToStrMap(declared[name])
+		value, passed := params[name]
+		if !passed {
+			if _, hasDefault := decl["Default"]; !hasDefault {
+				problems = append(problems, fmt.Sprintf("Parameters/%s: missing, it has no Default", name))
+			}
+			continue
+		}
+		str := fmt.Sprint(value)
+		if skipEmpty && len(str) == 0 {
+			continue
+		}
+		for _, problem := range checkParameterValue(decl, str) {
+			problems = append(problems, fmt.Sprintf("Parameters/%s: %s", name, problem))
+		}
+	}
+	return problems
+}
+
+// checkParameterValue checks a value against the constraints of its parameter declaration,
+// list values are checked item by item
+func checkParameterValue(decl map[string]interface{}, value string) []string {
+	paramType, _ := decl["Type"].(string)
+	items := []string{value}
+	if paramType == "CommaDelimitedList" || strings.HasPrefix(paramType, "List<") {
+		items = strings.Split(value, ",")
+	}
+	problems := []string{}
+	for _, item := range items {
+		if allowed, ok := decl["AllowedValues"].([]interface{}); ok && !containsValue(allowed, item) {
+			problems = append(problems, fmt.Sprintf("%q is not one of the AllowedValues: %v", item, allowed))
+		}
+		if paramType == "Number" || paramType == "List<Number>" {
+			problems = append(problems, checkNumber(decl, item)...)
+		}
+	}
+	if paramType != "String" {
+		return problems
+	}
+	if pattern, ok := decl["AllowedPattern"].(string); ok {
+		// CloudFormation patterns are java regexes, the ones go doesnt support (e.g. lookaheads) are not checked
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			log.Warnf("Not checking the AllowedPattern: %s %v", pattern, err)
+		} else if !re.MatchString(value) {
+			problems = append(problems, fmt.Sprintf("%q does not match the AllowedPattern: %s", value, pattern))
+		}
+	}
+	if min, ok := constraint(decl, "MinLength"); ok && float64(len(value)) < min {
+		problems = append(problems, fmt.Sprintf("%q is shorter than the MinLength: %v", value, min))
+	}
+	if max, ok := constraint(decl, "MaxLength"); ok && float64(len(value)) > max {
+		problems = append(problems, fmt.Sprintf("%q is longer than the MaxLength: %v", value, max))
+	}
+	return problems
+}
+
+func checkNumber(decl map[string]interface{}, item string) []string {
+	n, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+	if err != nil {
+		return []string{fmt.Sprintf("%q is not a Number", item)}
+	}
+	if min, ok := constraint(decl, "MinValue"); ok && n < min {
+		return []string{fmt.Sprintf("%v is less than the MinValue: %v", item, min)}
+	}
+	if max, ok := constraint(decl, "MaxValue"); ok && n > max {
+		return []string{fmt.Sprintf("%v is more than the MaxValue: %v", item, max)}
+	}
+	return nil
+}
+
+// constraint is a numeric constraint of a parameter declaration, templates may quote them
+func constraint(decl map[string]interface{}, name string) (float64, bool) {
+	val, ok := decl[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(fmt.Sprint(val), 64)
+	return n, err == nil
+}
+
+func containsValue(values []interface{}, value string) bool {
+	for _, v := range values {
+		if fmt.Sprint(v) == value {
+			return true
+		}
+	}
+	return false
+}
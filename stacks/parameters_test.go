@@ -0,0 +1,88 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const parametersTestTemplate = `Parameters:
+  Env:
+    Type: String
+    AllowedValues: [dev, qa, prod]
+  Name:
+    Type: String
+    AllowedPattern: '[a-z][a-z0-9-]*'
+    MinLength: 3
+    MaxLength: 8
+  Password:
+    Type: String
+    AllowedPattern: '(?=.*[A-Z])(?=.*[0-9]).{8,}'
+    Default: ''
+  Count:
+    Type: Number
+    MinValue: 1
+    MaxValue: '10'
+    Default: 2
+  Ports:
+    Type: List<Number>
+    MaxValue: 65535
+    Default: '80'
+  Subnets:
+    Type: CommaDelimitedList
+    AllowedValues: [a, b, c]
+    Default: a
+Resources:
+  Queue:
+    Type: AWS::SQS::Queue
+`
+
+func TestCheckParameters(t *testing.T) {
+	assert.Empty(t, checkParameters(parametersTestTemplate, map[string]interface{}{
+		"Env": "qa", "Name": "my-app", "Count": "10", "Ports": "80,443", "Subnets": "a,c",
+	}, false))
+	// java regex lookaheads dont compile in go, the pattern isnt checked
+	assert.Empty(t, checkParameters(parametersTestTemplate, map[string]interface{}{
+		"Env": "qa", "Name": "my-app", "Password": "secret",
+	}, false))
+
+	assert.Equal(t, []string{
+		"Parameters/Envv: not declared by the template",
+		"Parameters/Count: \"many\" is not a Number",
+		"Parameters/Env: missing, it has no Default",
+		"Parameters/Name: \"My_Application\" does not match the AllowedPattern: [a-z][a-z0-9-]*",
+		"Parameters/Name: \"My_Application\" is longer than the MaxLength: 8",
+		"Parameters/Ports: 70000 is more than the MaxValue: 65535",
+		"Parameters/Subnets: \"d\" is not one of the AllowedValues: [a b c]",
+	}, checkParameters(parametersTestTemplate, map[string]interface{}{
+		"Envv": "qa", "Name": "My_Application", "Count": "many", "Ports": "80,70000", "Subnets": "a,d",
+	}, false))
+
+	assert.Equal(t, []string{
+		"Parameters/Count: 0 is less than the MinValue: 1",
+		"Parameters/Env: \"staging\" is not one of the AllowedValues: [dev qa prod]",
+		"Parameters/Name: \"ab\" is shorter than the MinLength: 3",
+	}, checkParameters(parametersTestTemplate, map[string]interface{}{"Env": "staging", "Name": "ab", "Count": "0"}, false))
+
+	// outputs render empty offline
+	params := map[string]interface{}{"Env": "", "Name": ""}
+	assert.Empty(t, checkParameters(parametersTestTemplate, params, true))
+	assert.Equal(t, 3, len(checkParameters(parametersTestTemplate, params, false)))
+}
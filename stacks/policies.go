@@ -0,0 +1,439 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+	jsonptr "github.com/dustin/go-jsonpointer"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// PolicyRule is a check of the resources of the rendered templates, loaded from the yaml files of the policies
+// directories of the stacks yaml:
+//
+//   rules:
+//     - id: s3-encryption
+//       description: S3 buckets must have encryption
+//       severity: error # or warning
+//       resource_types: ['AWS::S3::Bucket']
+//       require:
+//         - path: Properties/BucketEncryption
+//
+// A resource breaks the rule when one of the require conditions doesn't hold, or one of the forbid conditions holds.
+type PolicyRule struct {
+	Id            string           `yaml:"id"`
+	Description   string           `yaml:"description"`
+	Severity      string           `yaml:"severity"`
+	ResourceTypes []string         `yaml:"resource_types"` // glob patterns, all resources by default
+	Require       []*RuleCondition `yaml:"require"`
+	Forbid        []*RuleCondition `yaml:"forbid"`
+
+	File string `yaml:"-"`
+}
+
+// RuleCondition holds when a value at the path of a resource (slash separated, * for any item) exists, and is
+// equal to equals, matches the matches regexp, and has the where values. equals and where values can be
+// comparisons: '<=22', '>=22', '<1', '>1', '!=x'
+type RuleCondition struct {
+	Path    string                 `yaml:"path"`
+	Equals  interface{}            `yaml:"equals"`
+	Matches string                 `yaml:"matches"`
+	Where   map[string]interface{} `yaml:"where"`
+
+	matchesRe *regexp.Regexp
+}
+
+// Finding is a resource breaking a policy rule
+type Finding struct {
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Stack    string `json:"stack"`
+	Resource string `json:"resource"`
+	Message  string `json:"message"`
+}
+
+func (f *Finding) String() string {
+	return fmt.Sprintf("%-7s %s %s/%s: %s", strings.ToUpper(f.Severity), f.Rule, f.Stack, f.Resource, f.Message)
+}
+
+// PolicyPaths are the policies directories or files of the stacks yaml, relative to it
+func (c *StacksConfig) PolicyPaths() []string {
+	paths := []string{}
+	switch val := c.ProcessValue(jsonptr.Get(c.Yaml, "/policies")).(type) {
+	case string:
+		paths = append(paths, val)
+	case []interface{}:
+		for _, p := range val {
+			paths = append(paths, fmt.Sprint(p))
+		}
+	}
+	for i, p := range paths {
+		if !filepath.IsAbs(p) {
+			paths[i] = filepath.Join(filepath.Dir(c.FileName), p)
+		}
+	}
+	return paths
+}
+
+// Policies are the rules of the yaml files in the policies paths of the stacks yaml
+func (c *StacksConfig) Policies() ([]*PolicyRule, error) {
+	rules := []*PolicyRule{}
+	for _, p := range c.PolicyPaths() {
+		files := []string{p}
+		if !strings.HasSuffix(p, ".yml") && !strings.HasSuffix(p, ".yaml") {
+			ymlFiles, _ := filepath.Glob(filepath.Join(p, "*.yml"))
+			yamlFiles, _ := filepath.Glob(filepath.Join(p, "*.yaml"))
+			files = append(ymlFiles, yamlFiles...)
+			sort.Strings(files)
+			if len(files) == 0 {
+				return nil, fmt.Errorf("no policy files (*.yml, *.yaml) in: %s", p)
+			}
+		}
+		for _, f := range files {
+			fileRules, err := LoadPolicyRules(f)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, fileRules...)
+		}
+	}
+	return rules, nil
+}
+
+// LoadPolicyRules loads the rules of a policy file
+func LoadPolicyRules(file string) ([]*PolicyRule, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	doc := struct {
+		Rules []*PolicyRule `yaml:"rules"`
+	}{}
+	if err = yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("Error parsing policy file: %s %v", file, err)
+	}
+	if len(doc.Rules) == 0 {
+		return nil, fmt.Errorf("no rules in policy file: %s", file)
+	}
+	for _, rule := range doc.Rules {
+		rule.File = file
+		if err = rule.init(); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return doc.Rules, nil
+}
+
+func (r *PolicyRule) init() error {
+	if len(r.Id) == 0 {
+		return fmt.Errorf("policy rule without an id")
+	}
+	if len(r.Severity) == 0 {
+		r.Severity = SeverityError
+	}
+	if r.Severity != SeverityError && r.Severity != SeverityWarning {
+		return fmt.Errorf("rule: %s invalid severity: %s, expected: error or warning", r.Id, r.Severity)
+	}
+	if len(r.ResourceTypes) == 0 {
+		r.ResourceTypes = []string{"*"}
+	}
+	for _, pattern := range r.ResourceTypes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("rule: %s invalid resource type pattern: %s", r.Id, pattern)
+		}
+	}
+	if len(r.Require)+len(r.Forbid) == 0 {
+		return fmt.Errorf("rule: %s has no require or forbid conditions", r.Id)
+	}
+	for _, cond := range append(append([]*RuleCondition{}, r.Require...), r.Forbid...) {
+		if len(cond.Path) == 0 {
+			return fmt.Errorf("rule: %s condition without a path", r.Id)
+		}
+		if len(cond.Matches) > 0 {
+			re, err := regexp.Compile(cond.Matches)
+			if err != nil {
+				return fmt.Errorf("rule: %s invalid matches: %s %v", r.Id, cond.Matches, err)
+			}
+			cond.matchesRe = re
+		}
+	}
+	return nil
+}
+
+func (r *PolicyRule) appliesTo(resourceType string) bool {
+	for _, pattern := range r.ResourceTypes {
+		if ok, _ := path.Match(pattern, resourceType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// message of a finding of the rule, the description or the condition broken
+func (r *PolicyRule) message(cond *RuleCondition, forbidden bool) string {
+	if len(r.Description) > 0 {
+		return r.Description
+	}
+	if forbidden {
+		return "forbidden: " + cond.String()
+	}
+	return "required: " + cond.String()
+}
+
+func (c *RuleCondition) String() string {
+	s := c.Path
+	if c.Equals != nil {
+		s += fmt.Sprintf(" equals %v", c.Equals)
+	}
+	if len(c.Matches) > 0 {
+		s += " matches " + c.Matches
+	}
+	if len(c.Where) > 0 {
+		s += fmt.Sprintf(" where %v", c.Where)
+	}
+	return s
+}
+
+// holds is true when a value at the path of the resource meets the condition
+func (c *RuleCondition) holds(resource interface{}) bool {
+	for _, val := range valuesAtPath(resource, strings.Split(c.Path, "/")) {
+		if c.Equals != nil && !compareValue(val, c.Equals) {
+			continue
+		}
+		if c.matchesRe != nil && !c.matchesRe.MatchString(fmt.Sprint(val)) {
+			continue
+		}
+		where := true
+		for subPath, expected := range c.Where {
+			found := false
+			for _, sub := range valuesAtPath(val, strings.Split(subPath, "/")) {
+				if compareValue(sub, expected) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				where = false
+				break
+			}
+		}
+		if where {
+			return true
+		}
+	}
+	return false
+}
+
+// valuesAtPath are the values at the path segments, * is any map value or list item
+func valuesAtPath(value interface{}, segments []string) []interface{} {
+	if len(segments) == 0 {
+		return []interface{}{value}
+	}
+	seg, rest := segments[0], segments[1:]
+	if len(seg) == 0 {
+		return valuesAtPath(value, rest)
+	}
+	values := []interface{}{}
+	switch val := value.(type) {
+	case map[string]interface{}:
+		if seg == "*" {
+			for _, k := range mapKeys(val) {
+				values = append(values, valuesAtPath(val[k], rest)...)
+			}
+		} else if v, ok := val[seg]; ok {
+			values = append(values, valuesAtPath(v, rest)...)
+		}
+	case []interface{}:
+		if seg == "*" {
+			for _, item := range val {
+				values = append(values, valuesAtPath(item, rest)...)
+			}
+		} else if i, err := strconv.Atoi(seg); err == nil && i >= 0 && i < len(val) {
+			values = append(values, valuesAtPath(val[i], rest)...)
+		}
+	}
+	return values
+}
+
+// compareValue compares a value with an expected one, or a comparison: <=, >=, <, > (numbers) and !=
+func compareValue(actual interface{}, expected interface{}) bool {
+	exp, ok := expected.(string)
+	if !ok {
+		return fmt.Sprint(actual) == fmt.Sprint(expected)
+	}
+	for _, op := range []string{"<=", ">=", "!=", "<", ">"} {
+		if !strings.HasPrefix(exp, op) {
+			continue
+		}
+		operand := strings.TrimSpace(exp[len(op):])
+		if op == "!=" {
+			return fmt.Sprint(actual) != operand
+		}
+		a, err := strconv.ParseFloat(fmt.Sprint(actual), 64)
+		if err != nil {
+			return false
+		}
+		b, err := strconv.ParseFloat(operand, 64)
+		if err != nil {
+			return false
+		}
+		switch op {
+		case "<=":
+			return a <= b
+		case ">=":
+			return a >= b
+		case "<":
+			return a < b
+		}
+		return a > b
+	}
+	return fmt.Sprint(actual) == exp
+}
+
+// evaluatePolicies are the findings of the rules on the resources of the template
+func evaluatePolicies(rules []*PolicyRule, stackName string, template string) ([]*Finding, error) {
+	doc, err := decodeTemplate(template)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing the template of: %s %v", stackName, err)
+	}
+	resources := utils.ToStrMap(doc["Resources"])
+	findings := []*Finding{}
+	for _, id := range mapKeys(resources) {
+		resource := utils.ToStrMap(resources[id])
+		resourceType, _ := resource["Type"].(string)
+		for _, rule := range rules {
+			if !rule.appliesTo(resourceType) {
+				continue
+			}
+			if cond := brokenCondition(rule, resource); cond != nil {
+				findings = append(findings, &Finding{Severity: rule.Severity, Rule: rule.Id, Stack: stackName,
+					Resource: id, Message: rule.message(cond, !isRequired(rule, cond))})
+			}
+		}
+	}
+	return findings, nil
+}
+
+// templatePolicyFindings evaluates the policies on the template and its local nested stack templates,
+// the resources of a nested template are named after its nested stack, i.e. Network/Vpc
+func templatePolicyFindings(rules []*PolicyRule, stackName string, templateFile string, template string) ([]*Finding, error) {
+	findings, err := evaluatePolicies(rules, stackName, template)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(templateFile)
+	for _, ref := range findLocalRefs(template, dir) {
+		if ref.Type != "AWS::CloudFormation::Stack" {
+			continue
+		}
+		nestedFile := localPath(ref.Path, dir)
+		nested, err := templatePolicyFindings(rules, stackName, nestedFile, loadTemplate(nestedFile))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", nestedFile, err)
+		}
+		for _, f := range nested {
+			f.Resource = ref.Resource + "/" + f.Resource
+		}
+		findings = append(findings, nested...)
+	}
+	return findings, nil
+}
+
+// brokenCondition is the first condition of the rule the resource breaks, or nil
+func brokenCondition(rule *PolicyRule, resource map[string]interface{}) *RuleCondition {
+	for _, cond := range rule.Require {
+		if !cond.holds(resource) {
+			return cond
+		}
+	}
+	for _, cond := range rule.Forbid {
+		if cond.holds(resource) {
+			return cond
+		}
+	}
+	return nil
+}
+
+func isRequired(rule *PolicyRule, cond *RuleCondition) bool {
+	for _, c := range rule.Require {
+		if c == cond {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyFindings evaluates the policies of the stacks yaml on the templates of the stacks
+func PolicyFindings(envStacks *EnvStacksConfig) ([]*Finding, error) {
+	rules, err := envStacks.Config.Policies()
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	findings := []*Finding{}
+	for _, stackLabel := range envStacks.StackLabels {
+		stack := envStacks.Stack(stackLabel)
+		templateFile := findTemplateFile(stack.TemplatePaths()...)
+		stackFindings, err := templatePolicyFindings(rules, stack.Name(), templateFile, loadTemplate(stack.TemplatePaths()...))
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, stackFindings...)
+	}
+	return findings, nil
+}
+
+// printFindings prints the findings, and returns the number of errors
+func printFindings(findings []*Finding) (errors int) {
+	for _, f := range findings {
+		fmt.Println(f.String())
+		if f.Severity == SeverityError {
+			errors++
+		}
+	}
+	return errors
+}
+
+// checkPolicies prints the policy findings of the stacks, errors fail the deploy
+func (a *AWSStackApi) checkPolicies(envStacks *EnvStacksConfig) {
+	findings, err := PolicyFindings(envStacks)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	errors := printFindings(findings)
+	if errors > 0 && a.IsDryMode() {
+		log.Warnf("Would refuse to deploy: %d policy errors", errors)
+	} else if errors > 0 {
+		log.Fatalf("%d policy errors, fix them before deploying", errors)
+	}
+}
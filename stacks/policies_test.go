@@ -0,0 +1,160 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const policiesTestTemplate = `Resources:
+  Logs:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketEncryption:
+        ServerSideEncryptionConfiguration:
+          - ServerSideEncryptionByDefault:
+              SSEAlgorithm: AES256
+      Tags:
+        - Key: Environment
+          Value: !Ref Env
+  Data:
+    Type: AWS::S3::Bucket
+  Ssh:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      SecurityGroupIngress:
+        - CidrIp: 10.0.0.0/8
+          FromPort: 22
+          ToPort: 22
+        - CidrIp: 0.0.0.0/0
+          FromPort: 0
+          ToPort: 1024
+      Tags:
+        - Key: Environment
+          Value: qa
+  Web:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      SecurityGroupIngress:
+        - CidrIp: 0.0.0.0/0
+          FromPort: '443'
+          ToPort: '443'
+      Tags: [{Key: Environment, Value: qa}]
+  Queue:
+    Type: AWS::SQS::Queue
+`
+
+func TestEvaluatePolicies(t *testing.T) {
+	rules, err := LoadPolicyRules(ResourcePath("policies/security.yml"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(rules))
+
+	findings, err := evaluatePolicies(rules, "qa-app", policiesTestTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Finding{
+		{Severity: SeverityError, Rule: "s3-encryption", Stack: "qa-app", Resource: "Data", Message: "S3 buckets must have encryption"},
+		{Severity: SeverityWarning, Rule: "environment-tag", Stack: "qa-app", Resource: "Data", Message: "all resources must have the Environment tag"},
+		{Severity: SeverityError, Rule: "no-world-ssh", Stack: "qa-app", Resource: "Ssh", Message: "no 0.0.0.0/0 ingress on port 22"},
+	}, findings)
+	assert.Equal(t, 2, printFindings(findings))
+	assert.Equal(t, "WARNING environment-tag qa-app/Data: all resources must have the Environment tag", findings[1].String())
+
+	_, err = evaluatePolicies(rules, "qa-app", "Resources: [")
+	assert.NotNil(t, err)
+}
+
+func TestTemplatePolicyFindings(t *testing.T) {
+	dir := writePackageTestFiles(t)
+	defer os.RemoveAll(dir)
+
+	rule := &PolicyRule{Id: "lambda-runtime", ResourceTypes: []string{"AWS::Lambda::Function"},
+		Require: []*RuleCondition{{Path: "Properties/Runtime"}}}
+	assert.Nil(t, rule.init())
+	findings, err := templatePolicyFindings([]*PolicyRule{rule}, "qa-app", filepath.Join(dir, "template.yml"), packageTestTemplate)
+	assert.Nil(t, err)
+	resources := []string{}
+	for _, f := range findings {
+		resources = append(resources, f.Resource)
+	}
+	// the local nested stack template is evaluated too, the remote one isnt
+	assert.Equal(t, []string{"Fn", "Param", "Nested/Fn"}, resources)
+}
+
+func TestPolicyRuleMessage(t *testing.T) {
+	rule := &PolicyRule{Id: "no-public-read", Forbid: []*RuleCondition{{Path: "Properties/AccessControl", Matches: "^Public"}}}
+	assert.Nil(t, rule.init())
+	findings, err := evaluatePolicies([]*PolicyRule{rule}, "qa-app",
+		`{"Resources": {"B": {"Type": "AWS::S3::Bucket", "Properties": {"AccessControl": "PublicRead"}}}}`)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(findings))
+	assert.Equal(t, SeverityError, findings[0].Severity)
+	assert.Equal(t, "forbidden: Properties/AccessControl matches ^Public", findings[0].Message)
+}
+
+func TestLoadPolicyRulesInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policies")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for content, msg := range map[string]string{
+		"rules: [{description: x, require: [{path: a}]}]":         "without an id",
+		"rules: [{id: x, severity: fatal, require: [{path: a}]}]": "invalid severity",
+		"rules: [{id: x}]":                                             "no require or forbid",
+		"rules: [{id: x, forbid: [{equals: 1}]}]":                      "without a path",
+		"rules: [{id: x, forbid: [{path: a, matches: '(' }]}]":         "invalid matches",
+		"rules: [{id: x, resource_types: ['['], forbid: [{path: a}]}]": "invalid resource type",
+	} {
+		p := filepath.Join(dir, "rules.yml")
+		assert.Nil(t, ioutil.WriteFile(p, []byte(content), 0644))
+		_, err = LoadPolicyRules(p)
+		if assert.NotNil(t, err, content) {
+			assert.Contains(t, err.Error(), msg)
+		}
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	rules, err := c.Policies()
+	assert.Nil(t, err)
+	assert.Empty(t, rules)
+
+	c.Yaml["policies"] = "policies"
+	assert.Equal(t, []string{ResourcePath("policies")}, c.PolicyPaths())
+	rules, err = c.Policies()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(rules))
+
+	c.Yaml["policies"] = []interface{}{"policies/security.yml", "bluegreen"}
+	_, err = c.Policies()
+	assert.NotNil(t, err)
+}
+
+func TestCompareValue(t *testing.T) {
+	assert.True(t, compareValue(22, "<=22"))
+	assert.True(t, compareValue("80", ">22"))
+	assert.False(t, compareValue("-1", ">=0"))
+	assert.False(t, compareValue(map[string]interface{}{"Ref": "Port"}, "<=22"))
+	assert.True(t, compareValue("tcp", "!=udp"))
+	assert.True(t, compareValue(true, true))
+	assert.False(t, compareValue("x", "y"))
+}
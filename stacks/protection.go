@@ -0,0 +1,249 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	jsonptr "github.com/dustin/go-jsonpointer"
+)
+
+// the env keys that are settings of the env, not stacks
+var envSettingKeys = []string{"protection"}
+
+var (
+	// where the confirmation of a protected env is typed
+	confirmInput io.Reader = os.Stdin
+
+	freezeRangeRe  = regexp.MustCompile(`^\s*(.+?)\s+(?:-|–|to)\s+(.+?)\s*$`)
+	freezeWeeklyRe = regexp.MustCompile(`(?i)^(sun|mon|tue|wed|thu|fri|sat)[a-z]*\s+(\d{1,2}):(\d{2})$`)
+	weekdays       = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// Protection are the rules guarding the deploy, delete and rollback of an env, the protection block of the env:
+//
+//   protection:
+//     confirm: true                       # type the env name to continue
+//     branches: [master, 'release/*']     # git branches allowed to change the env
+//     freeze:                             # no changes in these windows
+//       - Fri 15:00 - Mon 08:00           # weekly
+//       - 2026-12-20 00:00 - 2027-01-04 00:00
+//     timezone: America/New_York          # of the freeze windows, local time by default
+//     require_ticket: true                # --ticket is required
+type Protection struct {
+	Env           string
+	Confirm       bool
+	Branches      []string
+	Freeze        []*FreezeWindow
+	RequireTicket bool
+}
+
+// FreezeWindow is a weekly or a one-off window in which an env is frozen
+type FreezeWindow struct {
+	Spec string
+
+	weekly     bool
+	start, end time.Time // one-off
+	from, to   int       // weekly, minutes since sunday 00:00
+	loc        *time.Location
+}
+
+// Protection is the protection of the env, nil when it is unprotected
+func (e *EnvStacksConfig) Protection() (*Protection, error) {
+	return e.Config.envProtection(e.Env)
+}
+
+// Protections are the protections of the envs of the selected stacks: the env, then the other envs
+// of the stacks selected with --with-deps
+func (e *EnvStacksConfig) Protections() ([]*Protection, error) {
+	protections := []*Protection{}
+	for _, env := range e.Envs() {
+		p, err := e.Config.envProtection(env)
+		if err != nil {
+			return nil, fmt.Errorf("environment: %s %v", env, err)
+		}
+		if p != nil {
+			protections = append(protections, p)
+		}
+	}
+	return protections, nil
+}
+
+func (c *StacksConfig) envProtection(env string) (*Protection, error) {
+	val := jsonptr.Get(c.Yaml, "/stacks/"+escJsonPtr(env)+"/protection")
+	if val == nil {
+		return nil, nil
+	}
+	yaml := utils.ToStrMap(c.ProcessValue(val))
+	p := &Protection{
+		Env:           env,
+		Confirm:       isTrue(yaml["confirm"]),
+		Branches:      stringList(yaml["branches"]),
+		RequireTicket: isTrue(yaml["require_ticket"]),
+	}
+
+	loc := time.Local
+	if tz, ok := yaml["timezone"].(string); ok && len(tz) > 0 {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("invalid protection timezone: %s %v", tz, err)
+		}
+	}
+	for _, spec := range stringList(yaml["freeze"]) {
+		w, err := ParseFreezeWindow(spec, loc)
+		if err != nil {
+			return nil, err
+		}
+		p.Freeze = append(p.Freeze, w)
+	}
+	return p, nil
+}
+
+func isTrue(val interface{}) bool {
+	switch v := val.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// ParseFreezeWindow parses "<day> HH:MM - <day> HH:MM" weekly windows,
+// or "YYYY-MM-DD HH:MM - YYYY-MM-DD HH:MM" one-off windows
+func ParseFreezeWindow(spec string, loc *time.Location) (*FreezeWindow, error) {
+	m := freezeRangeRe.FindStringSubmatch(spec)
+	if m == nil {
+		return nil, fmt.Errorf("invalid freeze window: %s, expected: <start> - <end>", spec)
+	}
+	w := &FreezeWindow{Spec: spec, loc: loc}
+	from, fromOk := weeklyMinutes(m[1])
+	to, toOk := weeklyMinutes(m[2])
+	if fromOk && toOk {
+		w.weekly, w.from, w.to = true, from, to
+		return w, nil
+	}
+
+	var err error
+	if w.start, err = time.ParseInLocation("2006-01-02 15:04", m[1], loc); err != nil {
+		return nil, fmt.Errorf("invalid freeze window: %s, expected: Fri 15:00 - Mon 08:00 or 2006-01-02 15:04 - 2006-01-03 08:00", spec)
+	}
+	if w.end, err = time.ParseInLocation("2006-01-02 15:04", m[2], loc); err != nil || !w.end.After(w.start) {
+		return nil, fmt.Errorf("invalid freeze window: %s, the end must be a date after the start", spec)
+	}
+	return w, nil
+}
+
+// weeklyMinutes are the minutes since sunday 00:00 of "<day> HH:MM"
+func weeklyMinutes(s string) (int, bool) {
+	m := freezeWeeklyRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, false
+	}
+	hour, _ := strconv.Atoi(m[2])
+	min, _ := strconv.Atoi(m[3])
+	if hour > 23 || min > 59 {
+		return 0, false
+	}
+	for day, name := range weekdays {
+		if strings.ToLower(m[1]) == name {
+			return day*24*60 + hour*60 + min, true
+		}
+	}
+	return 0, false
+}
+
+// Contains is true when t is in the window
+func (w *FreezeWindow) Contains(t time.Time) bool {
+	if !w.weekly {
+		return !t.Before(w.start) && t.Before(w.end)
+	}
+	t = t.In(w.loc)
+	now := int(t.Weekday())*24*60 + t.Hour()*60 + t.Minute()
+	if w.from <= w.to {
+		return now >= w.from && now < w.to
+	}
+	// wraps around the end of the week
+	return now >= w.from || now < w.to
+}
+
+// Violations are the rules the change of the env breaks, empty when it is allowed
+func (p *Protection) Violations(branch string, now time.Time, ticket string) []string {
+	violations := []string{}
+	if len(p.Branches) > 0 && len(branch) == 0 {
+		violations = append(violations, "the git branch is unknown on a detached checkout, set BRANCH_NAME")
+	} else if len(p.Branches) > 0 && !matchesAny(p.Branches, branch) {
+		violations = append(violations, fmt.Sprintf("branch: %s is not one of: %s", branch, strings.Join(p.Branches, ", ")))
+	}
+	for _, w := range p.Freeze {
+		if w.Contains(now) {
+			violations = append(violations, fmt.Sprintf("%s is frozen: %s", p.Env, w.Spec))
+		}
+	}
+	if p.RequireTicket && len(strings.TrimSpace(ticket)) == 0 {
+		violations = append(violations, fmt.Sprintf("%s requires a ticket: --ticket <reference>", p.Env))
+	}
+	return violations
+}
+
+func matchesAny(patterns []string, val string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, val); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Confirmed asks for the env name to be typed, true when it was
+func (p *Protection) Confirmed(operation string, in io.Reader, out io.Writer) bool {
+	fmt.Fprintf(out, "%s is protected, type the environment name to %s: ", p.Env, operation)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return strings.TrimSpace(line) == p.Env
+}
+
+func (p *Protection) String() string {
+	rules := []string{}
+	if p.Confirm {
+		rules = append(rules, "confirmation")
+	}
+	if len(p.Branches) > 0 {
+		rules = append(rules, "branches: "+strings.Join(p.Branches, ", "))
+	}
+	for _, w := range p.Freeze {
+		rules = append(rules, "freeze: "+w.Spec)
+	}
+	if p.RequireTicket {
+		rules = append(rules, "ticket")
+	}
+	return strings.Join(rules, "; ")
+}
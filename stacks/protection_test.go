@@ -0,0 +1,113 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtection(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	qa4 := c.FetchEnvStacks("qa4")
+	assert.Equal(t, []string{"nagios-elb", "nagios-server"}, qa4.StackLabels)
+
+	p, err := qa4.Protection()
+	assert.Nil(t, err)
+	assert.True(t, p.Confirm)
+	assert.True(t, p.RequireTicket)
+	assert.Equal(t, []string{"master", "release/*"}, p.Branches)
+	assert.Len(t, p.Freeze, 1)
+	assert.Equal(t, "confirmation; branches: master, release/*; freeze: Fri 15:00 - Mon 08:00; ticket", p.String())
+
+	wednesday := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	assert.Empty(t, p.Violations("master", wednesday, "CHG-1234"))
+	assert.Empty(t, p.Violations("release/1.2", wednesday, "CHG-1234"))
+
+	violations := p.Violations("feature/x", saturday, "")
+	assert.Len(t, violations, 3)
+	assert.Contains(t, violations[0], "branch: feature/x")
+	assert.Equal(t, "qa4 is frozen: Fri 15:00 - Mon 08:00", violations[1])
+	assert.Contains(t, violations[2], "--ticket")
+	assert.Contains(t, p.Violations("", wednesday, "CHG-1234")[0], "branch is unknown")
+
+	p, err = c.FetchEnvStacks("qa3").Protection()
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+}
+
+func TestProtections(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	protections, err := c.FetchEnvStacksSelection("qa4.nagios-elb", &StackSelection{WithDeps: true}).Protections()
+	assert.Nil(t, err)
+	assert.Len(t, protections, 1)
+	assert.Equal(t, "qa4", protections[0].Env)
+
+	// stacks of other envs selected with --with-deps are guarded by the protection of their env
+	c.Yaml["stacks"].(map[string]interface{})["shared"].(map[string]interface{})["protection"] = map[string]interface{}{"confirm": true}
+	protections, err = c.FetchEnvStacksSelection("qa4.nagios-elb", &StackSelection{WithDeps: true}).Protections()
+	assert.Nil(t, err)
+	assert.Len(t, protections, 2)
+	assert.Equal(t, "shared", protections[1].Env)
+	assert.True(t, protections[1].Confirm)
+
+	protections, err = c.FetchEnvStacks("qa4.nagios-elb").Protections()
+	assert.Nil(t, err)
+	assert.Len(t, protections, 1)
+}
+
+func TestFreezeWindow(t *testing.T) {
+	weekend, err := ParseFreezeWindow("Fri 15:00 - Mon 08:00", time.UTC)
+	assert.Nil(t, err)
+	assert.False(t, weekend.Contains(time.Date(2026, 10, 16, 14, 59, 0, 0, time.UTC)))
+	assert.True(t, weekend.Contains(time.Date(2026, 10, 16, 15, 0, 0, 0, time.UTC)))
+	assert.True(t, weekend.Contains(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)))
+	assert.True(t, weekend.Contains(time.Date(2026, 10, 19, 7, 59, 0, 0, time.UTC)))
+	assert.False(t, weekend.Contains(time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)))
+
+	// compared in the timezone of the window
+	est := time.FixedZone("EST", -5*60*60)
+	evening, err := ParseFreezeWindow("Tuesday 18:00 – Tuesday 22:00", est)
+	assert.Nil(t, err)
+	assert.True(t, evening.Contains(time.Date(2026, 10, 20, 23, 30, 0, 0, time.UTC)))
+	assert.False(t, evening.Contains(time.Date(2026, 10, 20, 18, 30, 0, 0, time.UTC)))
+
+	holidays, err := ParseFreezeWindow("2026-12-20 00:00 - 2027-01-04 08:00", time.UTC)
+	assert.Nil(t, err)
+	assert.True(t, holidays.Contains(time.Date(2026, 12, 25, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, holidays.Contains(time.Date(2027, 1, 4, 8, 0, 0, 0, time.UTC)))
+
+	for _, spec := range []string{"weekends", "Fri 15:00 - 2026-12-20 00:00", "Fri 25:00 - Mon 08:00", "2027-01-04 00:00 - 2026-12-20 00:00"} {
+		_, err = ParseFreezeWindow(spec, time.UTC)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestProtectionConfirmed(t *testing.T) {
+	p := &Protection{Env: "prod", Confirm: true}
+	out := &bytes.Buffer{}
+	assert.True(t, p.Confirmed("deploy", strings.NewReader("prod\n"), out))
+	assert.Equal(t, "prod is protected, type the environment name to deploy: ", out.String())
+	assert.False(t, p.Confirmed("deploy", strings.NewReader("yes\n"), out))
+	assert.False(t, p.Confirmed("deploy", strings.NewReader(""), out))
+}
@@ -0,0 +1,140 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const ManifestFile = "manifest.json"
+
+// RenderManifest lists the rendered stacks of an environment in deploy order, and their files
+type RenderManifest struct {
+	Env    string           `json:"env"`
+	Stacks []*RenderedStack `json:"stacks"`
+}
+
+type RenderedStack struct {
+	Env           string   `json:"env"` // the env of the stack, another env for stacks selected with --with-deps
+	Label         string   `json:"label"`
+	StackName     string   `json:"stack_name"`
+	DependsOn     []string `json:"depends_on,omitempty"`
+	Template      string   `json:"template"`      // file of the rendered template body
+	Parameters    string   `json:"parameters"`    // aws cloudformation parameters json file
+	Configuration string   `json:"configuration"` // CodePipeline template configuration json file, parameters and tags
+}
+
+// cfParameter is a parameter of the aws cloudformation --parameters json file
+type cfParameter struct {
+	ParameterKey   string
+	ParameterValue string
+}
+
+// templateConfiguration is a CodePipeline template configuration file
+type templateConfiguration struct {
+	Parameters map[string]string
+	Tags       map[string]string
+}
+
+// RenderStacks writes the rendered template, the parameter files of each stack and a manifest of the stacks to outDir:
+// <stack name>.template, <stack name>.parameters.json (aws cloudformation), <stack name>.configuration.json
+// (CodePipeline template configuration) and manifest.json. The templates are packaged as deployed, without uploading.
+func (a *AWSStackApi) RenderStacks(envStacks *EnvStacksConfig, outDir string) (*RenderManifest, error) {
+	a.useTemplateBucket(envStacks.Config)
+	return renderStacks(envStacks, outDir, a.previewTemplate)
+}
+
+// renderStacks writes the rendered stacks, with the template body of each stack from templateBody
+func renderStacks(envStacks *EnvStacksConfig, outDir string, templateBody func(stack *StackConfig) string) (*RenderManifest, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	manifest := &RenderManifest{Env: envStacks.Env}
+	for _, stackLabel := range envStacks.StackLabels {
+		stack := envStacks.Stack(stackLabel)
+		stackmap := utils.ToStrMap(stack.FetchAll())
+		params := stringValues(utils.ToStrMap(stackmap["parameters"]))
+		tags := stringValues(utils.ToStrMap(stackmap["tags"]))
+
+		template := templateBody(stack)
+		if len(template) == 0 {
+			return nil, fmt.Errorf("template not found for stack: %s %v", stack.Name(), stack.TemplatePaths())
+		}
+		cfParams := []*cfParameter{}
+		for _, k := range mapKeys(params) {
+			cfParams = append(cfParams, &cfParameter{ParameterKey: k, ParameterValue: params[k]})
+		}
+
+		rs := &RenderedStack{
+			Env:           stack.Env(),
+			Label:         stackLabel,
+			StackName:     stack.Name(),
+			Template:      stack.Name() + ".template",
+			Parameters:    stack.Name() + ".parameters.json",
+			Configuration: stack.Name() + ".configuration.json",
+		}
+		if deps := stack.dependsOn(); len(deps) > 0 {
+			rs.DependsOn = deps
+		}
+		if err := writeRendered(outDir, rs.Template, []byte(template)); err != nil {
+			return nil, err
+		}
+		if err := writeRenderedJSON(outDir, rs.Parameters, cfParams); err != nil {
+			return nil, err
+		}
+		if err := writeRenderedJSON(outDir, rs.Configuration, &templateConfiguration{Parameters: params, Tags: tags}); err != nil {
+			return nil, err
+		}
+		manifest.Stacks = append(manifest.Stacks, rs)
+	}
+	return manifest, writeRenderedJSON(outDir, ManifestFile, manifest)
+}
+
+func writeRendered(outDir, name string, b []byte) error {
+	p := filepath.Join(outDir, name)
+	if err := ioutil.WriteFile(p, b, 0644); err != nil {
+		return fmt.Errorf("Error writing: %s %v", p, err)
+	}
+	log.Infof("Wrote: %s", p)
+	return nil
+}
+
+func writeRenderedJSON(outDir, name string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeRendered(outDir, name, append(b, '\n'))
+}
+
+// stringValues are the rendered parameter or tag values as strings
+func stringValues(m map[string]interface{}) map[string]string {
+	res := map[string]string{}
+	for k, v := range m {
+		res[k] = fmt.Sprint(v)
+	}
+	return res
+}
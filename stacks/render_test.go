@@ -0,0 +1,137 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func unpackagedTemplate(stack *StackConfig) string {
+	return loadTemplate(stack.TemplatePaths()...)
+}
+
+func TestRenderStacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	os.Setenv("VPCId", "vpc-1234")
+	defer os.Unsetenv("VPCId")
+
+	c := NewConfig(ResourcePath("bluegreen/stack_blue.yaml"), nil)
+	out := filepath.Join(dir, "build")
+	manifest, err := renderStacks(c.FetchEnvStacks("dev-techops.blue"), out, unpackagedTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, "dev-techops", manifest.Env)
+	assert.Equal(t, 1, len(manifest.Stacks))
+	rs := manifest.Stacks[0]
+	assert.Equal(t, &RenderedStack{Env: "dev-techops", Label: "blue", StackName: "blue", Template: "blue.template",
+		Parameters: "blue.parameters.json", Configuration: "blue.configuration.json"}, rs)
+
+	b, err := ioutil.ReadFile(filepath.Join(out, ManifestFile))
+	assert.Nil(t, err)
+	written := &RenderManifest{}
+	assert.Nil(t, json.Unmarshal(b, written))
+	assert.Equal(t, manifest, written)
+
+	b, err = ioutil.ReadFile(filepath.Join(out, rs.Template))
+	assert.Nil(t, err)
+	assert.Equal(t, loadTemplate(c.FetchEnvStacks("dev-techops.blue").Stack("blue").TemplatePaths()...), string(b))
+
+	b, err = ioutil.ReadFile(filepath.Join(out, rs.Parameters))
+	assert.Nil(t, err)
+	params := []*cfParameter{}
+	assert.Nil(t, json.Unmarshal(b, &params))
+	assert.Equal(t, "AMI", params[0].ParameterKey)
+	assert.Contains(t, params, &cfParameter{ParameterKey: "VPCId", ParameterValue: "vpc-1234"})
+
+	b, err = ioutil.ReadFile(filepath.Join(out, rs.Configuration))
+	assert.Nil(t, err)
+	conf := &templateConfiguration{}
+	assert.Nil(t, json.Unmarshal(b, conf))
+	assert.Equal(t, "vpc-1234", conf.Parameters["VPCId"])
+	assert.Equal(t, "dev", conf.Tags["Environment"])
+	assert.Equal(t, len(params), len(conf.Parameters))
+}
+
+func TestRenderStacksOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"stacks.yml": "stacks:\n  qa:\n    app:\n      depends_on: db\n      parameters: {Port: 8080}\n    db: {}\n",
+		"app.yml":    "Parameters:\n  Port:\n    Type: Number\nResources: {}\n",
+		"db.json":    `{"Resources": {}}`,
+	}
+	for name, content := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	c := NewConfig(filepath.Join(dir, "stacks.yml"), nil)
+	manifest, err := renderStacks(c.FetchEnvStacks("qa"), filepath.Join(dir, "build"), unpackagedTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(manifest.Stacks))
+	assert.Equal(t, "db", manifest.Stacks[0].StackName)
+	assert.Equal(t, []string{"db"}, manifest.Stacks[1].DependsOn)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "build", "app.parameters.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, "[\n  {\n    \"ParameterKey\": \"Port\",\n    \"ParameterValue\": \"8080\"\n  }\n]\n", string(b))
+
+	assert.Nil(t, os.Remove(filepath.Join(dir, "db.json")))
+	_, err = renderStacks(c.FetchEnvStacks("qa"), filepath.Join(dir, "build"), unpackagedTemplate)
+	assert.NotNil(t, err)
+}
+
+func TestRenderStacksPackaged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"stacks.yml":   "template_bucket: my-templates\nstacks:\n  shared:\n    vpc: {}\n  qa:\n    fn:\n      depends_on: shared.vpc\n",
+		"vpc.yml":      "Resources: {}\n",
+		"fn.yml":       "Resources:\n  Fn:\n    Type: AWS::Lambda::Function\n    Properties:\n      Code: src\n",
+		"src/index.js": "exports.handler = () => {}",
+	}
+	for name, content := range files {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	a := &AWSStackApi{}
+	a.Session = session.New(aws.NewConfig().WithRegion("us-east-1"))
+	c := NewConfig(filepath.Join(dir, "stacks.yml"), nil)
+	out := filepath.Join(dir, "build")
+	manifest, err := a.RenderStacks(c.FetchEnvStacksSelection("qa.fn", &StackSelection{WithDeps: true}), out)
+	assert.Nil(t, err)
+	assert.False(t, a.IsDryMode())
+	assert.Equal(t, 2, len(manifest.Stacks))
+	assert.Equal(t, "shared", manifest.Stacks[0].Env)
+	assert.Equal(t, "qa", manifest.Stacks[1].Env)
+
+	b, err := ioutil.ReadFile(filepath.Join(out, manifest.Stacks[1].Template))
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), "S3Bucket: 'my-templates'")
+}
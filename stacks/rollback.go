@@ -0,0 +1,98 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// stackRestorePoint is the state of a stack before an atomic deploy changed it
+type stackRestorePoint struct {
+	stackName  string
+	created    bool // created by the deploy, restored by deleting it
+	template   string
+	parameters map[string]interface{}
+	tags       map[string]interface{}
+}
+
+// restorePoint records the current template and parameters of a stack, before updating it
+func (a *AWSStackApi) restorePoint(stack *cloudformation.Stack) (*stackRestorePoint, error) {
+	resp, err := a.CFService().GetTemplate(&cloudformation.GetTemplateInput{
+		StackName: stack.StackName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the template of stack: %s %v", *stack.StackName, err)
+	}
+	return &stackRestorePoint{
+		stackName:  *stack.StackName,
+		template:   aws.StringValue(resp.TemplateBody),
+		parameters: stackParameters(stack),
+		tags:       stackTags(stack),
+	}, nil
+}
+
+// rollbackStacks restores the stacks changed by a failed deploy, most recent first
+func (a *AWSStackApi) rollbackStacks(restorePoints []*stackRestorePoint) {
+	for i := len(restorePoints) - 1; i >= 0; i-- {
+		rp := restorePoints[i]
+		if rp.created {
+			log.Infof("Rolling back: deleting created stack: %s", rp.stackName)
+			// created with termination protection, it would refuse the delete
+			if err := a.applyStackSettings(rp.stackName, &stackSettings{TerminationProtection: aws.Bool(false)}); err != nil {
+				log.Debugf("%v", err)
+			}
+			a.deleteStack(rp.stackName, nil)
+			continue
+		}
+
+		log.Infof("Rolling back: restoring the previous template and parameters of stack: %s", rp.stackName)
+		stack := a.FindStack(rp.stackName)
+		if stack == nil {
+			log.Errorf("Cannot roll back stack: %s not found", rp.stackName)
+			continue
+		}
+		if _, err := a.updateStack(stack, rp.template, rp.parameters, rp.tags, nil); err != nil {
+			log.Errorf("Error rolling back stack: %s %v", rp.stackName, err)
+		}
+	}
+}
+
+// noEchoValue is how CloudFormation returns the values of NoEcho parameters, cftParams sends it as UsePreviousValue
+const noEchoValue = "****"
+
+// stackParameters are the current parameter values of the stack, NoEcho values are masked by CloudFormation
+// and keep their previous value when the parameters are applied again
+func stackParameters(stack *cloudformation.Stack) map[string]interface{} {
+	params := make(map[string]interface{})
+	for _, p := range stack.Parameters {
+		params[aws.StringValue(p.ParameterKey)] = aws.StringValue(p.ParameterValue)
+	}
+	return params
+}
+
+func stackTags(stack *cloudformation.Stack) map[string]interface{} {
+	tags := make(map[string]interface{})
+	for _, t := range stack.Tags {
+		tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return tags
+}
@@ -0,0 +1,53 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStackParametersAndTags(t *testing.T) {
+	stack := &cloudformation.Stack{
+		StackName: aws.String("nagios-elb-qa"),
+		Parameters: []*cloudformation.Parameter{
+			{ParameterKey: aws.String("Port"), ParameterValue: aws.String("80")},
+			{ParameterKey: aws.String("Password"), ParameterValue: aws.String("****")},
+		},
+		Tags: []*cloudformation.Tag{
+			{Key: aws.String(ContentHashTag), Value: aws.String("abc")},
+		},
+	}
+	params := stackParameters(stack)
+	assert.Equal(t, map[string]interface{}{"Port": "80", "Password": noEchoValue}, params)
+	// the masked NoEcho value is not restored as "****", the stack keeps its value
+	for _, p := range cftParams(params) {
+		if *p.ParameterKey == "Password" {
+			assert.Nil(t, p.ParameterValue)
+			assert.True(t, *p.UsePreviousValue)
+		} else {
+			assert.Equal(t, "80", *p.ParameterValue)
+			assert.Nil(t, p.UsePreviousValue)
+		}
+	}
+	assert.Equal(t, map[string]interface{}{ContentHashTag: "abc"}, stackTags(stack))
+	assert.Empty(t, stackParameters(&cloudformation.Stack{StackName: aws.String("empty")}))
+}
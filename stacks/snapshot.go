@@ -0,0 +1,170 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/capitalone/stack-deployment-tool/sdt"
+	"github.com/capitalone/stack-deployment-tool/store"
+	"github.com/capitalone/stack-deployment-tool/utils"
+	"github.com/capitalone/stack-deployment-tool/versioning"
+
+	log "github.com/Sirupsen/logrus"
+	jsonptr "github.com/dustin/go-jsonpointer"
+)
+
+// Snapshot is what a deploy applied to a stack, so it can be redeployed exactly
+type Snapshot struct {
+	Id         string                 `json:"id"`
+	Env        string                 `json:"env"`
+	Label      string                 `json:"label"`
+	StackName  string                 `json:"stack_name"`
+	Template   string                 `json:"template"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Tags       map[string]interface{} `json:"tags"`
+	SdtVersion string                 `json:"sdt_version"`
+	GitCommit  string                 `json:"git_commit"`
+	Created    time.Time              `json:"created"`
+	RollbackOf string                 `json:"rollback_of,omitempty"` // id of the snapshot redeployed by a rollback
+}
+
+func newSnapshot(stack *StackConfig, template string, parameters map[string]interface{}, tags map[string]interface{}) *Snapshot {
+	s := &Snapshot{
+		Env:        stack.Env(),
+		Label:      stack.Label(),
+		StackName:  stack.Name(),
+		Template:   template,
+		Parameters: maskNoEcho(template, parameters),
+		Tags:       tags,
+		SdtVersion: sdt.Version,
+		GitCommit:  strings.TrimSpace(versioning.GitHash()),
+	}
+	s.setId(time.Now().UTC())
+	return s
+}
+
+// maskNoEcho are the parameters with the values of the NoEcho parameters of the template masked, they are not
+// stored in the snapshots: a rollback keeps their current value
+func maskNoEcho(template string, parameters map[string]interface{}) map[string]interface{} {
+	doc, err := decodeTemplate(template)
+	if err != nil {
+		log.Warnf("Masking all the parameters of the snapshot, error decoding the template: %v", err)
+	}
+	masked := map[string]interface{}{}
+	for name, val := range parameters {
+		decl := utils.ToStrMap(utils.ToStrMap(doc["Parameters"])[name])
+		if err != nil || isTrue(decl["NoEcho"]) {
+			val = noEchoValue
+		}
+		masked[name] = val
+	}
+	return masked
+}
+
+// setId ids sort by creation time, and end with the start of the content hash
+func (s *Snapshot) setId(created time.Time) {
+	hash := contentHash(s.Template, s.Parameters, s.Tags, nil)
+	s.Created = created
+	s.Id = created.Format("20060102T150405Z") + "-" + hash[:8]
+}
+
+// SnapshotStore is where the deploy snapshots are kept, the deploy_history store of the stacks yaml:
+// s3://bucket/prefix or a directory, by default snapshots in the state dir
+func (c *StacksConfig) SnapshotStore() (store.Store, error) {
+	location, _ := c.ProcessValue(jsonptr.Get(c.Yaml, "/deploy_history/store")).(string)
+	if len(location) == 0 {
+		location = filepath.Join(c.StateDir(), "snapshots")
+	}
+	return store.New(location, filepath.Dir(c.FileName))
+}
+
+func snapshotPrefix(env, label string) string {
+	return env + "/" + label + "/"
+}
+
+func SaveSnapshot(st store.Store, s *Snapshot) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return st.Put(snapshotPrefix(s.Env, s.Label)+s.Id+".json", b)
+}
+
+// SnapshotIds are the snapshot ids of a stack, newest first
+func SnapshotIds(st store.Store, env, label string) ([]string, error) {
+	keys, err := st.List(snapshotPrefix(env, label))
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, k := range keys {
+		ids = append(ids, strings.TrimSuffix(path.Base(k), ".json"))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids, nil
+}
+
+func LoadSnapshot(st store.Store, env, label, id string) (*Snapshot, error) {
+	b, err := st.Get(snapshotPrefix(env, label) + id + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("cannot load snapshot: %s of stack: %s.%s %v", id, env, label, err)
+	}
+	s := &Snapshot{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("cannot load snapshot: %s of stack: %s.%s %v", id, env, label, err)
+	}
+	return s, nil
+}
+
+// FindSnapshot finds the snapshot of a stack by the number of deploys back (0 is the latest),
+// an id or a unique id prefix
+func FindSnapshot(st store.Store, env, label, to string) (*Snapshot, error) {
+	ids, err := SnapshotIds(st, env, label)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := strconv.Atoi(to); err == nil {
+		if n < 0 || n >= len(ids) {
+			return nil, fmt.Errorf("stack: %s.%s has %d snapshots, cannot go back: %d", env, label, len(ids), n)
+		}
+		return LoadSnapshot(st, env, label, ids[n])
+	}
+
+	matches := []string{}
+	for _, id := range ids {
+		if strings.HasPrefix(id, to) {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("snapshot: %s of stack: %s.%s not found", to, env, label)
+	case 1:
+		return LoadSnapshot(st, env, label, matches[0])
+	default:
+		return nil, fmt.Errorf("snapshot: %s of stack: %s.%s is ambiguous: %v", to, env, label, matches)
+	}
+}
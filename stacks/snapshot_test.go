@@ -0,0 +1,95 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/capitalone/stack-deployment-tool/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskNoEcho(t *testing.T) {
+	template := "Parameters:\n  Password:\n    Type: String\n    NoEcho: true\n  Port:\n    Type: Number\n"
+	params := map[string]interface{}{"Password": "secret", "Port": "80"}
+	assert.Equal(t, map[string]interface{}{"Password": noEchoValue, "Port": "80"}, maskNoEcho(template, params))
+	assert.Equal(t, "secret", params["Password"])
+	assert.Equal(t, map[string]interface{}{"Password": noEchoValue, "Port": noEchoValue}, maskNoEcho("Parameters: [", params))
+}
+
+func TestSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	st := store.NewFileStore(dir)
+
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	stack := c.FetchEnvStacks("qa3.nagios-elb").Stack("nagios-elb")
+
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i, port := range []string{"80", "81", "82"} {
+		s := newSnapshot(stack, "{}", map[string]interface{}{"Port": port}, map[string]interface{}{})
+		s.setId(start.Add(time.Duration(i) * time.Hour))
+		assert.Nil(t, SaveSnapshot(st, s))
+	}
+
+	ids, err := SnapshotIds(st, "qa3", "nagios-elb")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 3)
+	assert.Contains(t, ids[0], "20261001T140000Z-")
+
+	latest, err := FindSnapshot(st, "qa3", "nagios-elb", "0")
+	assert.Nil(t, err)
+	assert.Equal(t, "82", latest.Parameters["Port"])
+	assert.Equal(t, "nagios-elb-qa3", latest.StackName)
+
+	previous, err := FindSnapshot(st, "qa3", "nagios-elb", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "81", previous.Parameters["Port"])
+
+	byId, err := FindSnapshot(st, "qa3", "nagios-elb", ids[2])
+	assert.Nil(t, err)
+	assert.Equal(t, "80", byId.Parameters["Port"])
+
+	byPrefix, err := FindSnapshot(st, "qa3", "nagios-elb", "20261001T13")
+	assert.Nil(t, err)
+	assert.Equal(t, ids[1], byPrefix.Id)
+
+	_, err = FindSnapshot(st, "qa3", "nagios-elb", "20261001T1")
+	assert.Contains(t, err.Error(), "ambiguous")
+	_, err = FindSnapshot(st, "qa3", "nagios-elb", "3")
+	assert.NotNil(t, err)
+	_, err = FindSnapshot(st, "qa3", "nagios-server", "0")
+	assert.NotNil(t, err)
+}
+
+func TestSnapshotStore(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	st, err := c.SnapshotStore()
+	assert.Nil(t, err)
+	assert.Equal(t, "../resources/.sdt/snapshots", st.String())
+
+	c.Yaml["deploy_history"] = map[string]interface{}{"store": "s3://deploys/sdt"}
+	st, err = c.SnapshotStore()
+	assert.Nil(t, err)
+	assert.Equal(t, "s3://deploys/sdt", st.String())
+}
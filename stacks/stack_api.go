@@ -19,18 +19,40 @@ package stacks
 
 import (
 	"strings"
+	"time"
 
 	"github.com/capitalone/stack-deployment-tool/providers"
 )
 
 type StackApi interface {
 	DeploymentOutputFinder
+	ExportFinder
+	ProvisionedProductOutputFinder
+	CrossAccountOutputFinder
+	StackStatusFinder
 	CreateOrUpdateStacks(envStacks *EnvStacksConfig)
 	DeleteStacks(envStacks *EnvStacksConfig)
 	StacksStatus(envStacks *EnvStacksConfig)
 	PrintChangesToStacks(envStacks *EnvStacksConfig)
+	RollbackStack(envStacks *EnvStacksConfig, to string)
+	PackageStacks(envStacks *EnvStacksConfig, outDir string)
+	DiffStacks(envStacks *EnvStacksConfig, color bool)
+	RenderStacks(envStacks *EnvStacksConfig, outDir string) (*RenderManifest, error)
 
 	DryMode(enable bool)
+	DeployOptions(opts DeployOptions)
+}
+
+// DeployOptions change how CreateOrUpdateStacks deploys the stacks
+type DeployOptions struct {
+	Force  bool // deploy stacks even when the content hash is unchanged
+	Resume bool // continue the last failed deploy from its checkpoint
+	Atomic bool // on failure restore the stacks deployed by the run, and delete the created ones
+
+	WaitForLock time.Duration // how long to wait for a locked environment, also when deleting
+	Ticket      string        // change ticket of the deploy, delete or rollback, see Protection
+
+	StackPolicyDuringUpdate string // stack policy file or document replacing the stack policy during updates
 }
 
 func DefaultStackApi() StackApi {
@@ -56,6 +78,22 @@ func (p *ScriptRunnerStackProxy) FindDeploymentOutput(stackName string, outputKe
 	return p.api.FindDeploymentOutput(stackName, outputKey)
 }
 
+func (p *ScriptRunnerStackProxy) FindExport(exportName string) (string, error) {
+	return p.api.FindExport(exportName)
+}
+
+func (p *ScriptRunnerStackProxy) FindProvisionedProductOutput(productName string, outputKey string) (string, error) {
+	return p.api.FindProvisionedProductOutput(productName, outputKey)
+}
+
+func (p *ScriptRunnerStackProxy) FindDeploymentOutputInAccount(account, region, role, stackName, outputKey string) (string, error) {
+	return p.api.FindDeploymentOutputInAccount(account, region, role, stackName, outputKey)
+}
+
+func (p *ScriptRunnerStackProxy) FindStackStatus(stackName string) string {
+	return p.api.FindStackStatus(stackName)
+}
+
 func (p *ScriptRunnerStackProxy) CreateOrUpdateStacks(envStacks *EnvStacksConfig) {
 	p.api.CreateOrUpdateStacks(envStacks)
 }
@@ -70,6 +108,26 @@ func (p *ScriptRunnerStackProxy) PrintChangesToStacks(envStacks *EnvStacksConfig
 	p.api.PrintChangesToStacks(envStacks)
 }
 
+func (p *ScriptRunnerStackProxy) RollbackStack(envStacks *EnvStacksConfig, to string) {
+	p.api.RollbackStack(envStacks, to)
+}
+
+func (p *ScriptRunnerStackProxy) PackageStacks(envStacks *EnvStacksConfig, outDir string) {
+	p.api.PackageStacks(envStacks, outDir)
+}
+
+func (p *ScriptRunnerStackProxy) DiffStacks(envStacks *EnvStacksConfig, color bool) {
+	p.api.DiffStacks(envStacks, color)
+}
+
+func (p *ScriptRunnerStackProxy) RenderStacks(envStacks *EnvStacksConfig, outDir string) (*RenderManifest, error) {
+	return p.api.RenderStacks(envStacks, outDir)
+}
+
 func (p *ScriptRunnerStackProxy) DryMode(enable bool) {
 	p.api.DryMode(enable)
 }
+
+func (p *ScriptRunnerStackProxy) DeployOptions(opts DeployOptions) {
+	p.api.DeployOptions(opts)
+}
@@ -0,0 +1,253 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const (
+	// restored after a stack policy override when the stack had no policy
+	allowAllStackPolicy = `{"Statement":[{"Effect":"Allow","Action":"Update:*","Principal":"*","Resource":"*"}]}`
+
+	maxRollbackTriggers  = 5
+	maxMonitoringMinutes = 180
+	alarmTriggerType     = "AWS::CloudWatch::Alarm"
+)
+
+// stackSettings are the settings of a stack besides its template, parameters and tags
+type stackSettings struct {
+	StackPolicy           string `json:"stack_policy,omitempty"`
+	TerminationProtection *bool  `json:"termination_protection,omitempty"` // nil leaves it unchanged
+
+	// nil leave the rollback configuration of the stack unchanged
+	RollbackTriggers  []alarmTrigger `json:"rollback_triggers,omitempty"`
+	MonitoringMinutes *int64         `json:"monitoring_minutes,omitempty"`
+}
+
+// alarmTrigger is a CloudWatch alarm that rolls back the stack when it goes to ALARM
+type alarmTrigger struct {
+	Arn  string `json:"arn"`
+	Type string `json:"type"`
+}
+
+// stackSettings loads the stack_policy, termination_protection, rollback_triggers and monitoring_minutes of the stack
+func (a *AWSStackApi) stackSettings(stack *StackConfig) (*stackSettings, error) {
+	policy, err := a.loadStackPolicy(stack.Fetch("stack_policy"), filepath.Dir(stack.Config.FileName))
+	if err != nil {
+		return nil, fmt.Errorf("stack: %s %v", stack.Name(), err)
+	}
+	settings := &stackSettings{StackPolicy: policy}
+	if val := stack.Fetch("termination_protection"); val != nil {
+		settings.TerminationProtection = aws.Bool(isTrue(val))
+	}
+	if err := settings.loadRollbackConfiguration(stack.Fetch("rollback_triggers"), stack.Fetch("monitoring_minutes")); err != nil {
+		return nil, fmt.Errorf("stack: %s %v", stack.Name(), err)
+	}
+	return settings, nil
+}
+
+// loadRollbackConfiguration loads the alarm arns, or {arn: , type: } alarms, and the minutes they are monitored after a deploy
+func (s *stackSettings) loadRollbackConfiguration(triggers interface{}, minutes interface{}) error {
+	if triggers != nil {
+		list, ok := triggers.([]interface{})
+		if !ok {
+			list = []interface{}{triggers}
+		}
+		s.RollbackTriggers = []alarmTrigger{}
+		for _, t := range list {
+			trigger := alarmTrigger{Type: alarmTriggerType}
+			switch v := t.(type) {
+			case string:
+				trigger.Arn = v
+			case map[string]interface{}:
+				trigger.Arn, _ = v["arn"].(string)
+				if typ, ok := v["type"].(string); ok {
+					trigger.Type = typ
+				}
+			}
+			if !strings.HasPrefix(trigger.Arn, "arn:") {
+				return fmt.Errorf("invalid rollback trigger: %v, expected an alarm arn", t)
+			}
+			s.RollbackTriggers = append(s.RollbackTriggers, trigger)
+		}
+		if len(s.RollbackTriggers) > maxRollbackTriggers {
+			return fmt.Errorf("%d rollback triggers, at most %d", len(s.RollbackTriggers), maxRollbackTriggers)
+		}
+	}
+	if minutes != nil {
+		m, err := strconv.ParseInt(fmt.Sprint(minutes), 10, 64)
+		if err != nil || m < 0 || m > maxMonitoringMinutes {
+			return fmt.Errorf("invalid monitoring_minutes: %v, expected 0 to %d", minutes, maxMonitoringMinutes)
+		}
+		s.MonitoringMinutes = aws.Int64(m)
+	}
+	return nil
+}
+
+// rollbackConfiguration is the rollback configuration for the api, nil when it is not configured
+func (s *stackSettings) rollbackConfiguration() *rollbackConfiguration {
+	if s == nil || (s.RollbackTriggers == nil && s.MonitoringMinutes == nil) {
+		return nil
+	}
+	config := &rollbackConfiguration{MonitoringTimeInMinutes: s.MonitoringMinutes}
+	if s.RollbackTriggers != nil {
+		config.RollbackTriggers = []*rollbackTrigger{}
+		for _, t := range s.RollbackTriggers {
+			config.RollbackTriggers = append(config.RollbackTriggers, &rollbackTrigger{Arn: aws.String(t.Arn), Type: aws.String(t.Type)})
+		}
+	}
+	return config
+}
+
+// monitoring is how long the rollback triggers are monitored after the resources are deployed
+func (s *stackSettings) monitoring() time.Duration {
+	if s == nil || s.MonitoringMinutes == nil {
+		return 0
+	}
+	return time.Duration(*s.MonitoringMinutes) * time.Minute
+}
+
+// loadStackPolicy is the policy json of an inline document, or of a policy file rendered like templates
+func (a *AWSStackApi) loadStackPolicy(val interface{}, dir string) (string, error) {
+	switch policy := val.(type) {
+	case nil:
+		return "", nil
+	case map[string]interface{}:
+		return string(utils.EncodeJSON(policy)), nil
+	case string:
+		if len(strings.TrimSpace(policy)) == 0 {
+			return "", nil
+		}
+		if strings.HasPrefix(strings.TrimSpace(policy), "{") {
+			return string(utils.GenerateJSONFromYaml([]byte(policy))), nil
+		}
+		if !filepath.IsAbs(policy) {
+			policy = filepath.Join(dir, policy)
+		}
+		if len(findTemplateFile(policy)) == 0 {
+			return "", fmt.Errorf("stack policy not found: %s", policy)
+		}
+		// yaml templates are kept as yaml, policies are json
+		return string(utils.GenerateJSONFromYaml([]byte(loadTemplate(policy)))), nil
+	}
+	return "", fmt.Errorf("invalid stack policy: %v, expected a document or a file", val)
+}
+
+func (s *stackSettings) String() string {
+	if s == nil || (len(s.StackPolicy) == 0 && s.TerminationProtection == nil && s.rollbackConfiguration() == nil) {
+		return ""
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// applyStackSettings sets the stack policy and termination protection of an existing stack
+func (a *AWSStackApi) applyStackSettings(stackName string, settings *stackSettings) error {
+	if settings == nil || a.IsDryMode() {
+		return nil
+	}
+	if len(settings.StackPolicy) > 0 {
+		log.Infof("Setting stack policy of: %s", stackName)
+		if err := a.setStackPolicy(stackName, settings.StackPolicy); err != nil {
+			return err
+		}
+	}
+	if settings.TerminationProtection != nil {
+		log.Infof("Setting termination protection of: %s to: %t", stackName, *settings.TerminationProtection)
+		err := a.cfRequest("UpdateTerminationProtection", &updateTerminationProtectionInput{
+			EnableTerminationProtection: settings.TerminationProtection,
+			StackName:                   aws.String(stackName),
+		}, &updateTerminationProtectionOutput{})
+		if err != nil {
+			return fmt.Errorf("Error setting termination protection of: %s %v", stackName, err)
+		}
+	}
+	return nil
+}
+
+func (a *AWSStackApi) setStackPolicy(stackName string, policy string) error {
+	_, err := a.CFService().SetStackPolicy(&cloudformation.SetStackPolicyInput{
+		StackName:       aws.String(stackName),
+		StackPolicyBody: aws.String(policy),
+	})
+	if err != nil {
+		return fmt.Errorf("Error setting stack policy of: %s %v", stackName, err)
+	}
+	return nil
+}
+
+// overrideStackPolicy sets the --stack-policy-during-update policy for the update,
+// and returns the policy to restore after it, empty without an override
+func (a *AWSStackApi) overrideStackPolicy(stackName string, settings *stackSettings) (string, error) {
+	if len(a.options.StackPolicyDuringUpdate) == 0 || a.IsDryMode() {
+		return "", nil
+	}
+	override, err := a.loadStackPolicy(a.options.StackPolicyDuringUpdate, ".")
+	if err != nil {
+		return "", err
+	}
+	restore := ""
+	if settings != nil {
+		restore = settings.StackPolicy
+	}
+	if len(restore) == 0 {
+		resp, err := a.CFService().GetStackPolicy(&cloudformation.GetStackPolicyInput{StackName: aws.String(stackName)})
+		if err != nil {
+			return "", fmt.Errorf("Error getting stack policy of: %s %v", stackName, err)
+		}
+		restore = aws.StringValue(resp.StackPolicyBody)
+	}
+	if len(restore) == 0 {
+		restore = allowAllStackPolicy
+	}
+	log.Warnf("Overriding the stack policy of: %s during the update", stackName)
+	return restore, a.setStackPolicy(stackName, override)
+}
+
+// terminationProtected are the stacks with termination protection enabled
+func (a *AWSStackApi) terminationProtected(stackNames []string) ([]string, error) {
+	protected := []string{}
+	for _, name := range stackNames {
+		resp := &describeStacksOutput{}
+		err := a.cfRequest("DescribeStacks", &cloudformation.DescribeStacksInput{StackName: aws.String(name)}, resp)
+		if err != nil {
+			if strings.Contains(err.Error(), "does not exist") {
+				continue
+			}
+			return nil, err
+		}
+		for _, s := range resp.Stacks {
+			if aws.BoolValue(s.EnableTerminationProtection) {
+				protected = append(protected, name)
+			}
+		}
+	}
+	return protected, nil
+}
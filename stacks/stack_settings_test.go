@@ -0,0 +1,119 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStackSettings(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	qa4 := c.FetchEnvStacks("qa4")
+	a := &AWSStackApi{}
+
+	elb, err := a.stackSettings(qa4.Stack("nagios-elb"))
+	assert.Nil(t, err)
+	assert.True(t, *elb.TerminationProtection)
+	assert.JSONEq(t, `{"Statement": [
+		{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*"},
+		{"Effect": "Deny", "Action": ["Update:Replace", "Update:Delete"], "Principal": "*", "Resource": "LogicalResourceId/LoadBalancer"}]}`,
+		elb.StackPolicy)
+
+	assert.Equal(t, []alarmTrigger{
+		{Arn: "arn:aws:cloudwatch:us-east-1:000000000000:alarm:nagios-elb-qa4-5xx", Type: "AWS::CloudWatch::Alarm"},
+		{Arn: "arn:aws:cloudwatch:us-east-1:000000000000:alarm:nagios-qa4-health", Type: "AWS::CloudWatch::CompositeAlarm"},
+	}, elb.RollbackTriggers)
+	assert.Equal(t, 10*time.Minute, elb.monitoring())
+	config := elb.rollbackConfiguration()
+	assert.Equal(t, int64(10), *config.MonitoringTimeInMinutes)
+	assert.Len(t, config.RollbackTriggers, 2)
+	assert.Equal(t, "AWS::CloudWatch::CompositeAlarm", *config.RollbackTriggers[1].Type)
+
+	server, err := a.stackSettings(qa4.Stack("nagios-server"))
+	assert.Nil(t, err)
+	assert.False(t, *server.TerminationProtection)
+	assert.JSONEq(t, allowAllStackPolicy, server.StackPolicy)
+
+	none, err := a.stackSettings(c.FetchEnvStacks("qa3").Stack("nagios-elb"))
+	assert.Nil(t, err)
+	assert.Nil(t, none.TerminationProtection)
+	assert.Equal(t, "", none.String())
+	assert.Equal(t, `{"termination_protection":true}`, (&stackSettings{TerminationProtection: aws.Bool(true)}).String())
+	assert.Nil(t, none.rollbackConfiguration())
+	assert.Equal(t, time.Duration(0), none.monitoring())
+	var unset *stackSettings
+	assert.Nil(t, unset.rollbackConfiguration())
+}
+
+func TestLoadRollbackConfiguration(t *testing.T) {
+	s := &stackSettings{}
+	assert.Nil(t, s.loadRollbackConfiguration("arn:aws:cloudwatch:us-east-1:000000000000:alarm:5xx", nil))
+	assert.Len(t, s.RollbackTriggers, 1)
+	assert.Nil(t, s.MonitoringMinutes)
+
+	// an empty list removes the triggers of the stack
+	s = &stackSettings{}
+	assert.Nil(t, s.loadRollbackConfiguration([]interface{}{}, 0))
+	config := s.rollbackConfiguration()
+	assert.NotNil(t, config.RollbackTriggers)
+	assert.Empty(t, config.RollbackTriggers)
+	assert.Equal(t, int64(0), *config.MonitoringTimeInMinutes)
+
+	assert.NotNil(t, (&stackSettings{}).loadRollbackConfiguration("my-alarm", nil))
+	assert.NotNil(t, (&stackSettings{}).loadRollbackConfiguration(nil, 181))
+	assert.NotNil(t, (&stackSettings{}).loadRollbackConfiguration(nil, "ten"))
+	six := []interface{}{}
+	for i := 0; i < 6; i++ {
+		six = append(six, "arn:aws:cloudwatch:us-east-1:000000000000:alarm:a")
+	}
+	assert.NotNil(t, (&stackSettings{}).loadRollbackConfiguration(six, nil))
+}
+
+func TestLoadStackPolicy(t *testing.T) {
+	a := &AWSStackApi{}
+	policy, err := a.loadStackPolicy(` {"Statement": [{"Effect": "Deny", "Action": "Update:*", "Principal": "*", "Resource": "*"}]}`, ".")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"Statement": [{"Effect": "Deny", "Action": "Update:*", "Principal": "*", "Resource": "*"}]}`, policy)
+
+	policy, err = a.loadStackPolicy("", ".")
+	assert.Nil(t, err)
+	assert.Equal(t, "", policy)
+
+	_, err = a.loadStackPolicy("missing_policy.json", "../resources")
+	assert.NotNil(t, err)
+	_, err = a.loadStackPolicy([]interface{}{"Deny"}, ".")
+	assert.NotNil(t, err)
+}
+
+func TestIsEmptyChangeSet(t *testing.T) {
+	assert.False(t, isEmptyChangeSet(nil))
+	assert.False(t, isEmptyChangeSet(&cloudformation.DescribeChangeSetOutput{Status: aws.String("CREATE_COMPLETE")}))
+	assert.True(t, isEmptyChangeSet(&cloudformation.DescribeChangeSetOutput{
+		Status:       aws.String("FAILED"),
+		StatusReason: aws.String("The submitted information didn't contain changes. Submit different information to create a change set."),
+	}))
+	assert.False(t, isEmptyChangeSet(&cloudformation.DescribeChangeSetOutput{
+		Status:       aws.String("FAILED"),
+		StatusReason: aws.String("Template format error"),
+	}))
+}
@@ -49,6 +49,10 @@ var (
 //   optional default value for the env value, for example:
 //   CreatedByURL: '{{env.BUILD_URL default="NA"}}'
 // output stack=<stack name> key=<output key to pull value from>  - use the output value from one stack
+//   optional account=<account id> region=<region> role=<role name> to look up a stack in another account/region
+//   or label=<env>.<stack label> to reference a stack from the stacks yaml instead of stack=
+// import name=<export name> - use the value of a CloudFormation export
+// sc_output product=<provisioned product name> key=<output key> - use the output value of a Service Catalog provisioned product
 // s3artifact repo=<one of the valid artifact repos, default: sandbox>
 // pipeline_version - PIPELINE_VERSION environment variable
 //                    shortcut for: env.PIPELINE_VERSION default="NA"
@@ -63,6 +67,23 @@ type DeploymentOutputFinder interface {
 	FindDeploymentOutput(stackName string, outputKey string) (string, error)
 }
 
+// StackNameResolver resolves an "env.label" stack reference to the stack name
+type StackNameResolver interface {
+	StackName(qualifiedLabel string) (string, error)
+}
+
+type ExportFinder interface {
+	FindExport(exportName string) (string, error)
+}
+
+type ProvisionedProductOutputFinder interface {
+	FindProvisionedProductOutput(productName string, outputKey string) (string, error)
+}
+
+type CrossAccountOutputFinder interface {
+	FindDeploymentOutputInAccount(account, region, role, stackName, outputKey string) (string, error)
+}
+
 func RegisterTemplateHelper(cmd string, ctx interface{}, helper interface{}) {
 	helpersCtx[cmd] = ctx
 	raymond.RegisterHelper(cmd, helper)
@@ -95,6 +116,8 @@ func (t *Template) Render(input string) string {
 func init() {
 	// can only register these once, and they get all info from the ctx since they are reused
 	raymond.RegisterHelper("output", outputHelper)
+	raymond.RegisterHelper("import", importHelper)
+	raymond.RegisterHelper("sc_output", scOutputHelper)
 	raymond.RegisterHelper("pipeline_version", func(options *raymond.Options) raymond.SafeString {
 		return raymond.SafeString(utils.GetenvWithDefault("PIPELINE_VERSION", "NA"))
 	})
@@ -171,19 +194,43 @@ func envHelper(options *raymond.Options) raymond.SafeString {
 	return raymond.SafeString(utils.GetenvWithDefault(key, defaultVal))
 }
 
-func outputHelper(options *raymond.Options) raymond.SafeString {
-	stackNameTempl := options.HashStr("stack")
-	key := options.HashStr("key")
-	stackName, err := raymond.MustParse(stackNameTempl).Exec(options.Ctx())
+// hashTemplStr renders a hash argument, which may contain a nested template
+func hashTemplStr(options *raymond.Options, name string) string {
+	templ := options.HashStr(name)
+	if len(templ) == 0 {
+		return templ
+	}
+	val, err := raymond.MustParse(templ).Exec(options.Ctx())
 	if err != nil {
-		log.Fatalf("Error parsing: %s\n", stackNameTempl)
+		log.Fatalf("Error parsing: %s\n", templ)
+	}
+	return val
+}
+
+func outputHelper(options *raymond.Options) raymond.SafeString {
+	stackName := hashTemplStr(options, "stack")
+	if label := hashTemplStr(options, "label"); len(label) > 0 {
+		stackName = resolveStackLabel(CtxTemplate(options).YamlFetcher, label)
 	}
+	key := options.HashStr("key")
+	account := hashTemplStr(options, "account")
+	region := hashTemplStr(options, "region")
 	log.Debugf("looking for %s %s\n", stackName, key)
 	// find the Stack output
 	outputFinder := CtxTemplate(options).OutputFinder
 	log.Debugf("outputFinder: %#v", outputFinder)
 	if outputFinder != nil {
-		val, err := outputFinder.FindDeploymentOutput(stackName, key)
+		var val string
+		var err error
+		if len(account) > 0 || len(region) > 0 {
+			crossFinder, ok := outputFinder.(CrossAccountOutputFinder)
+			if !ok {
+				log.Fatalf("Cross account output lookups are not supported by: %T\n", outputFinder)
+			}
+			val, err = crossFinder.FindDeploymentOutputInAccount(account, region, hashTemplStr(options, "role"), stackName, key)
+		} else {
+			val, err = outputFinder.FindDeploymentOutput(stackName, key)
+		}
 		if err != nil {
 			log.Fatalf("Error finding stack output: %s\n", key)
 		}
@@ -196,6 +243,48 @@ func outputHelper(options *raymond.Options) raymond.SafeString {
 	return raymond.SafeString("")
 }
 
+// resolveStackLabel finds the stack name of an "env.label" stack reference
+func resolveStackLabel(yamlFetcher Fetcher, label string) string {
+	resolver, ok := yamlFetcher.(StackNameResolver)
+	if !ok {
+		log.Fatalf("Stack label lookups are not supported by: %T\n", yamlFetcher)
+	}
+	stackName, err := resolver.StackName(label)
+	if err != nil {
+		log.Fatalf("Error finding stack: %v\n", err)
+	}
+	return stackName
+}
+
+func importHelper(options *raymond.Options) raymond.SafeString {
+	exportName := hashTemplStr(options, "name")
+	log.Debugf("looking for export %s\n", exportName)
+	if exportFinder, ok := CtxTemplate(options).OutputFinder.(ExportFinder); ok {
+		val, err := exportFinder.FindExport(exportName)
+		if err != nil {
+			log.Fatalf("Error finding export: %s %v\n", exportName, err)
+		}
+		log.Debugf("found export %s = %s\n", exportName, val)
+		return raymond.SafeString(val)
+	}
+	return raymond.SafeString("")
+}
+
+func scOutputHelper(options *raymond.Options) raymond.SafeString {
+	productName := hashTemplStr(options, "product")
+	key := options.HashStr("key")
+	log.Debugf("looking for provisioned product %s %s\n", productName, key)
+	if productFinder, ok := CtxTemplate(options).OutputFinder.(ProvisionedProductOutputFinder); ok {
+		val, err := productFinder.FindProvisionedProductOutput(productName, key)
+		if err != nil {
+			log.Fatalf("Error finding provisioned product output: %s %s %v\n", productName, key, err)
+		}
+		log.Debugf("found %s %s = %s\n", productName, key, val)
+		return raymond.SafeString(val)
+	}
+	return raymond.SafeString("")
+}
+
 func EnvKeys() (result []string) {
 	e := os.Environ() // "key=value"
 	for _, ev := range e {
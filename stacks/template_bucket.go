@@ -0,0 +1,207 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	jsonptr "github.com/dustin/go-jsonpointer"
+)
+
+const (
+	// largest TemplateBody, larger templates are uploaded and passed as a TemplateURL
+	maxTemplateBodySize = 51200
+	maxTemplateURLSize  = 1048576
+
+	DefaultTemplateKey = "sdt/templates/{{stack_name}}/{{content_hash}}.template"
+)
+
+// TemplateBucket is where templates too large for a TemplateBody are uploaded, the template_bucket of the stacks yaml:
+//
+//   template_bucket:
+//     bucket: my-bucket
+//     key: 'sdt/templates/{{stack_name}}/{{content_hash}}.template' # optional
+//     keep: 5 # optional, the newest templates kept per stack
+type TemplateBucket struct {
+	Bucket string
+	Key    string // {{stack_name}} and {{content_hash}} are replaced
+	Keep   int    // older templates of the stack are deleted after a deploy, 0 keeps them all
+}
+
+// TemplateBucket is the template bucket of the stacks yaml, nil when there is none
+func (c *StacksConfig) TemplateBucket() (*TemplateBucket, error) {
+	val := jsonptr.Get(c.Yaml, "/template_bucket")
+	if val == nil {
+		return nil, nil
+	}
+	b := &TemplateBucket{Key: DefaultTemplateKey}
+	if bucket, ok := val.(string); ok {
+		b.Bucket = c.ProcessValue(bucket).(string)
+		return b, nil
+	}
+	yaml := utils.ToStrMap(val)
+	// the key is not rendered, its placeholders are replaced for each template
+	if key, ok := yaml["key"].(string); ok && len(key) > 0 {
+		b.Key = key
+	}
+	b.Bucket, _ = c.ProcessValue(yaml["bucket"]).(string)
+	if len(b.Bucket) == 0 {
+		return nil, fmt.Errorf("template_bucket bucket missing")
+	}
+	if keep := yaml["keep"]; keep != nil {
+		n, err := strconv.Atoi(fmt.Sprint(c.ProcessValue(keep)))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid template_bucket keep: %v", keep)
+		}
+		b.Keep = n
+	}
+	return b, nil
+}
+
+// ObjectKey is the key of a template of the stack
+func (b *TemplateBucket) ObjectKey(stackName string, template string) string {
+	key := strings.Replace(b.Key, "{{stack_name}}", stackName, -1)
+	return strings.Replace(key, "{{content_hash}}", fmt.Sprintf("%x", sha256.Sum256([]byte(template))), -1)
+}
+
+// stackPrefix is the key prefix of the templates of the stack, empty when the key doesnt end with the hash
+func (b *TemplateBucket) stackPrefix(stackName string) string {
+	key := strings.Replace(b.Key, "{{stack_name}}", stackName, -1)
+	i := strings.Index(key, "{{content_hash}}")
+	if i <= 0 || !strings.Contains(key[:i], stackName) {
+		return ""
+	}
+	return key[:i]
+}
+
+// stackKeyRe matches the keys of the templates of the stack: the key with a sha256 hex in place of {{content_hash}}.
+// Other stacks can share the prefix, cf/{{stack_name}}-{{content_hash}} gives cf/qa-app- to qa-app and qa-app-db.
+func (b *TemplateBucket) stackKeyRe(stackName string) *regexp.Regexp {
+	key := strings.Replace(b.Key, "{{stack_name}}", stackName, -1)
+	parts := strings.SplitN(key, "{{content_hash}}", 2)
+	if len(parts) < 2 {
+		return regexp.MustCompile("^" + regexp.QuoteMeta(key) + "$")
+	}
+	return regexp.MustCompile("^" + regexp.QuoteMeta(parts[0]) + "[0-9a-f]{64}" + regexp.QuoteMeta(parts[1]) + "$")
+}
+
+// useTemplateBucket sets the bucket large templates of the stacks config are uploaded to
+func (a *AWSStackApi) useTemplateBucket(c *StacksConfig) {
+	bucket, err := c.TemplateBucket()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	a.templateBucket = bucket
+}
+
+// templateSource is the TemplateBody of the template, or the TemplateURL of it uploaded to the template bucket when it is too large
+func (a *AWSStackApi) templateSource(stackName string, template string) (body *string, url *string, err error) {
+	if len(template) <= maxTemplateBodySize {
+		return aws.String(template), nil, nil
+	}
+	if len(template) > maxTemplateURLSize {
+		return nil, nil, fmt.Errorf("template of: %s is %d bytes, over the %d byte limit", stackName, len(template), maxTemplateURLSize)
+	}
+	if a.templateBucket == nil {
+		return nil, nil, fmt.Errorf("template of: %s is %d bytes, over the %d byte TemplateBody limit, configure a template_bucket to upload it",
+			stackName, len(template), maxTemplateBodySize)
+	}
+
+	key := a.templateBucket.ObjectKey(stackName, template)
+	log.Infof("Uploading the template of: %s (%d bytes) to: s3://%s/%s", stackName, len(template), a.templateBucket.Bucket, key)
+	_, err = a.S3Service().PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(a.templateBucket.Bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader([]byte(template)),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error uploading the template of: %s %v", stackName, err)
+	}
+	return nil, aws.String(templateURL(aws.StringValue(a.Session.Config.Region), a.templateBucket.Bucket, key)), nil
+}
+
+func templateURL(region string, bucket string, key string) string {
+	if len(region) == 0 || region == "us-east-1" {
+		return fmt.Sprintf("https://s3.amazonaws.com/%s/%s", bucket, key)
+	}
+	return fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", region, bucket, key)
+}
+
+// cleanupTemplates deletes the uploaded templates of the stack older than the newest keep ones
+func (a *AWSStackApi) cleanupTemplates(stackName string) {
+	if a.templateBucket == nil || a.templateBucket.Keep == 0 || a.IsDryMode() {
+		return
+	}
+	prefix := a.templateBucket.stackPrefix(stackName)
+	if len(prefix) == 0 {
+		log.Warnf("template_bucket key: %s has no stack prefix before {{content_hash}}, old templates are kept", a.templateBucket.Key)
+		return
+	}
+	keyRe := a.templateBucket.stackKeyRe(stackName)
+	objects := []*s3.Object{}
+	err := a.S3Service().ListObjectsPages(&s3.ListObjectsInput{
+		Bucket: aws.String(a.templateBucket.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, o := range page.Contents {
+			if keyRe.MatchString(aws.StringValue(o.Key)) {
+				objects = append(objects, o)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		log.Warnf("Error listing the templates of: %s %v", stackName, err)
+		return
+	}
+	old := oldTemplates(objects, a.templateBucket.Keep)
+	for _, batch := range deleteBatches(old) {
+		log.Infof("Deleting %d old templates of: %s", len(batch), stackName)
+		_, err := a.S3Service().DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(a.templateBucket.Bucket),
+			Delete: &s3.Delete{Objects: batch, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			log.Warnf("Error deleting the old templates of: %s %v", stackName, err)
+		}
+	}
+}
+
+// oldTemplates are the objects older than the newest keep ones
+func oldTemplates(objects []*s3.Object, keep int) []*s3.ObjectIdentifier {
+	sort.Slice(objects, func(i, j int) bool {
+		return aws.TimeValue(objects[i].LastModified).After(aws.TimeValue(objects[j].LastModified))
+	})
+	old := []*s3.ObjectIdentifier{}
+	for i := keep; i < len(objects); i++ {
+		old = append(old, &s3.ObjectIdentifier{Key: objects[i].Key})
+	}
+	return old
+}
@@ -0,0 +1,101 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateBucketConfig(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	b, err := c.TemplateBucket()
+	assert.Nil(t, err)
+	assert.Nil(t, b)
+
+	c.Yaml["template_bucket"] = "my-templates"
+	b, err = c.TemplateBucket()
+	assert.Nil(t, err)
+	assert.Equal(t, &TemplateBucket{Bucket: "my-templates", Key: DefaultTemplateKey}, b)
+
+	c.Yaml["template_bucket"] = map[string]interface{}{"bucket": "my-templates", "key": "cf/{{stack_name}}-{{content_hash}}", "keep": 3}
+	b, err = c.TemplateBucket()
+	assert.Nil(t, err)
+	assert.Equal(t, &TemplateBucket{Bucket: "my-templates", Key: "cf/{{stack_name}}-{{content_hash}}", Keep: 3}, b)
+
+	c.Yaml["template_bucket"] = map[string]interface{}{"key": "cf/{{content_hash}}"}
+	_, err = c.TemplateBucket()
+	assert.NotNil(t, err)
+
+	c.Yaml["template_bucket"] = map[string]interface{}{"bucket": "my-templates", "keep": "all"}
+	_, err = c.TemplateBucket()
+	assert.NotNil(t, err)
+}
+
+func TestTemplateBucketKeys(t *testing.T) {
+	b := &TemplateBucket{Bucket: "my-templates", Key: DefaultTemplateKey}
+	key := b.ObjectKey("qa-app", "{}")
+	assert.Equal(t, "sdt/templates/qa-app/44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a.template", key)
+	assert.Equal(t, "sdt/templates/qa-app/", b.stackPrefix("qa-app"))
+
+	assert.True(t, b.stackKeyRe("qa-app").MatchString(key))
+
+	b.Key = "cf/{{stack_name}}-{{content_hash}}"
+	assert.Equal(t, "cf/qa-app-", b.stackPrefix("qa-app"))
+	re := b.stackKeyRe("qa-app")
+	assert.True(t, re.MatchString(b.ObjectKey("qa-app", "{}")))
+	assert.False(t, re.MatchString(b.ObjectKey("qa-app-db", "{}")))
+	assert.False(t, re.MatchString("cf/qa-app-notes.txt"))
+
+	b.Key = "cf/{{content_hash}}.template"
+	assert.Equal(t, "", b.stackPrefix("qa-app"))
+
+	assert.Equal(t, "https://s3.amazonaws.com/my-templates/k", templateURL("us-east-1", "my-templates", "k"))
+	assert.Equal(t, "https://s3.eu-west-1.amazonaws.com/my-templates/k", templateURL("eu-west-1", "my-templates", "k"))
+}
+
+func TestOldTemplates(t *testing.T) {
+	now := time.Now()
+	objects := []*s3.Object{
+		{Key: aws.String("b"), LastModified: aws.Time(now.Add(-2 * time.Hour))},
+		{Key: aws.String("a"), LastModified: aws.Time(now)},
+		{Key: aws.String("c"), LastModified: aws.Time(now.Add(-3 * time.Hour))},
+	}
+	old := oldTemplates(objects, 1)
+	assert.Equal(t, 2, len(old))
+	assert.Equal(t, "b", *old[0].Key)
+	assert.Equal(t, "c", *old[1].Key)
+	assert.Equal(t, 0, len(oldTemplates(objects, 5)))
+}
+
+func TestTemplateSource(t *testing.T) {
+	a := &AWSStackApi{}
+	body, url, err := a.templateSource("qa-app", "{}")
+	assert.Nil(t, err)
+	assert.Nil(t, url)
+	assert.Equal(t, "{}", *body)
+
+	_, _, err = a.templateSource("qa-app", strings.Repeat(" ", maxTemplateBodySize+1))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "template_bucket")
+}
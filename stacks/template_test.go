@@ -50,6 +50,31 @@ func (f *FakeDeploymentFinder) FindDeploymentOutput(stackName string, outputKey
 	return "", errors.New("asdf")
 }
 
+type FakeExtendedOutputFinder struct {
+	FakeDeploymentFinder
+	Account     string
+	Region      string
+	ExportName  string
+	ProductName string
+}
+
+func (f *FakeExtendedOutputFinder) FindExport(exportName string) (string, error) {
+	f.ExportName = exportName
+	return "exported", nil
+}
+
+func (f *FakeExtendedOutputFinder) FindProvisionedProductOutput(productName string, outputKey string) (string, error) {
+	f.ProductName = productName
+	f.OutputKey = outputKey
+	return "provisioned", nil
+}
+
+func (f *FakeExtendedOutputFinder) FindDeploymentOutputInAccount(account, region, role, stackName, outputKey string) (string, error) {
+	f.Account = account
+	f.Region = region
+	return f.FindDeploymentOutput(stackName, outputKey)
+}
+
 func TestBasicTemplate(t *testing.T) {
 	tmpl := NewTemplate(&FakeDeploymentFinder{}, nil)
 	home := tmpl.Render("{{ env.HOME }}")
@@ -68,6 +93,48 @@ func TestOutputMacro(t *testing.T) {
 	os.Unsetenv(envKey)
 }
 
+func TestImportMacro(t *testing.T) {
+	fake := &FakeExtendedOutputFinder{}
+	tmpl := NewTemplate(fake, nil)
+	out := tmpl.Render("{{import name=\"shared-vpc-id\"}}")
+
+	assert.Equal(t, "shared-vpc-id", fake.ExportName)
+	assert.Equal(t, "exported", out)
+}
+
+func TestScOutputMacro(t *testing.T) {
+	fake := &FakeExtendedOutputFinder{}
+	tmpl := NewTemplate(fake, nil)
+	out := tmpl.Render("{{sc_output product=\"nagios-logs\" key=\"NagiosLogGroupName\"}}")
+
+	assert.Equal(t, "nagios-logs", fake.ProductName)
+	assert.Equal(t, "NagiosLogGroupName", fake.OutputKey)
+	assert.Equal(t, "provisioned", out)
+}
+
+func TestOutputMacroCrossAccount(t *testing.T) {
+	fake := &FakeExtendedOutputFinder{}
+	tmpl := NewTemplate(fake, nil)
+	out := tmpl.Render("{{output stack=\"shared-vpc\" key=\"VpcId\" account=\"123456789012\" region=\"us-west-2\"}}")
+
+	assert.Equal(t, "123456789012", fake.Account)
+	assert.Equal(t, "us-west-2", fake.Region)
+	assert.Equal(t, "shared-vpc", fake.StackName)
+	assert.Equal(t, "something", out)
+}
+
+func TestOutputMacroStackLabel(t *testing.T) {
+	c := NewConfig(ResourcePath("stacks_dag.yml"), tempOutputFinder())
+	fake := &FakeDeploymentFinder{}
+	c.Templ = NewTemplate(fake, c)
+
+	qa4 := c.FetchEnvStacks("qa4.nagios-elb")
+	params := utils.ToStrMap(qa4.Stack("nagios-elb").Fetch("parameters"))
+	assert.Equal(t, "shared-vpc", fake.StackName)
+	assert.Equal(t, "VpcId", fake.OutputKey)
+	assert.Equal(t, "something", params["VpcId"])
+}
+
 func TestFromYamlTemplate(t *testing.T) {
 	log.SetLevel(log.DebugLevel)
 	c := NewConfig(ResourcePath("from_yaml.yml"), tempOutputFinder())
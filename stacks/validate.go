@@ -0,0 +1,468 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/utils"
+
+	log "github.com/Sirupsen/logrus"
+	jsonptr "github.com/dustin/go-jsonpointer"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	pseudoParameters = []string{"AWS::AccountId", "AWS::NotificationARNs", "AWS::NoValue", "AWS::Partition",
+		"AWS::Region", "AWS::StackId", "AWS::StackName", "AWS::URLSuffix"}
+
+	// ${Name} and ${Resource.Attribute} of Fn::Sub, ${!Literal} is not a reference
+	subVariableRe = regexp.MustCompile(`\$\{([^!}][^}]*)\}`)
+)
+
+// ResourceSpec is a CloudFormation resource specification, e.g.
+// https://d1uauaxba7bl26.cloudfront.net/latest/gzip/CloudFormationResourceSpecification.json
+type ResourceSpec struct {
+	PropertyTypes                map[string]*propertyTypeSpec
+	ResourceTypes                map[string]*resourceTypeSpec
+	ResourceSpecificationVersion string
+}
+
+type resourceTypeSpec struct {
+	Attributes map[string]*propertySpec
+	Properties map[string]*propertySpec
+}
+
+type propertyTypeSpec struct {
+	propertySpec
+	Properties map[string]*propertySpec
+}
+
+type propertySpec struct {
+	PrimitiveType     string
+	PrimitiveItemType string
+	Type              string
+	ItemType          string
+	Required          bool
+}
+
+// LoadResourceSpec loads a resource specification json file, gzipped or not
+func LoadResourceSpec(path string) (*ResourceSpec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading resource spec: %s %v", path, err)
+		}
+		if b, err = ioutil.ReadAll(r); err != nil {
+			return nil, fmt.Errorf("Error reading resource spec: %s %v", path, err)
+		}
+	}
+	spec := &ResourceSpec{}
+	if err = json.Unmarshal(b, spec); err != nil {
+		return nil, fmt.Errorf("Error parsing resource spec: %s %v", path, err)
+	}
+	if len(spec.ResourceTypes) == 0 {
+		return nil, fmt.Errorf("no ResourceTypes in resource spec: %s", path)
+	}
+	return spec, nil
+}
+
+// ResourceSpecPath is the resource_spec file of the stacks yaml, relative to it, empty when there is none
+func (c *StacksConfig) ResourceSpecPath() string {
+	p, _ := c.ProcessValue(jsonptr.Get(c.Yaml, "/resource_spec")).(string)
+	if len(p) == 0 || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(c.FileName), p)
+}
+
+// ValidateStacks validates the rendered templates and the parameters of the stacks, without AWS, and prints the problems
+// and policy findings. The resource types and properties are only checked with a resource spec, policy warnings are not problems.
+func ValidateStacks(envStacks *EnvStacksConfig, spec *ResourceSpec) (problems int) {
+	for _, stackLabel := range envStacks.StackLabels {
+		stack := envStacks.Stack(stackLabel)
+		templateFile := findTemplateFile(stack.TemplatePaths()...)
+		if len(templateFile) == 0 {
+			fmt.Printf("%s: template not found: %v\n", stack.QualifiedLabel(), stack.TemplatePaths())
+			problems++
+			continue
+		}
+		template := loadTemplate(stack.TemplatePaths()...)
+		skip := map[string]bool{}
+		for _, ref := range findLocalRefs(template, filepath.Dir(templateFile)) {
+			skip["Resources/"+ref.Resource+"/Properties/"+ref.Property] = true
+		}
+		params := utils.ToStrMap(utils.ToStrMap(stack.FetchAll())["parameters"])
+		for _, problem := range append(validateTemplate(template, spec, skip), checkParameters(template, params, true)...) {
+			fmt.Printf("%s (%s): %s\n", stack.QualifiedLabel(), templateFile, problem)
+			problems++
+		}
+		log.Infof("Validated: %s (%s)", stack.QualifiedLabel(), templateFile)
+	}
+	findings, err := PolicyFindings(envStacks)
+	if err != nil {
+		fmt.Printf("%s: %v\n", envStacks.Env, err)
+		return problems + 1
+	}
+	return problems + printFindings(findings)
+}
+
+// decodeTemplate decodes a json or yaml template, with the yaml short form intrinsic functions in long form
+func decodeTemplate(template string) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	if strings.HasPrefix(strings.TrimSpace(template), "{") {
+		err := json.Unmarshal([]byte(template), &doc)
+		return doc, err
+	}
+	if err := yaml.Unmarshal([]byte(utils.LongFormIntrinsics(template)), &doc); err != nil {
+		return nil, err
+	}
+	return utils.DeepToStrMap(doc), nil
+}
+
+// validateTemplate are the problems of the template: unknown resource types, unknown or missing required properties,
+// wrong primitive types, and references to undefined names. The skip paths are not checked.
+func validateTemplate(template string, spec *ResourceSpec, skip map[string]bool) []string {
+	doc, err := decodeTemplate(template)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid template: %v", err)}
+	}
+	resources, ok := doc["Resources"].(map[string]interface{})
+	if !ok || len(resources) == 0 {
+		return []string{"no Resources"}
+	}
+	// resources of transforms (AWS::Serverless) are only known after the transform
+	transformed := doc["Transform"] != nil
+
+	v := &templateValidator{spec: spec, skip: skip, resources: map[string]string{}, names: map[string]bool{}}
+	for _, name := range pseudoParameters {
+		v.names[name] = true
+	}
+	for name := range utils.ToStrMap(doc["Parameters"]) {
+		v.names[name] = true
+	}
+	for _, id := range mapKeys(resources) {
+		resource := utils.ToStrMap(resources[id])
+		resourceType, _ := resource["Type"].(string)
+		v.resources[id] = resourceType
+		v.names[id] = true
+		if len(resourceType) == 0 {
+			v.problem("Resources/"+id, "missing Type")
+		} else if spec != nil && !(transformed && strings.HasPrefix(resourceType, "AWS::Serverless::")) {
+			v.checkResource("Resources/"+id, resourceType, resource["Properties"])
+		}
+	}
+	if !transformed {
+		for _, section := range []string{"Conditions", "Resources", "Outputs"} {
+			v.checkReferences(section, doc[section])
+		}
+	}
+	return v.problems
+}
+
+type templateValidator struct {
+	spec      *ResourceSpec
+	skip      map[string]bool
+	resources map[string]string // logical id -> type
+	names     map[string]bool   // Ref-able names
+	problems  []string
+}
+
+func (v *templateValidator) problem(path string, format string, args ...interface{}) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *templateValidator) checkResource(path string, resourceType string, properties interface{}) {
+	if isCustomResource(resourceType) {
+		return
+	}
+	rt, ok := v.spec.ResourceTypes[resourceType]
+	if !ok {
+		v.problem(path, "unknown resource type: %s", resourceType)
+		return
+	}
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	v.checkProperties(path+"/Properties", resourceType, rt.Properties, properties)
+}
+
+func isCustomResource(resourceType string) bool {
+	return strings.HasPrefix(resourceType, "Custom::") || resourceType == "AWS::CloudFormation::CustomResource"
+}
+
+func (v *templateValidator) checkProperties(path string, resourceType string, specs map[string]*propertySpec, value interface{}) {
+	if isIntrinsic(value) {
+		return
+	}
+	properties, ok := value.(map[string]interface{})
+	if !ok {
+		v.problem(path, "expected properties, got: %v", value)
+		return
+	}
+	for _, name := range mapKeys(properties) {
+		ps, ok := specs[name]
+		if !ok {
+			v.problem(path, "unknown property: %s", name)
+			continue
+		}
+		v.checkValue(path+"/"+name, resourceType, ps, properties[name])
+	}
+	for _, name := range mapKeys(specs) {
+		if _, found := properties[name]; specs[name].Required && !found {
+			v.problem(path, "missing required property: %s", name)
+		}
+	}
+}
+
+func (v *templateValidator) checkValue(path string, resourceType string, ps *propertySpec, value interface{}) {
+	if v.skip[path] || isIntrinsic(value) {
+		return
+	}
+	switch {
+	case len(ps.PrimitiveType) > 0:
+		if !isPrimitive(ps.PrimitiveType, value) {
+			v.problem(path, "expected %s, got: %v", ps.PrimitiveType, value)
+		}
+	case ps.Type == "List":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.problem(path, "expected a list, got: %v", value)
+			return
+		}
+		for i, item := range items {
+			v.checkItem(fmt.Sprintf("%s/%d", path, i), resourceType, ps, item)
+		}
+	case ps.Type == "Map":
+		items, ok := value.(map[string]interface{})
+		if !ok {
+			v.problem(path, "expected a map, got: %v", value)
+			return
+		}
+		for _, k := range mapKeys(items) {
+			v.checkItem(path+"/"+k, resourceType, ps, items[k])
+		}
+	case len(ps.Type) > 0:
+		v.checkPropertyType(path, resourceType, ps.Type, value)
+	}
+}
+
+func (v *templateValidator) checkItem(path string, resourceType string, ps *propertySpec, item interface{}) {
+	if isIntrinsic(item) {
+		return
+	}
+	if len(ps.PrimitiveItemType) > 0 {
+		if !isPrimitive(ps.PrimitiveItemType, item) {
+			v.problem(path, "expected %s, got: %v", ps.PrimitiveItemType, item)
+		}
+	} else if len(ps.ItemType) > 0 {
+		v.checkPropertyType(path, resourceType, ps.ItemType, item)
+	}
+}
+
+func (v *templateValidator) checkPropertyType(path string, resourceType string, typeName string, value interface{}) {
+	pt, ok := v.spec.PropertyTypes[resourceType+"."+typeName]
+	if !ok {
+		// shared property types, e.g. Tag
+		if pt, ok = v.spec.PropertyTypes[typeName]; !ok {
+			return
+		}
+	}
+	if pt.Properties == nil {
+		v.checkValue(path, resourceType, &pt.propertySpec, value)
+		return
+	}
+	v.checkProperties(path, resourceType, pt.Properties, value)
+}
+
+// isIntrinsic is true for intrinsic function values, e.g. {"Ref": "X"}, their type is only known when deploying
+func isIntrinsic(value interface{}) bool {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return false
+	}
+	for k := range m {
+		return k == "Ref" || k == "Condition" || strings.HasPrefix(k, "Fn::")
+	}
+	return false
+}
+
+func isPrimitive(primitiveType string, value interface{}) bool {
+	switch primitiveType {
+	case "String", "Timestamp":
+		switch value.(type) {
+		case string, int, int64, float64, bool:
+			return true
+		}
+		return false
+	case "Integer", "Long":
+		switch val := value.(type) {
+		case int, int64:
+			return true
+		case float64:
+			return val == math.Trunc(val)
+		case string:
+			_, err := strconv.ParseInt(val, 10, 64)
+			return err == nil
+		}
+		return false
+	case "Double":
+		switch val := value.(type) {
+		case int, int64, float64:
+			return true
+		case string:
+			_, err := strconv.ParseFloat(val, 64)
+			return err == nil
+		}
+		return false
+	case "Boolean":
+		switch val := value.(type) {
+		case bool:
+			return true
+		case string:
+			return val == "true" || val == "false"
+		}
+		return false
+	case "Json":
+		switch value.(type) {
+		case map[string]interface{}, []interface{}, string:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// checkReferences checks the Ref, Fn::GetAtt and Fn::Sub references of the value are defined
+func (v *templateValidator) checkReferences(path string, value interface{}) {
+	switch val := value.(type) {
+	case map[string]interface{}:
+		if len(val) == 1 {
+			if ref, ok := val["Ref"].(string); ok && !v.names[ref] {
+				v.problem(path, "Ref to undefined: %s", ref)
+			}
+			if getAtt, ok := val["Fn::GetAtt"]; ok {
+				v.checkGetAtt(path, getAtt)
+			}
+			if sub, ok := val["Fn::Sub"]; ok {
+				v.checkSub(path, sub)
+			}
+		}
+		for _, k := range mapKeys(val) {
+			v.checkReferences(path+"/"+k, val[k])
+		}
+	case []interface{}:
+		for i, item := range val {
+			v.checkReferences(fmt.Sprintf("%s/%d", path, i), item)
+		}
+	}
+}
+
+func (v *templateValidator) checkGetAtt(path string, getAtt interface{}) {
+	var resource, attribute string
+	switch val := getAtt.(type) {
+	case string:
+		parts := strings.SplitN(val, ".", 2)
+		resource = parts[0]
+		if len(parts) == 2 {
+			attribute = parts[1]
+		}
+	case []interface{}:
+		if len(val) != 2 {
+			v.problem(path, "Fn::GetAtt expects [resource, attribute], got: %v", val)
+			return
+		}
+		resource, _ = val[0].(string)
+		attribute, _ = val[1].(string) // may be an intrinsic function
+	}
+	v.checkAttribute(path, "Fn::GetAtt", resource, attribute)
+}
+
+func (v *templateValidator) checkAttribute(path string, function string, resource string, attribute string) {
+	resourceType, ok := v.resources[resource]
+	if !ok {
+		v.problem(path, "%s to undefined resource: %s", function, resource)
+		return
+	}
+	if v.spec == nil || len(attribute) == 0 || isCustomResource(resourceType) ||
+		(resourceType == "AWS::CloudFormation::Stack" && strings.HasPrefix(attribute, "Outputs.")) {
+		return
+	}
+	if rt, ok := v.spec.ResourceTypes[resourceType]; ok {
+		if _, ok := rt.Attributes[attribute]; !ok {
+			v.problem(path, "%s to unknown attribute of %s: %s.%s", function, resourceType, resource, attribute)
+		}
+	}
+}
+
+func (v *templateValidator) checkSub(path string, sub interface{}) {
+	str, ok := sub.(string)
+	vars := map[string]interface{}{}
+	if list, isList := sub.([]interface{}); isList && len(list) == 2 {
+		str, ok = list[0].(string)
+		vars = utils.ToStrMap(list[1])
+	}
+	if !ok {
+		return
+	}
+	for _, match := range subVariableRe.FindAllStringSubmatch(str, -1) {
+		name := strings.TrimSpace(match[1])
+		if _, local := vars[name]; local || v.names[name] {
+			continue
+		}
+		if parts := strings.SplitN(name, ".", 2); len(parts) == 2 {
+			v.checkAttribute(path, "Fn::Sub", parts[0], parts[1])
+			continue
+		}
+		v.problem(path, "Fn::Sub to undefined: %s", name)
+	}
+}
+
+func mapKeys(m interface{}) []string {
+	keys := []string{}
+	switch val := m.(type) {
+	case map[string]interface{}:
+		for k := range val {
+			keys = append(keys, k)
+		}
+	case map[string]*propertySpec:
+		for k := range val {
+			keys = append(keys, k)
+		}
+	case map[string]string:
+		for k := range val {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
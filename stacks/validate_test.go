@@ -0,0 +1,151 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package stacks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const validateTestTemplate = `Parameters:
+  Env:
+    Type: String
+Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketName: !Sub '${Env}-${AWS::Region}-bucket'
+      VersioningConfiguration:
+        Status: Enabled
+      Tags:
+        - Key: Env
+          Value: !Ref Env
+  Fn:
+    Type: AWS::Lambda::Function
+    Properties:
+      Code:
+        S3Bucket: !Ref Bucket
+        S3Key: fn.zip
+      Role: !GetAtt Bucket.Arn
+      MemorySize: 128
+      Environment:
+        QUEUE: !GetAtt [Queue, QueueName]
+  Queue:
+    Type: AWS::SQS::Queue
+    Properties:
+      DelaySeconds: '5'
+      FifoQueue: !If [IsProd, true, false]
+  Hook:
+    Type: Custom::Hook
+    Properties:
+      Anything: !GetAtt Hook.Whatever
+Outputs:
+  QueueUrl:
+    Value: !Ref Queue
+`
+
+func testResourceSpec(t *testing.T) *ResourceSpec {
+	spec, err := LoadResourceSpec(ResourcePath("resource_spec.json"))
+	assert.Nil(t, err)
+	return spec
+}
+
+func TestLoadResourceSpec(t *testing.T) {
+	spec := testResourceSpec(t)
+	assert.Equal(t, "test", spec.ResourceSpecificationVersion)
+	assert.True(t, spec.ResourceTypes["AWS::Lambda::Function"].Properties["Role"].Required)
+
+	b, err := ioutil.ReadFile(ResourcePath("resource_spec.json"))
+	assert.Nil(t, err)
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write(b)
+	w.Close()
+	f, err := ioutil.TempFile("", "spec")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.Write(buf.Bytes())
+	f.Close()
+	gzipped, err := LoadResourceSpec(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, spec, gzipped)
+
+	_, err = LoadResourceSpec(ResourcePath("stacks_include.json"))
+	assert.NotNil(t, err)
+}
+
+func TestValidateTemplate(t *testing.T) {
+	spec := testResourceSpec(t)
+	assert.Empty(t, validateTemplate(validateTestTemplate, spec, nil))
+
+	template := `{"Resources": {
+		"Fn": {"Type": "AWS::Lambda::Function", "Properties": {"Code": "src", "Handler": ["x"], "MemorySize": "lots", "Timeout": 3}},
+		"Bucket": {"Type": "AWS::S3::Bucket", "Properties": {"Tags": [{"Key": "Env"}], "VersioningConfiguration": "Enabled"}},
+		"Queue": {"Type": "AWS::SQS::Queu"},
+		"Alarm": {"Type": "AWS::SQS::Queue", "Properties": {"FifoQueue": "yes", "DelaySeconds": 1.5}}
+	},
+	"Outputs": {
+		"A": {"Value": {"Ref": "Missing"}},
+		"B": {"Value": {"Fn::GetAtt": ["Bucket", "Nope"]}},
+		"C": {"Value": {"Fn::GetAtt": "Gone.Arn"}},
+		"D": {"Value": {"Fn::Sub": ["${Fn.Arn}-${Local}-${Undefined}-${!Literal}", {"Local": "x"}]}}
+	}}`
+	assert.Equal(t, []string{
+		"Resources/Alarm/Properties/DelaySeconds: expected Integer, got: 1.5",
+		"Resources/Alarm/Properties/FifoQueue: expected Boolean, got: yes",
+		"Resources/Bucket/Properties/Tags/0: missing required property: Value",
+		"Resources/Bucket/Properties/VersioningConfiguration: expected properties, got: Enabled",
+		"Resources/Fn/Properties/Code: expected properties, got: src",
+		"Resources/Fn/Properties/Handler: expected String, got: [x]",
+		"Resources/Fn/Properties/MemorySize: expected Integer, got: lots",
+		"Resources/Fn/Properties: unknown property: Timeout",
+		"Resources/Fn/Properties: missing required property: Role",
+		"Resources/Queue: unknown resource type: AWS::SQS::Queu",
+		"Outputs/A/Value: Ref to undefined: Missing",
+		"Outputs/B/Value: Fn::GetAtt to unknown attribute of AWS::S3::Bucket: Bucket.Nope",
+		"Outputs/C/Value: Fn::GetAtt to undefined resource: Gone",
+		"Outputs/D/Value: Fn::Sub to undefined: Undefined",
+	}, validateTemplate(template, spec, nil))
+
+	// a local code path is packaged before deploying
+	problems := validateTemplate(template, spec, map[string]bool{"Resources/Fn/Properties/Code": true})
+	assert.NotContains(t, problems, "Resources/Fn/Properties/Code: expected properties, got: src")
+
+	// without a spec only the references are checked
+	assert.Equal(t, []string{
+		"Outputs/A/Value: Ref to undefined: Missing",
+		"Outputs/C/Value: Fn::GetAtt to undefined resource: Gone",
+		"Outputs/D/Value: Fn::Sub to undefined: Undefined",
+	}, validateTemplate(template, nil, nil))
+
+	assert.Equal(t, []string{"no Resources"}, validateTemplate("Parameters: {}", spec, nil))
+	assert.Equal(t, 1, len(validateTemplate("Resources: [", spec, nil)))
+	transformed := "Transform: AWS::Serverless-2016-10-31\nResources:\n  Api:\n    Type: AWS::Serverless::Function\n" +
+		"    Properties:\n      Role: !GetAtt ApiRole.Arn\n"
+	assert.Empty(t, validateTemplate(transformed, spec, nil))
+}
+
+func TestValidateStacks(t *testing.T) {
+	c := NewConfig(ResourcePath("bluegreen/stack_blue.yaml"), nil)
+	assert.Equal(t, 0, ValidateStacks(c.FetchEnvStacks("dev-techops"), nil))
+}
@@ -0,0 +1,108 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/capitalone/stack-deployment-tool/providers"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Store keeps the keys as objects under a bucket prefix
+type S3Store struct {
+	Bucket string
+	Prefix string
+
+	service *s3.S3
+}
+
+func NewS3Store(bucket, prefix string) *S3Store {
+	return &S3Store{Bucket: bucket, Prefix: prefix}
+}
+
+func (s *S3Store) S3Service() *s3.S3 {
+	if s.service == nil {
+		s.service = providers.NewAWSApi().S3Service()
+	}
+	return s.service
+}
+
+func (s *S3Store) Put(key string, data []byte) error {
+	_, err := s.S3Service().PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(s.Bucket),
+		Key:                  aws.String(joinKey(s.Prefix, key)),
+		Body:                 bytes.NewReader(data),
+		ContentType:          aws.String("application/json"),
+		ACL:                  aws.String(s3.ObjectCannedACLBucketOwnerFullControl),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+	return err
+}
+
+func (s *S3Store) Get(key string) ([]byte, error) {
+	resp, err := s.S3Service().GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(joinKey(s.Prefix, key)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (s *S3Store) List(prefix string) ([]string, error) {
+	keys := []string{}
+	base := joinKey(s.Prefix) + "/"
+	if len(s.Prefix) == 0 {
+		base = ""
+	}
+	err := s.S3Service().ListObjectsPages(&s3.ListObjectsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(base + prefix),
+	}, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.StringValue(obj.Key), base))
+		}
+		return true
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+func (s *S3Store) Delete(key string) error {
+	_, err := s.S3Service().DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(joinKey(s.Prefix, key)),
+	})
+	return err
+}
+
+func (s *S3Store) String() string {
+	return "s3://" + joinKey(s.Bucket, s.Prefix)
+}
@@ -0,0 +1,76 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+)
+
+// Store keeps the deploy state by key, in a local directory or under an S3 prefix.
+// Keys use / as the separator.
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	List(prefix string) ([]string, error) // keys under the prefix, sorted
+	Delete(key string) error
+	String() string
+}
+
+// New creates the store for a location: s3://bucket/prefix, or a directory relative to baseDir
+func New(location string, baseDir string) (Store, error) {
+	if strings.HasPrefix(location, "s3://") {
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		if len(u.Host) == 0 {
+			return nil, fmt.Errorf("bucket missing from: %s", location)
+		}
+		return NewS3Store(u.Host, strings.Trim(u.Path, "/")), nil
+	}
+	if len(location) == 0 {
+		return nil, errors.New("store location missing")
+	}
+	if !filepath.IsAbs(location) {
+		location = filepath.Join(baseDir, location)
+	}
+	return NewFileStore(location), nil
+}
+
+// IsNotFound is true for the error of a missing key
+func IsNotFound(err error) bool {
+	return err == ErrNotFound
+}
+
+func joinKey(parts ...string) string {
+	result := []string{}
+	for _, p := range parts {
+		if p = strings.Trim(p, "/"); len(p) > 0 {
+			result = append(result, p)
+		}
+	}
+	return strings.Join(result, "/")
+}
@@ -0,0 +1,85 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	s, err := New("s3://my-bucket/sdt/history/", "/base")
+	assert.Nil(t, err)
+	assert.Equal(t, "my-bucket", s.(*S3Store).Bucket)
+	assert.Equal(t, "sdt/history", s.(*S3Store).Prefix)
+	assert.Equal(t, "s3://my-bucket/sdt/history", s.String())
+
+	s, err = New("history", "/base")
+	assert.Nil(t, err)
+	assert.Equal(t, "/base/history", s.String())
+
+	s, err = New("/var/sdt", "/base")
+	assert.Nil(t, err)
+	assert.Equal(t, "/var/sdt", s.String())
+
+	_, err = New("s3:///prefix", "/base")
+	assert.NotNil(t, err)
+	_, err = New("", "/base")
+	assert.NotNil(t, err)
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := NewFileStore(filepath.Join(dir, "state"))
+	keys, err := s.List("")
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	_, err = s.Get("qa/elb/1.json")
+	assert.True(t, IsNotFound(err))
+
+	assert.Nil(t, s.Put("qa/elb/2.json", []byte("two")))
+	assert.Nil(t, s.Put("qa/elb/1.json", []byte("one")))
+	assert.Nil(t, s.Put("qa/server/1.json", []byte("server")))
+
+	b, err := s.Get("qa/elb/1.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "one", string(b))
+
+	keys, err = s.List("qa/elb/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"qa/elb/1.json", "qa/elb/2.json"}, keys)
+
+	assert.Nil(t, s.Delete("qa/elb/1.json"))
+	assert.True(t, IsNotFound(s.Delete("qa/elb/1.json")))
+	keys, err = s.List("qa/")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"qa/elb/2.json", "qa/server/1.json"}, keys)
+}
+
+func TestJoinKey(t *testing.T) {
+	assert.Equal(t, "sdt/qa/elb", joinKey("/sdt/", "", "qa/elb"))
+	assert.Equal(t, "", joinKey())
+}
@@ -0,0 +1,59 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	assert.Equal(t, "", UnifiedDiff("a", "b", "x\ny\n", "x\ny\n"))
+
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n18\n19\n20\n"
+	b := strings.Replace(strings.Replace(a, "2\n", "two\n", 1), "18\n", "", 1) + "21\n"
+	assert.Equal(t, `--- qa
++++ prod
+@@ -1,5 +1,5 @@
+ 1
+-2
++two
+ 3
+ 4
+ 5
+@@ -15,6 +15,6 @@
+ 15
+ 16
+ 17
+-18
+ 19
+ 20
++21
+`, UnifiedDiff("qa", "prod", a, b))
+
+	assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n", UnifiedDiff("a", "b", "", "x\ny"))
+	assert.Equal(t, "--- a\n+++ b\n@@ -1,2 +1 @@\n x\n-y\n", UnifiedDiff("a", "b", "x\ny", "x"))
+}
+
+func TestColorDiff(t *testing.T) {
+	assert.Equal(t, "", ColorDiff(""))
+	assert.Equal(t, "\x1b[1m--- a\x1b[0m\n\x1b[1m+++ b\x1b[0m\n\x1b[36m@@ -1,2 +1,2 @@\x1b[0m\n x\n\x1b[31m-y\x1b[0m\n\x1b[32m+z\x1b[0m\n",
+		ColorDiff(UnifiedDiff("a", "b", "x\ny", "x\nz")))
+}
@@ -39,6 +39,9 @@ var (
 
 	// Matches tag: "Fn::Base64": !Local::IncludeFileLines file_content.txt
 	valueTagRe = regexp.MustCompile(`!Local::IncludeFile(Lines)?[ ]+([[:ascii:]]+)`)
+
+	// Matches json: {"Fn::Local::IncludeFileLines": "file_content.txt"}
+	jsonIncludeRe = regexp.MustCompile(`"Fn::Local::IncludeFileLines"[ ]*:[ ]*"([^"]+)"`)
 )
 
 func init() {
@@ -74,6 +77,23 @@ func ApplyIncludeFileLinesDirective(reader io.Reader) []byte {
 	return output.Bytes()
 }
 
+// IncludedFiles lists the files included by the template, relative to the template
+func IncludedFiles(reader io.Reader) []string {
+	files := []string{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Split(CustomScanLines)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		for _, re := range []*regexp.Regexp{literalIncludeRe, valueTagRe, jsonIncludeRe} {
+			if m := re.FindSubmatch(line); m != nil {
+				files = append(files, strings.TrimSpace(string(m[len(m)-1])))
+				break
+			}
+		}
+	}
+	return files
+}
+
 func indentation(line []byte) int {
 	spaces := 0
 	for _, b := range line {
@@ -59,3 +59,10 @@ func TestApplyIncludeFileLinesDirective(t *testing.T) {
 		assert.Equal(t, expectedUserData, string(result))
 	})
 }
+
+func TestIncludedFiles(t *testing.T) {
+	f, err := os.Open("../resources/user-data.yaml")
+	assert.Nil(t, err)
+	defer f.Close()
+	assert.Equal(t, []string{"file_content.txt", "file_content.txt", "file_content.txt", "file_content.txt"}, IncludedFiles(f))
+}
@@ -0,0 +1,256 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	blockScalarRe = regexp.MustCompile(`(^|[ :-])[|>][-+0-9]*$`)
+
+	// the short form CloudFormation intrinsic function tags, and their long form keys
+	intrinsicTags = map[string]string{
+		"Ref": "Ref", "Condition": "Condition",
+		"And": "Fn::And", "Base64": "Fn::Base64", "Cidr": "Fn::Cidr", "Equals": "Fn::Equals",
+		"FindInMap": "Fn::FindInMap", "GetAZs": "Fn::GetAZs", "GetAtt": "Fn::GetAtt", "If": "Fn::If",
+		"ImportValue": "Fn::ImportValue", "Join": "Fn::Join", "Length": "Fn::Length", "Not": "Fn::Not",
+		"Or": "Fn::Or", "Select": "Fn::Select", "Split": "Fn::Split", "Sub": "Fn::Sub",
+		"ToJsonString": "Fn::ToJsonString", "Transform": "Fn::Transform",
+	}
+)
+
+// LongFormIntrinsics rewrites the short form intrinsic functions of a yaml template (!Ref X, !GetAtt A.B, ...)
+// to their long form ({"Ref": X}), the yaml decoder drops the tags of the short form.
+// Block scalars (!Sub |) keep their string, without the function.
+func LongFormIntrinsics(template string) string {
+	lines := strings.Split(template, "\n")
+	for i := 0; i < len(lines); i++ {
+		content, comment := splitComment(lines[i])
+		trimmed := strings.TrimRight(content, " \t")
+		tagAt, key := lastIntrinsicTag(trimmed)
+		switch {
+		case tagAt >= 0 && tagAt+len(key) == len(trimmed) && len(key) > 0:
+			// !Join followed by an indented block: nest the block under the long form key
+			lines = nestBlock(lines, i, trimmed[:tagAt], intrinsicTags[key[1:]], comment)
+		case tagAt >= 0 && isBlockScalar(trimmed[tagAt+len(key):]):
+			for tagAt >= 0 && isBlockScalar(trimmed[tagAt+len(key):]) {
+				trimmed = trimmed[:tagAt] + strings.TrimSpace(trimmed[tagAt+len(key):])
+				tagAt, key = lastIntrinsicTag(strings.TrimRight(trimmed[:strings.LastIndexAny(trimmed, "|>")], " \t"))
+			}
+			lines[i] = trimmed + comment
+		}
+		content, comment = splitComment(lines[i])
+		lines[i] = convertFlow(content, false) + comment
+		if blockScalarRe.MatchString(strings.TrimRight(content, " \t")) {
+			// the text of a block scalar is kept as it is
+			indent := len(content) - len(strings.TrimLeft(content, " "))
+			for i+1 < len(lines) && (len(strings.TrimSpace(lines[i+1])) == 0 ||
+				len(lines[i+1])-len(strings.TrimLeft(lines[i+1], " ")) > indent) {
+				i++
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isBlockScalar(value string) bool {
+	value = strings.TrimSpace(value)
+	return strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">")
+}
+
+// lastIntrinsicTag is the position and the tag of the intrinsic tag of the line followed by a block, or -1
+func lastIntrinsicTag(line string) (int, string) {
+	i := strings.LastIndex(line, "!")
+	if i < 0 || (i > 0 && line[i-1] != ' ' && line[i-1] != '\t') {
+		return -1, ""
+	}
+	rest := line[i+1:]
+	name := rest
+	if sp := strings.IndexAny(rest, " \t"); sp >= 0 {
+		name = rest[:sp]
+	}
+	if _, ok := intrinsicTags[name]; !ok {
+		return -1, ""
+	}
+	return i, "!" + name
+}
+
+// nestBlock replaces the tag of line i with the long form key, on a new line above the block following it
+func nestBlock(lines []string, i int, prefix string, key string, comment string) []string {
+	indent := len(prefix) - len(strings.TrimLeft(prefix, " "))
+	isItem := strings.HasPrefix(strings.TrimSpace(prefix), "- ") || strings.TrimSpace(prefix) == "-"
+	if isItem {
+		// the block of "- !Join" is indented past the dash
+		indent += strings.Index(prefix[indent:], "-") + 1
+	}
+	end := i + 1
+	minIndent := -1
+	for ; end < len(lines); end++ {
+		l := lines[end]
+		if len(strings.TrimSpace(l)) == 0 {
+			continue
+		}
+		li := len(l) - len(strings.TrimLeft(l, " "))
+		// a sequence may be at the indent of its key
+		if li < indent || (li == indent && !(!isItem && strings.HasPrefix(strings.TrimLeft(l, " "), "- "))) {
+			break
+		}
+		if minIndent < 0 || li < minIndent {
+			minIndent = li
+		}
+	}
+	for end > i+1 && len(strings.TrimSpace(lines[end-1])) == 0 {
+		end--
+	}
+	if minIndent < 0 {
+		lines[i] = prefix + "null" + comment
+		return lines
+	}
+	keyIndent := minIndent
+	if keyIndent <= indent {
+		keyIndent = indent + 2
+	}
+	shift := strings.Repeat(" ", keyIndent-minIndent+2)
+	nested := []string{strings.TrimRight(prefix, " \t") + comment, strings.Repeat(" ", keyIndent) + key + ":"}
+	for _, l := range lines[i+1 : end] {
+		if len(strings.TrimSpace(l)) > 0 {
+			l = shift + l
+		}
+		nested = append(nested, l)
+	}
+	result := append([]string{}, lines[:i]...)
+	result = append(result, nested...)
+	return append(result, lines[end:]...)
+}
+
+// splitComment splits a yaml line into its content and its comment
+func splitComment(line string) (string, string) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			if i == 0 || strings.IndexByte(" \t[{,:-", line[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i], line[i:]
+		}
+	}
+	return line, ""
+}
+
+// convertFlow rewrites the inline short form tags of a line or flow value
+func convertFlow(s string, inFlow bool) string {
+	out := strings.Builder{}
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			out.WriteByte(c)
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if c == '\'' || c == '"' {
+			quote = c
+			out.WriteByte(c)
+			continue
+		}
+		if c != '!' || (i > 0 && strings.IndexByte(" \t[{,:", s[i-1]) < 0) {
+			out.WriteByte(c)
+			continue
+		}
+		j := i + 1
+		for j < len(s) && strings.IndexByte(" \t,[]{}", s[j]) < 0 {
+			j++
+		}
+		key, ok := intrinsicTags[s[i+1:j]]
+		if !ok {
+			out.WriteByte(c)
+			continue
+		}
+		for j < len(s) && (s[j] == ' ' || s[j] == '\t') {
+			j++
+		}
+		end := flowValueEnd(s, j, inFlow)
+		value := strings.TrimRight(s[j:end], " \t")
+		switch {
+		case len(value) == 0:
+			value = "''"
+		case value[0] == '[' || value[0] == '{':
+			value = convertFlow(value, true)
+		case value[0] != '\'' && value[0] != '"':
+			value = "'" + strings.Replace(value, "'", "''", -1) + "'"
+		}
+		out.WriteString(`{"` + key + `": ` + value + `}` + s[j+len(strings.TrimRight(s[j:end], " \t")):end])
+		i = end - 1
+	}
+	return out.String()
+}
+
+// flowValueEnd is the end of the value starting at i: a bracketed or quoted value, or a plain one until the
+// end of the flow item (or line)
+func flowValueEnd(s string, i int, inFlow bool) int {
+	if i >= len(s) {
+		return i
+	}
+	switch s[i] {
+	case '[', '{':
+		depth := 0
+		var quote byte
+		for j := i; j < len(s); j++ {
+			c := s[j]
+			switch {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '\'' || c == '"':
+				quote = c
+			case c == '[' || c == '{':
+				depth++
+			case c == ']' || c == '}':
+				depth--
+				if depth == 0 {
+					return j + 1
+				}
+			}
+		}
+		return len(s)
+	case '\'', '"':
+		if end := strings.IndexByte(s[i+1:], s[i]); end >= 0 {
+			return i + end + 2
+		}
+		return len(s)
+	}
+	if !inFlow {
+		return len(s)
+	}
+	if end := strings.IndexAny(s[i:], ",]}"); end >= 0 {
+		return i + end
+	}
+	return len(s)
+}
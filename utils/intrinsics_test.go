@@ -0,0 +1,70 @@
+//
+// Copyright 2016 Capital One Services, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and limitations under the License.
+//
+// SPDX-Copyright: Copyright (c) Capital One Services, LLC
+// SPDX-License-Identifier: Apache-2.0
+//
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var shortFormTemplate = `Conditions:
+  IsProd: !Equals [!Ref Env, prod]
+Resources:
+  Fn:
+    Properties:
+      Role: !GetAtt Role.Arn # the role
+      AZ: !Select [0, !GetAZs '']
+      Name: !Join
+        - '-'
+        - - !Ref AWS::StackName
+          - fn
+      Other: !If
+      - IsProd
+      - !Sub '${AWS::Region}-x'
+      - !Ref AWS::NoValue
+      UserData: !Base64 !Sub |
+        #!/bin/bash
+        echo !Ref ${Env}
+      Items:
+        - !Join
+          - ''
+          - [a, b]
+`
+
+func TestLongFormIntrinsics(t *testing.T) {
+	doc, err := DecodeYAML([]byte(LongFormIntrinsics(shortFormTemplate)))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"Fn::Equals": []interface{}{map[string]interface{}{"Ref": "Env"}, "prod"}},
+		ToStrMap(doc["Conditions"])["IsProd"])
+
+	props := ToStrMap(ToStrMap(ToStrMap(doc["Resources"])["Fn"])["Properties"])
+	assert.Equal(t, map[string]interface{}{"Fn::GetAtt": "Role.Arn"}, props["Role"])
+	assert.Equal(t, map[string]interface{}{"Fn::Select": []interface{}{0, map[string]interface{}{"Fn::GetAZs": ""}}}, props["AZ"])
+	assert.Equal(t, map[string]interface{}{"Fn::Join": []interface{}{"-",
+		[]interface{}{map[string]interface{}{"Ref": "AWS::StackName"}, "fn"}}}, props["Name"])
+	assert.Equal(t, map[string]interface{}{"Fn::If": []interface{}{"IsProd",
+		map[string]interface{}{"Fn::Sub": "${AWS::Region}-x"}, map[string]interface{}{"Ref": "AWS::NoValue"}}}, props["Other"])
+	assert.Equal(t, "#!/bin/bash\necho !Ref ${Env}\n", props["UserData"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"Fn::Join": []interface{}{"", []interface{}{"a", "b"}}}}, props["Items"])
+}
+
+func TestLongFormIntrinsicsUnchanged(t *testing.T) {
+	template := "Resources:\n  Fn:\n    Properties:\n      Name: 'it''s !Ref x' # !Ref y\n      Tag: !Custom z\n"
+	assert.Equal(t, template, LongFormIntrinsics(template))
+}
@@ -19,7 +19,10 @@ package versioning
 
 import (
 	"bytes"
+	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -31,6 +34,25 @@ func GetBranch() string {
 	return result
 }
 
+// ciBranchVars are the env vars CI servers set to the branch built, their checkouts are detached
+var ciBranchVars = []string{"BRANCH_NAME", "GIT_BRANCH", "CI_COMMIT_REF_NAME", "GITHUB_HEAD_REF", "GITHUB_REF_NAME",
+	"TRAVIS_BRANCH", "CIRCLE_BRANCH", "DRONE_BRANCH", "BITBUCKET_BRANCH", "BUILDKITE_BRANCH"}
+
+// CurrentBranch is the checked out branch, or the branch of the CI env vars on a detached checkout,
+// empty when it is unknown
+func CurrentBranch() string {
+	branch := strings.TrimSpace(GetBranch())
+	if len(branch) > 0 && branch != "HEAD" {
+		return branch
+	}
+	for _, name := range ciBranchVars {
+		if val := strings.TrimSpace(os.Getenv(name)); len(val) > 0 {
+			return strings.TrimPrefix(val, "origin/")
+		}
+	}
+	return ""
+}
+
 func GitHash() string {
 	result, _ := Sh("git", "log", "-n", "1", "--pretty=format:%H")
 	log.Debugf("GitHash: %s\n", result)
@@ -43,6 +65,59 @@ func GitLatestTag() string {
 	return result
 }
 
+// GitTopLevel is the root directory of the git work tree containing dir
+func GitTopLevel(dir string) (string, error) {
+	result, err := Sh("git", "-C", dir, "rev-parse", "--show-toplevel")
+	return strings.TrimSpace(result), err
+}
+
+// GitChangedFiles lists the files of the work tree containing dir that differ from ref,
+// including untracked files, as absolute paths
+func GitChangedFiles(dir string, ref string) ([]string, error) {
+	top, err := GitTopLevel(dir)
+	if err != nil {
+		return nil, fmt.Errorf("not a git work tree: %s", dir)
+	}
+	diff, err := Sh("git", "-C", top, "diff", "--name-only", ref, "--")
+	if err != nil {
+		return nil, fmt.Errorf("cannot diff against git ref: %s", ref)
+	}
+	untracked, err := Sh("git", "-C", top, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, f := range strings.Split(diff+"\n"+untracked, "\n") {
+		if f = strings.TrimSpace(f); len(f) > 0 {
+			files = append(files, filepath.Join(top, f))
+		}
+	}
+	log.Debugf("GitChangedFiles: %s %v\n", ref, files)
+	return files, nil
+}
+
+// GitShowFile returns the content of the file at ref
+func GitShowFile(ref string, file string) ([]byte, error) {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	top, err := GitTopLevel(filepath.Dir(absFile))
+	if err != nil {
+		return nil, fmt.Errorf("not a git work tree: %s", file)
+	}
+	relFile, err := filepath.Rel(top, absFile)
+	if err != nil {
+		return nil, err
+	}
+	result, err := Sh("git", "-C", top, "show", ref+":"+filepath.ToSlash(relFile))
+	if err != nil {
+		return nil, fmt.Errorf("file: %s not found at git ref: %s", relFile, ref)
+	}
+	return []byte(result), nil
+}
+
 func MustSh(name string, arg ...string) string {
 	o, err := Sh(name, arg...)
 	if err != nil {
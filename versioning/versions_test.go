@@ -20,6 +20,7 @@ package versioning
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -58,3 +59,62 @@ func TestVersionWriting(t *testing.T) {
 	v2 := LoadVersionProps(f.Name())
 	assert.Equal(t, v.String(), v2.String())
 }
+
+func TestGitChangedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrepo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+
+	git := func(args ...string) {
+		_, err := Sh("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		assert.Nil(t, err)
+	}
+	git("init", "-q")
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a.yml"), []byte("a: 1\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "b.yml"), []byte("b: 1\n"), 0644))
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a.yml"), []byte("a: 2\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "c.yml"), []byte("c: 1\n"), 0644))
+
+	files, err := GitChangedFiles(dir, "HEAD")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "a.yml"), filepath.Join(dir, "c.yml")}, files)
+
+	content, err := GitShowFile("HEAD", filepath.Join(dir, "a.yml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "a: 1\n", string(content))
+
+	_, err = GitShowFile("HEAD", filepath.Join(dir, "c.yml"))
+	assert.NotNil(t, err)
+
+	_, err = GitChangedFiles(dir, "nosuchref")
+	assert.NotNil(t, err)
+}
+
+func TestCurrentBranch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrepo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	defer os.Chdir(wd)
+
+	git := func(args ...string) {
+		_, err := Sh("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		assert.Nil(t, err)
+	}
+	git("init", "-q")
+	git("checkout", "-q", "-b", "feature/x")
+	git("commit", "-q", "--allow-empty", "-m", "initial")
+	assert.Nil(t, os.Chdir(dir))
+	assert.Equal(t, "feature/x", CurrentBranch())
+
+	// CI checkouts are detached
+	git("checkout", "-q", "--detach")
+	defer os.Setenv("BRANCH_NAME", os.Getenv("BRANCH_NAME"))
+	os.Setenv("BRANCH_NAME", "origin/release/1.2")
+	assert.Equal(t, "release/1.2", CurrentBranch())
+}